@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hh_bot/jobfetcher"
+	"hh_bot/storage"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// applyJobs sends up to limit valid letters and returns how many were sent
+// and how many failed. Every application is recorded as sending before it
+// is posted, so it is never sent twice. Applications HH refuses, for
+// example because the vacancy needs a test, are recorded as rejected and
+// not sent again. Applications that never reached HH or that HH turned
+// away for the rate limit are retried by the next run. Any other failure,
+// such as a timeout, may have reached HH, so the application stays sending
+// until sync finds it there.
+func applyJobs(a *app, resumeID string, minScore float64, limit int, dryRun bool, stats *runStats) (int, int, error) {
+	applications, err := storage.LoadApplications(a.dbpool, minScore, limit)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var sent, failed int
+	for _, application := range applications {
+		if dryRun {
+			fmt.Printf("%s\t%.2f\t%s — %s\n", application.JobID, application.Score, application.Name, application.Employer)
+			continue
+		}
+
+		negotiation := storage.Negotiation{JobID: application.JobID, ResumeID: resumeID, State: storage.NegotiationSending}
+		if _, err := storage.SaveNegotiations(a.dbpool, []storage.Negotiation{negotiation}); err != nil {
+			return sent, failed, err
+		}
+
+		negotiation.State = storage.NegotiationSent
+		negotiation.NegotiationID, err = jobfetcher.Apply(a.client, a.conf.NegotiationsAPIURL, a.conf.JobAPIKey,
+			application.JobID, resumeID, application.CoverLetter)
+		if err != nil {
+			failed++
+			stats.add(func(run *storage.Run) { run.Failed++ })
+			var apiErr *jobfetcher.APIError
+			isAPIErr := errors.As(err, &apiErr)
+			switch {
+			case errors.Is(err, jobfetcher.ErrNotSent) || isAPIErr && apiErr.StatusCode == http.StatusTooManyRequests:
+				stats.logger().Warn("failed to apply, will retry", "vacancy_id", application.JobID, "err", err)
+				if err := storage.DeleteSendingNegotiation(a.dbpool, application.JobID); err != nil {
+					stats.logger().Warn("failed to forget the unsent application, it stays sending", "vacancy_id", application.JobID, "err", err)
+				}
+				continue
+			case !isAPIErr || !apiErr.Permanent():
+				stats.logger().Warn("failed to apply, HH may have received the application, it stays sending until sync",
+					"vacancy_id", application.JobID, "err", err)
+				continue
+			}
+			stats.logger().Warn("HH refused the application", "vacancy_id", application.JobID, "err", err)
+			negotiation.State = storage.NegotiationRejected
+			negotiation.Error = apiErr.Body
+		} else {
+			sent++
+			stats.add(func(run *storage.Run) { run.Saved++ })
+			fmt.Printf("Applied to job %s (%s — %s).\n", application.JobID, application.Name, application.Employer)
+		}
+
+		// The application stays sending when this fails, which keeps it
+		// from being sent again until sync brings its state from HH.
+		if _, err := storage.SaveNegotiations(a.dbpool, []storage.Negotiation{negotiation}); err != nil {
+			stats.logger().Warn("failed to record the application", "vacancy_id", application.JobID, "state", negotiation.State, "err", err)
+		}
+	}
+
+	if dryRun {
+		fmt.Printf("Would apply to %d job ads.\n", len(applications))
+	} else {
+		fmt.Printf("Applied to %d job ads, %d failed.\n", sent, failed)
+	}
+	return sent, failed, nil
+}
+
+// syncNegotiations pages through the user's negotiations on HH and stores
+// the state of those about stored vacancies.
+func syncNegotiations(a *app, stats *runStats) error {
+	var negotiations []storage.Negotiation
+	for page := 0; ; page++ {
+		response, err := jobfetcher.FetchNegotiations(a.client, a.conf.NegotiationsAPIURL, a.conf.JobAPIKey, page)
+		if err != nil {
+			return fmt.Errorf("failed to fetch negotiations: %w", err)
+		}
+		stats.add(func(run *storage.Run) { run.Pages++ })
+		for _, item := range response.Items {
+			n := storage.Negotiation{
+				JobID:         item.Vacancy.ID,
+				NegotiationID: item.ID,
+				State:         item.State.ID,
+				UpdatedAt:     time.Time(item.UpdatedAt),
+			}
+			if item.Resume != nil {
+				n.ResumeID = item.Resume.ID
+			}
+			negotiations = append(negotiations, n)
+		}
+		if page+1 >= response.Pages {
+			break
+		}
+	}
+
+	changed, err := storage.SaveNegotiations(a.dbpool, negotiations)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Synced %d negotiations, %d of them new or changed.\n", len(negotiations), changed)
+	stats.add(func(run *storage.Run) {
+		run.Saved += changed
+		run.Skipped += len(negotiations) - changed
+	})
+	return nil
+}
+
+// recheckJobs loads up to limit vacancies that have a letter waiting to be
+// sent and archives those that were closed on HH since they were fetched,
+// so apply skips them.
+func recheckJobs(a *app, limit int, stats *runStats) error {
+	applications, err := storage.LoadApplications(a.dbpool, 0, limit)
+	if err != nil {
+		return err
+	}
+
+	var closed []string
+	failed := 0
+	for _, application := range applications {
+		url := strings.TrimRight(a.conf.JobAPIURL, "/") + "/" + application.JobID
+		job, err := jobfetcher.FetchVacancy(a.client, url, a.conf.JobAPIKey)
+		var apiErr *jobfetcher.APIError
+		switch {
+		case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound:
+			closed = append(closed, application.JobID)
+		case err != nil:
+			failed++
+			stats.logger().Warn("failed to recheck job", "vacancy_id", application.JobID, "err", err)
+		case job.Archived:
+			closed = append(closed, application.JobID)
+		}
+	}
+
+	archived := 0
+	if len(closed) > 0 {
+		if archived, err = storage.ArchiveJobs(a.dbpool, closed); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Rechecked %d job ads, %d closed since fetched.\n", len(applications)-failed, archived)
+	stats.add(func(run *storage.Run) {
+		run.Details += len(applications) - failed
+		run.Saved += archived
+		run.Failed += failed
+	})
+	return partial(failed, len(applications), "rechecks")
+}
@@ -0,0 +1,1157 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"hh_bot/config"
+	"hh_bot/export"
+	"hh_bot/htmltext"
+	"hh_bot/migrations"
+	"hh_bot/processor"
+	"hh_bot/scheduler"
+	"hh_bot/storage"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Exit codes of hh_bot, so scripts and cron jobs can act on results.
+const (
+	exitOK      = 0
+	exitError   = 1 // the command could not do its work
+	exitUsage   = 2 // unknown command, bad flags or arguments
+	exitPartial = 3 // the command ran, but some vacancies, records or checks failed
+)
+
+// usageError is returned by commands for bad arguments.
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+// partialError reports a command that finished but failed on some items.
+type partialError struct {
+	failed, total int
+	what          string
+}
+
+func (e partialError) Error() string {
+	return fmt.Sprintf("%d of %d %s failed", e.failed, e.total, e.what)
+}
+
+// partial returns a partialError when any item failed.
+func partial(failed, total int, what string) error {
+	if failed == 0 {
+		return nil
+	}
+	return partialError{failed: failed, total: total, what: what}
+}
+
+// command is an hh_bot subcommand. setup registers the command's flags and
+// returns the function that runs it with the remaining arguments.
+type command struct {
+	name    string
+	args    string
+	summary string
+	setup   func(fs *flag.FlagSet) func(args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"fetch", "", "download new vacancies from HH and queue them for processing", fetchCommand},
+		{"process", "", "write cover letters for queued vacancies", processCommand},
+		{"reprocess", "", "regenerate already written letters, e.g. after a prompt change", reprocessCommand},
+		{"apply", "", "send valid cover letters to HH with your resume", applyCommand},
+		{"sync", "", "update the state of sent applications from HH", syncCommand},
+		{"recheck", "", "archive vacancies with unsent letters that were closed on HH", recheckCommand},
+		{"import", "PATH...", "import HH vacancy JSON files or directories without calling the API", importCommand},
+		{"export", "FILE", "export vacancies and letters to CSV, JSONL or Parquet (- for stdout)", exportCommand},
+		{"search", "QUERY... | add|list|enable|disable|remove [NAME|ID...]", "full-text or similarity search over stored vacancies, or manage the saved HH searches that fetch runs", searchCommand},
+		{"similar", "ID|FILE", "same as search -similar-to ID|FILE", similarCommand},
+		{"stats", "", "show queue and application counts", statsCommand},
+		{"runs", "show [ID]", "list recent runs of commands and daemon tasks, or summarize one", runsCommand},
+		{"requeue", "ID...|all", "give dead vacancies a fresh set of processing attempts", requeueCommand},
+		{"repair", "", "queue stored vacancies without a queue entry and drop orphaned entries", repairCommand},
+		{"embed", "", "embed stored vacancies for similarity search", embedCommand},
+		{"skills", "", "normalize the skills of stored vacancies and show the most common ones", skillsCommand},
+		{"dedup", "", "fingerprint stored vacancies and group near-duplicates", dedupCommand},
+		{"reclean", "", "re-convert stored descriptions from HTML", recleanCommand},
+		{"eval", "CONFIG", "compare prompt and model variants on a golden set of vacancies", evalCommand},
+		{"migrate", "up|down|status", "apply, revert or list database migrations", migrateCommand},
+		{"serve", "", "serve health and queue stats over HTTP", serveCommand},
+		{"daemon", "", "run fetch, process, sync and recheck on a cron schedule", daemonCommand},
+		{"doctor", "", "check configuration, storage and API access", doctorCommand},
+		{"config", "print", "show the effective settings and where they came from, with secrets redacted", configCommand},
+	}
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: hh_bot [-config FILE] [-set KEY=VALUE]... <command> [flags] [arguments]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nRun \"hh_bot help <command>\" for the flags of a command.\n")
+	fmt.Fprintf(w, "Settings come from defaults, -config (default .env), the environment and -set, later ones winning.\n")
+	fmt.Fprintf(w, "Exit codes: 0 success, 1 error, 2 usage error, 3 some vacancies, records or checks failed.\n")
+}
+
+func newFlagSet(cmd command, stderr io.Writer) (*flag.FlagSet, func(args []string) error) {
+	fs := flag.NewFlagSet("hh_bot "+cmd.name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	runCmd := cmd.setup(fs)
+	fs.Usage = func() {
+		synopsis := strings.TrimSpace(fmt.Sprintf("hh_bot %s [flags] %s", cmd.name, cmd.args))
+		fmt.Fprintf(fs.Output(), "Usage: %s\n\n%s.\n", synopsis, capitalize(cmd.summary))
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintf(fs.Output(), "\nFlags:\n")
+			fs.PrintDefaults()
+		}
+	}
+	return fs, runCmd
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// repeatedFlag collects the values of a flag given several times, such as
+// -set KEY=VALUE.
+type repeatedFlag []string
+
+func (s *repeatedFlag) String() string { return strings.Join(*s, " ") }
+
+func (s *repeatedFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// configSources are the global -config and -set flags of the current run.
+var configSources config.Sources
+
+// run executes the command named by args[0] and returns the exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+	global := flag.NewFlagSet("hh_bot", flag.ContinueOnError)
+	global.SetOutput(stderr)
+	global.Usage = func() { usage(global.Output()) }
+	configFile := global.String("config", "", "KEY=VALUE file with settings; .env is read if it exists")
+	var settings repeatedFlag
+	global.Var(&settings, "set", "override a setting, e.g. -set PROCESS_LIMIT=20")
+	if err := global.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			usage(stdout)
+			return exitOK
+		}
+		return exitUsage
+	}
+	configSources = config.Sources{File: *configFile, Flags: settings}
+	args = global.Args()
+
+	if len(args) == 0 {
+		usage(stderr)
+		return exitUsage
+	}
+
+	name := args[0]
+	if name == "help" {
+		if len(args) > 1 {
+			cmd, ok := findCommand(args[1])
+			if !ok {
+				fmt.Fprintf(stderr, "hh_bot: unknown command %q\n", args[1])
+				return exitUsage
+			}
+			fs, _ := newFlagSet(cmd, stdout)
+			fs.Usage()
+			return exitOK
+		}
+		usage(stdout)
+		return exitOK
+	}
+
+	cmd, ok := findCommand(name)
+	if !ok {
+		fmt.Fprintf(stderr, "hh_bot: unknown command %q\n\n", name)
+		usage(stderr)
+		return exitUsage
+	}
+
+	fs, runCmd := newFlagSet(cmd, stderr)
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	err := runCmd(fs.Args())
+	if err == nil {
+		return exitOK
+	}
+	fmt.Fprintf(stderr, "hh_bot %s: %v\n", name, err)
+
+	var usageErr usageError
+	var partialErr partialError
+	switch {
+	case errors.As(err, &usageErr):
+		fs.Usage()
+		return exitUsage
+	case errors.As(err, &partialErr):
+		return exitPartial
+	default:
+		return exitError
+	}
+}
+
+func noArgs(args []string) error {
+	if len(args) > 0 {
+		return usageError(fmt.Sprintf("unexpected arguments: %s", strings.Join(args, " ")))
+	}
+	return nil
+}
+
+// app holds what commands share once the configuration is loaded.
+type app struct {
+	conf   *config.Config
+	client *http.Client
+	repo   storage.Repository
+	// dbpool is only set for the postgres backend.
+	dbpool *pgxpool.Pool
+}
+
+// openApp loads the configuration and opens the storage backend. With
+// checkSchema, it refuses a Postgres database with pending migrations.
+func openApp(checkSchema bool) (*app, error) {
+	conf, client, repo, dbpool, err := initialize(configSources)
+	if err != nil {
+		return nil, fmt.Errorf("initialization failed: %w", err)
+	}
+	if checkSchema && dbpool != nil {
+		if err := migrations.Check(dbpool); err != nil {
+			repo.Close()
+			return nil, err
+		}
+	}
+	return &app{conf: conf, client: client, repo: repo, dbpool: dbpool}, nil
+}
+
+func (a *app) Close() error {
+	return a.repo.Close()
+}
+
+func (a *app) requirePostgres(feature string) error {
+	if a.dbpool == nil {
+		return fmt.Errorf("%s requires the postgres storage backend", feature)
+	}
+	return nil
+}
+
+// registerParser applies RESPONSE_FORMAT to the configured model.
+func (a *app) registerParser() error {
+	if a.conf.ResponseFormat == "" {
+		return nil
+	}
+	parser, err := processor.ParserByName(a.conf.ResponseFormat)
+	if err != nil {
+		return fmt.Errorf("invalid RESPONSE_FORMAT: %w", err)
+	}
+	processor.RegisterParser(a.conf.Model, parser)
+	return nil
+}
+
+func fetchCommand(fs *flag.FlagSet) func([]string) error {
+	queries := fs.String("queries", "", "comma-separated search queries to fetch instead of the saved searches")
+	search := fs.String("search", "", "name or id of the only saved search to fetch, even if disabled")
+	andProcess := fs.Bool("process", false, "also process the queue afterwards, e.g. for a dry run on the memory backend")
+
+	return func(args []string) error {
+		if err := noArgs(args); err != nil {
+			return err
+		}
+		if *queries != "" && *search != "" {
+			return usageError("-queries and -search exclude each other")
+		}
+		a, err := openApp(true)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+
+		required := config.HHKeys
+		if *andProcess {
+			required = slices.Concat(config.HHKeys, config.LLMKeys)
+		}
+		if err := a.conf.Require(required...); err != nil {
+			return err
+		}
+
+		if *search != "" {
+			if err := a.requirePostgres("-search"); err != nil {
+				return err
+			}
+		}
+		var jobQueries []string
+		if *queries != "" {
+			jobQueries = strings.Split(*queries, ",")
+		}
+		filter := storage.SavedSearchFilter{Ref: *search, EnabledOnly: *search == ""}
+		searches, err := fetchSearches(a.dbpool, jobQueries, filter)
+		if err != nil {
+			return err
+		}
+		if *search != "" && len(searches) == 0 {
+			return fmt.Errorf("no saved search %q", *search)
+		}
+
+		var opts storage.LeaseOptions
+		if *andProcess {
+			if opts, err = loadOptions(a.conf, "", 0); err != nil {
+				return usageError(err.Error())
+			}
+			if err := a.registerParser(); err != nil {
+				return err
+			}
+		}
+
+		mode := "fetch"
+		if *andProcess {
+			mode = "fetch -process"
+		}
+		return a.record(mode, func(stats *runStats) error {
+			failed, requests := fetchJobAds(a.client, a.repo, a.dbpool, searches, a.conf.JobAPIURL, a.conf.JobAPIKey, stats)
+			fetchErr := partial(failed, requests, "search requests")
+			if !*andProcess {
+				return fetchErr
+			}
+
+			stats.logger().Info("processing queued jobs")
+			done, failed, err := processQueue(a.repo, a.client, a.conf, opts, stats)
+			if err != nil {
+				return errors.Join(fetchErr, err)
+			}
+			return errors.Join(fetchErr, partial(failed, done+failed, "vacancies"))
+		})
+	}
+}
+
+func processCommand(fs *flag.FlagSet) func([]string) error {
+	order := fs.String("order", "", "order to process vacancies in: queue, newest or fit (most PROFILE_SKILLS matched); overrides PROCESS_ORDER")
+	maxJobs := fs.Int("max-jobs", 0, "maximum number of vacancies to process in this run; overrides PROCESS_LIMIT")
+
+	return func(args []string) error {
+		if err := noArgs(args); err != nil {
+			return err
+		}
+		a, err := openApp(true)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+
+		opts, err := loadOptions(a.conf, *order, *maxJobs)
+		if err != nil {
+			return usageError(err.Error())
+		}
+		if err := a.conf.Require(config.LLMKeys...); err != nil {
+			return err
+		}
+		if err := a.registerParser(); err != nil {
+			return err
+		}
+
+		return a.record("process", func(stats *runStats) error {
+			done, failed, err := processQueue(a.repo, a.client, a.conf, opts, stats)
+			if err != nil {
+				return err
+			}
+			return partial(failed, done+failed, "vacancies")
+		})
+	}
+}
+
+// reprocessBatchSize is how many vacancies reprocess loads at a time, so
+// that reprocessing every letter does not hold every vacancy in memory.
+const reprocessBatchSize = 100
+
+func reprocessCommand(fs *flag.FlagSet) func([]string) error {
+	var filter storage.ReprocessFilter
+	fs.StringVar(&filter.PromptHash, "prompt-hash", "", "reprocess letters produced by this prompt hash")
+	fs.StringVar(&filter.PromptVersion, "prompt-version", "", "reprocess letters produced by this prompt version")
+	fs.StringVar(&filter.Model, "model", "", "reprocess letters produced by this model")
+	fs.StringVar(&filter.Status, "status", "", "reprocess letters with this status: processed, unprocessed, valid, invalid")
+	since := fs.String("since", "", "reprocess letters produced on or after this date (YYYY-MM-DD)")
+	until := fs.String("until", "", "reprocess letters produced before this date (YYYY-MM-DD)")
+	order := fs.String("order", "", "order to reprocess vacancies in: queue, newest or fit; overrides PROCESS_ORDER")
+	maxJobs := fs.Int("max-jobs", 0, "maximum number of vacancies to reprocess in this run; overrides PROCESS_LIMIT")
+
+	return func(args []string) error {
+		if err := noArgs(args); err != nil {
+			return err
+		}
+		filter, err := reprocessFilter(filter, *since, *until)
+		if err != nil {
+			return usageError(err.Error())
+		}
+
+		a, err := openApp(true)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+		if err := a.requirePostgres("reprocess"); err != nil {
+			return err
+		}
+
+		opts, err := loadOptions(a.conf, *order, *maxJobs)
+		if err != nil {
+			return usageError(err.Error())
+		}
+		if err := a.conf.Require(config.LLMKeys...); err != nil {
+			return err
+		}
+		if err := a.registerParser(); err != nil {
+			return err
+		}
+
+		ids, err := storage.LoadJobIDsForReprocessing(a.dbpool, filter, opts)
+		if err != nil {
+			return fmt.Errorf("failed to load jobs for reprocessing: %w", err)
+		}
+
+		return a.record("reprocess", func(stats *runStats) error {
+			stats.logger().Info("reprocessing jobs", "jobs", len(ids), "prompt", processor.PromptHash(a.conf.SystemPrompt))
+			failed := 0
+			for start := 0; start < len(ids); start += reprocessBatchSize {
+				jobs, err := storage.LoadJobsByIDs(a.dbpool, ids[start:min(start+reprocessBatchSize, len(ids))])
+				if err != nil {
+					return fmt.Errorf("failed to load jobs for reprocessing: %w", err)
+				}
+				failed += processJobs(a.repo, a.client, a.conf, jobs, stats)
+			}
+			return partial(failed, len(ids), "vacancies")
+		})
+	}
+}
+
+func applyCommand(fs *flag.FlagSet) func([]string) error {
+	resumeID := fs.String("resume", "", "id of the resume to apply with; overrides RESUME_ID")
+	minScore := fs.Float64("min-score", 0, "only send letters with at least this validation score (0-1)")
+	maxJobs := fs.Int("max-jobs", 10, "maximum number of applications to send in this run")
+	dryRun := fs.Bool("dry-run", false, "list the letters that would be sent without sending them")
+
+	return func(args []string) error {
+		if err := noArgs(args); err != nil {
+			return err
+		}
+		if *maxJobs <= 0 {
+			return usageError("-max-jobs must be positive")
+		}
+		a, err := openApp(true)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+		if err := a.requirePostgres("apply"); err != nil {
+			return err
+		}
+
+		if *resumeID == "" {
+			*resumeID = a.conf.ResumeID
+		}
+		if *resumeID == "" && !*dryRun {
+			return usageError("no resume: set RESUME_ID or pass -resume")
+		}
+		if !*dryRun {
+			if err := a.conf.Require("JOB_API_KEY"); err != nil {
+				return err
+			}
+		}
+
+		if *dryRun {
+			_, _, err := applyJobs(a, *resumeID, *minScore, *maxJobs, true, nil)
+			return err
+		}
+		return a.record("apply", func(stats *runStats) error {
+			sent, failed, err := applyJobs(a, *resumeID, *minScore, *maxJobs, false, stats)
+			if err != nil {
+				return err
+			}
+			return partial(failed, sent+failed, "applications")
+		})
+	}
+}
+
+func syncCommand(fs *flag.FlagSet) func([]string) error {
+	return func(args []string) error {
+		if err := noArgs(args); err != nil {
+			return err
+		}
+		a, err := openApp(true)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+		if err := a.requirePostgres("sync"); err != nil {
+			return err
+		}
+		if err := a.conf.Require("JOB_API_KEY"); err != nil {
+			return err
+		}
+
+		return a.record("sync", func(stats *runStats) error {
+			return syncNegotiations(a, stats)
+		})
+	}
+}
+
+func recheckCommand(fs *flag.FlagSet) func([]string) error {
+	maxJobs := fs.Int("max-jobs", recheckBatchSize, "maximum number of vacancies to recheck")
+
+	return func(args []string) error {
+		if err := noArgs(args); err != nil {
+			return err
+		}
+		if *maxJobs <= 0 {
+			return usageError("-max-jobs must be positive")
+		}
+		a, err := openApp(true)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+		if err := a.requirePostgres("recheck"); err != nil {
+			return err
+		}
+		if err := a.conf.Require(config.HHKeys...); err != nil {
+			return err
+		}
+
+		return a.record("recheck", func(stats *runStats) error {
+			return recheckJobs(a, *maxJobs, stats)
+		})
+	}
+}
+
+func importCommand(fs *flag.FlagSet) func([]string) error {
+	return func(args []string) error {
+		if len(args) == 0 {
+			return usageError("no files or directories to import")
+		}
+		a, err := openApp(true)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+
+		return a.record("import", func(stats *runStats) error {
+			report, err := importJobAds(a.repo, a.dbpool, args, stats)
+			if err != nil {
+				return fmt.Errorf("import failed: %w", err)
+			}
+			return partial(len(report.Rejected), report.Records, "records")
+		})
+	}
+}
+
+func exportCommand(fs *flag.FlagSet) func([]string) error {
+	var overrides export.Preset
+	fs.StringVar(&overrides.Format, "format", "", "export format: csv, jsonl or parquet (default: from the file extension)")
+	fs.StringVar(&overrides.DateField, "date", "", "date the export range applies to: published or processed")
+	fs.StringVar(&overrides.Since, "since", "", "export vacancies on or after this date: YYYY-MM-DD, today, yesterday or -Nd")
+	fs.StringVar(&overrides.Until, "until", "", "export vacancies before this date: YYYY-MM-DD, today, yesterday or -Nd")
+	fs.StringVar(&overrides.Status, "status", "", "export vacancies with this processing status: pending, in_progress, done, failed, dead, valid, invalid")
+	fs.StringVar(&overrides.Query, "query", "", "export vacancies matching this full-text query")
+	minScore := fs.Float64("min-score", -1, "export letters with at least this validation score (0-1)")
+	columns := fs.String("columns", "", "comma-separated columns to export")
+	preset := fs.String("preset", "", "saved export preset to use, or \"list\" to show them")
+
+	return func(args []string) error {
+		if *preset == "list" {
+			if err := noArgs(args); err != nil {
+				return err
+			}
+			conf, err := config.Load(configSources)
+			if err != nil {
+				return err
+			}
+			return listExportPresets(conf)
+		}
+		if len(args) != 1 {
+			return usageError("expected one output file, or - for stdout")
+		}
+
+		a, err := openApp(true)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+		if err := a.requirePostgres("export"); err != nil {
+			return err
+		}
+
+		overrides.MinScore = minScore
+		if err := exportJobs(a.dbpool, a.conf, args[0], *preset, *columns, overrides); err != nil {
+			return fmt.Errorf("export failed: %w", err)
+		}
+		return nil
+	}
+}
+
+func searchCommand(fs *flag.FlagSet) func([]string) error {
+	var query storage.SearchQuery
+	fs.BoolVar(&query.Raw, "raw", false, "treat the query as raw tsquery syntax (&, |, !, <->)")
+	fs.IntVar(&query.MinSalary, "min-salary", 0, "only find vacancies paying at least this much")
+	fs.StringVar(&query.Currency, "currency", "RUR", "currency of -min-salary (HH code, e.g. RUR, USD, EUR)")
+	fs.StringVar(&query.Area, "area", "", "only find vacancies in this area (name or hh.ru id)")
+	fs.StringVar(&query.Experience, "experience", "", "only find vacancies with this experience (name or hh.ru id, e.g. between1And3)")
+	fs.StringVar(&query.Status, "status", "", "only find vacancies with this processing status: pending, in_progress, done, failed, dead, valid, invalid")
+	fs.IntVar(&query.Limit, "limit", 20, "maximum number of vacancies to show")
+	similarTo := fs.String("similar-to", "", "find vacancies similar to this stored vacancy id or file, such as a resume, instead of running a query")
+
+	saved := flag.NewFlagSet("hh_bot search", flag.ContinueOnError)
+	saved.SetOutput(fs.Output())
+	runSaved := savedSearchCommand(saved)
+
+	return func(args []string) error {
+		if *similarTo != "" {
+			if len(args) > 0 {
+				return usageError("-similar-to takes no query")
+			}
+			var others []string
+			fs.Visit(func(f *flag.Flag) {
+				if f.Name != "similar-to" && f.Name != "limit" {
+					others = append(others, "-"+f.Name)
+				}
+			})
+			if len(others) > 0 {
+				return usageError(fmt.Sprintf("-similar-to only combines with -limit, not %s", strings.Join(others, ", ")))
+			}
+			return similar(*similarTo, query.Limit)
+		}
+		if len(args) == 0 {
+			return usageError("no query: use \"quoted phrases\", or, -excluded words; or add, list, enable, disable or remove")
+		}
+		// A query starting with one of these words needs a flag first, as in
+		// "search -limit 20 list".
+		if slices.Contains(savedSearchActions, args[0]) && fs.NFlag() == 0 {
+			return runSaved(args)
+		}
+		query.Text = strings.Join(args, " ")
+
+		a, err := openApp(true)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+		if err := a.requirePostgres("search"); err != nil {
+			return err
+		}
+
+		if err := searchJobs(a.dbpool, query); err != nil {
+			return fmt.Errorf("search failed: %w", err)
+		}
+		return nil
+	}
+}
+
+// savedSearchActions are the subcommands of search that manage saved
+// searches rather than query stored vacancies.
+var savedSearchActions = []string{"add", "list", "enable", "disable", "remove"}
+
+// savedSearchCommand runs "search add|list|enable|disable|remove" with flags
+// of its own, which differ from those of the full-text search.
+func savedSearchCommand(fs *flag.FlagSet) func([]string) error {
+	query := fs.String("query", "", "add: HH search text; defaults to the name")
+	area := fs.String("area", "", "add: comma-separated HH area ids, e.g. 1 for Moscow")
+	experience := fs.String("experience", "noExperience,between1And3", "add: comma-separated HH experience ids; empty for any")
+	salary := fs.Int("salary", 0, "add: only vacancies paying at least this much")
+	var filters repeatedFlag
+	fs.Var(&filters, "filter", "add: any other HH search parameter as KEY=VALUE; may be repeated")
+	schedule := fs.String("cron", "", "add: cron expression for the daemon to fetch the search on; by default it runs with every fetch")
+	owner := fs.String("owner", "", "add: who the search belongs to; list: only their searches")
+	disabled := fs.Bool("disabled", false, "add: add the search disabled")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: hh_bot search add|list|enable|disable|remove [flags] [NAME|ID...]\n\nManage the saved HH searches that fetch runs.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+
+	return func(args []string) error {
+		// Flags follow the action, as in "search add -cron ... NAME".
+		action := args[0]
+		if err := fs.Parse(args[1:]); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil
+			}
+			return usageError(err.Error())
+		}
+		refs := fs.Args()
+		switch action {
+		case "add":
+			if len(refs) != 1 {
+				return usageError("expected the name of the new search")
+			}
+		case "list":
+			if err := noArgs(refs); err != nil {
+				return err
+			}
+		case "enable", "disable", "remove":
+			if len(refs) == 0 {
+				return usageError(fmt.Sprintf("expected the names or ids of the searches to %s", action))
+			}
+		}
+
+		search := storage.SavedSearch{Query: *query, Filters: url.Values{}, Schedule: *schedule, Owner: *owner, Enabled: !*disabled}
+		if action == "add" {
+			search.Name = refs[0]
+			if search.Query == "" {
+				search.Query = search.Name
+			}
+			if err := searchFilters(search.Filters, *area, *experience, *salary, filters); err != nil {
+				return usageError(err.Error())
+			}
+			if search.Schedule != "" {
+				if err := scheduler.CheckCron(search.Schedule); err != nil {
+					return usageError(err.Error())
+				}
+			}
+		}
+
+		a, err := openApp(true)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+		if err := a.requirePostgres("saved searches"); err != nil {
+			return err
+		}
+
+		switch action {
+		case "add":
+			id, err := storage.AddSavedSearch(a.dbpool, search)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Added search %s with id %d.\n", search.Name, id)
+		case "list":
+			return listSavedSearches(a.dbpool, *owner)
+		case "enable", "disable":
+			for _, ref := range refs {
+				if err := storage.SetSavedSearchEnabled(a.dbpool, ref, action == "enable"); err != nil {
+					return err
+				}
+				fmt.Printf("Search %s %sd.\n", ref, action)
+			}
+		case "remove":
+			for _, ref := range refs {
+				if err := storage.RemoveSavedSearch(a.dbpool, ref); err != nil {
+					return err
+				}
+				fmt.Printf("Search %s removed.\n", ref)
+			}
+		}
+		return nil
+	}
+}
+
+func similarCommand(fs *flag.FlagSet) func([]string) error {
+	limit := fs.Int("limit", 20, "maximum number of vacancies to show")
+
+	return func(args []string) error {
+		if len(args) != 1 {
+			return usageError("expected one vacancy id or file")
+		}
+		return similar(args[0], *limit)
+	}
+}
+
+// similar runs search -similar-to and its alias similar.
+func similar(target string, limit int) error {
+	a, err := openApp(true)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	if err := a.requirePostgres("similarity search"); err != nil {
+		return err
+	}
+
+	provider := newEmbeddingsProvider(a.conf, a.client)
+	if err := searchSimilar(a.dbpool, provider, a.conf.EmbeddingsPGVector, target, limit); err != nil {
+		return fmt.Errorf("similarity search failed: %w", err)
+	}
+	return nil
+}
+
+func statsCommand(fs *flag.FlagSet) func([]string) error {
+	dead := fs.Bool("dead", false, "also list the vacancies that exhausted their processing attempts")
+
+	return func(args []string) error {
+		if err := noArgs(args); err != nil {
+			return err
+		}
+		a, err := openApp(true)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+
+		return printStats(a, *dead)
+	}
+}
+
+func runsCommand(fs *flag.FlagSet) func([]string) error {
+	limit := fs.Int("limit", 20, "maximum number of runs to list")
+	problems := fs.Bool("problems", false, "list only runs that failed, partly failed or never finished")
+
+	return func(args []string) error {
+		if len(args) == 0 || args[0] != "show" {
+			return usageError("expected show")
+		}
+		if err := fs.Parse(args[1:]); err != nil {
+			return usageError(err.Error())
+		}
+		var id int64
+		switch rest := fs.Args(); len(rest) {
+		case 0:
+			if *limit <= 0 {
+				return usageError("-limit must be positive")
+			}
+		case 1:
+			n, err := strconv.ParseInt(rest[0], 10, 64)
+			if err != nil || n <= 0 {
+				return usageError(fmt.Sprintf("invalid run id %q", rest[0]))
+			}
+			id = n
+		default:
+			return usageError("expected at most one run id")
+		}
+
+		a, err := openApp(true)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+		if err := a.requirePostgres("runs"); err != nil {
+			return err
+		}
+
+		return showRuns(a.dbpool, id, *limit, *problems)
+	}
+}
+
+func requeueCommand(fs *flag.FlagSet) func([]string) error {
+	return func(args []string) error {
+		if len(args) == 0 {
+			return usageError("expected vacancy ids or \"all\"")
+		}
+		var ids []string
+		if len(args) != 1 || args[0] != "all" {
+			for _, arg := range args {
+				ids = append(ids, strings.Split(arg, ",")...)
+			}
+		}
+
+		a, err := openApp(true)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+
+		requeued, err := a.repo.RequeueJobs(ids)
+		if err != nil {
+			return fmt.Errorf("failed to requeue job ads: %w", err)
+		}
+		fmt.Printf("Requeued %d dead job ads.\n", requeued)
+		return nil
+	}
+}
+
+func repairCommand(fs *flag.FlagSet) func([]string) error {
+	return func(args []string) error {
+		if err := noArgs(args); err != nil {
+			return err
+		}
+		a, err := openApp(true)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+
+		slog.Info("repairing the processing queue")
+		report, err := a.repo.RepairQueue()
+		if err != nil {
+			return fmt.Errorf("failed to repair the processing queue: %w", err)
+		}
+		fmt.Printf("Queued %d job ads, removed %d orphaned queue entries.\n", report.Queued, report.Removed)
+		if a.dbpool == nil {
+			return nil
+		}
+
+		skilled, err := extractMissingSkills(a.dbpool)
+		if err != nil {
+			return err
+		}
+		fingerprinted, _, err := fingerprintStoredJobs(a.dbpool)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Extracted skills of %d and fingerprints of %d job ads.\n", skilled, fingerprinted)
+		return nil
+	}
+}
+
+func embedCommand(fs *flag.FlagSet) func([]string) error {
+	return func(args []string) error {
+		if err := noArgs(args); err != nil {
+			return err
+		}
+		a, err := openApp(true)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+		if err := a.requirePostgres("embed"); err != nil {
+			return err
+		}
+
+		provider := newEmbeddingsProvider(a.conf, a.client)
+		slog.Info("embedding job ads", "model", provider.Model())
+		embedded, err := embedStoredJobs(a.dbpool, provider, a.conf.EmbeddingsPGVector)
+		if err != nil {
+			return fmt.Errorf("failed to embed job ads: %w", err)
+		}
+		fmt.Printf("Embedded %d job ads.\n", embedded)
+		return nil
+	}
+}
+
+func skillsCommand(fs *flag.FlagSet) func([]string) error {
+	top := fs.Int("top", 20, "number of most common skills to show")
+
+	return func(args []string) error {
+		if err := noArgs(args); err != nil {
+			return err
+		}
+		a, err := openApp(true)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+		if err := a.requirePostgres("skills"); err != nil {
+			return err
+		}
+
+		slog.Info("normalizing skills")
+		if err := normalizeStoredSkills(a.dbpool, *top); err != nil {
+			return fmt.Errorf("failed to normalize skills: %w", err)
+		}
+		return nil
+	}
+}
+
+func dedupCommand(fs *flag.FlagSet) func([]string) error {
+	return func(args []string) error {
+		if err := noArgs(args); err != nil {
+			return err
+		}
+		a, err := openApp(true)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+		if err := a.requirePostgres("dedup"); err != nil {
+			return err
+		}
+
+		slog.Info("fingerprinting job ads")
+		clustered, duplicates, err := fingerprintStoredJobs(a.dbpool)
+		if err != nil {
+			return fmt.Errorf("failed to fingerprint job ads: %w", err)
+		}
+		fmt.Printf("Fingerprinted %d job ads, %d of them are duplicates.\n", clustered, duplicates)
+		return nil
+	}
+}
+
+func recleanCommand(fs *flag.FlagSet) func([]string) error {
+	return func(args []string) error {
+		if err := noArgs(args); err != nil {
+			return err
+		}
+		a, err := openApp(true)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+		if err := a.requirePostgres("reclean"); err != nil {
+			return err
+		}
+
+		slog.Info("re-converting job descriptions")
+		updated, err := storage.ReconvertDescriptions(a.dbpool, htmltext.ToMarkdown)
+		if err != nil {
+			return fmt.Errorf("failed to re-convert job descriptions: %w", err)
+		}
+		fmt.Printf("Re-converted %d job descriptions.\n", updated)
+		return nil
+	}
+}
+
+func evalCommand(fs *flag.FlagSet) func([]string) error {
+	return func(args []string) error {
+		if len(args) != 1 {
+			return usageError("expected one eval config")
+		}
+		a, err := openApp(true)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+		if err := a.requirePostgres("eval"); err != nil {
+			return err
+		}
+		if err := a.conf.Require(config.LLMKeys...); err != nil {
+			return err
+		}
+		if err := a.registerParser(); err != nil {
+			return err
+		}
+
+		if err := runEval(a.dbpool, a.client, a.conf, args[0]); err != nil {
+			return fmt.Errorf("eval failed: %w", err)
+		}
+		return nil
+	}
+}
+
+func migrateCommand(fs *flag.FlagSet) func([]string) error {
+	steps := fs.Int("steps", 1, "number of migrations to revert with down")
+
+	return func(args []string) error {
+		if len(args) != 1 {
+			return usageError("expected up, down or status")
+		}
+		switch args[0] {
+		case "up", "down", "status":
+		default:
+			return usageError(fmt.Sprintf("unknown migrate command %q, expected up, down or status", args[0]))
+		}
+
+		a, err := openApp(false)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+		if err := a.requirePostgres("migrate"); err != nil {
+			return err
+		}
+
+		if err := runMigrate(a.dbpool, args[0], *steps); err != nil {
+			return fmt.Errorf("migration failed: %w", err)
+		}
+		return nil
+	}
+}
+
+func serveCommand(fs *flag.FlagSet) func([]string) error {
+	addr := fs.String("addr", ":8080", "address to listen on")
+
+	return func(args []string) error {
+		if err := noArgs(args); err != nil {
+			return err
+		}
+		a, err := openApp(true)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+
+		return serve(a, *addr)
+	}
+}
+
+func daemonCommand(fs *flag.FlagSet) func([]string) error {
+	schedule := fs.String("schedule", "", "JSON file with the tasks to run; overrides DAEMON_SCHEDULE")
+	jitter := fs.Duration("jitter", time.Minute, "start every run up to this long after its cron time")
+	statusFile := fs.String("status-file", "", "file to keep the last and next runs of every task in as JSON")
+	addr := fs.String("addr", "", "also serve /healthz, /stats and /status on this address")
+
+	return func(args []string) error {
+		if err := noArgs(args); err != nil {
+			return err
+		}
+		if *jitter < 0 {
+			return usageError("-jitter must not be negative")
+		}
+		a, err := openApp(true)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+
+		if *schedule == "" {
+			*schedule = a.conf.DaemonSchedule
+		}
+		tasks, err := scheduler.LoadTasks(*schedule)
+		if err != nil {
+			return err
+		}
+		searches, err := searchTasks(a)
+		if err != nil {
+			return err
+		}
+		tasks = append(tasks, searches...)
+
+		return runDaemon(a, tasks, *jitter, *statusFile, *addr)
+	}
+}
+
+func doctorCommand(fs *flag.FlagSet) func([]string) error {
+	offline := fs.Bool("offline", false, "skip the checks that call the HH API")
+
+	return func(args []string) error {
+		if err := noArgs(args); err != nil {
+			return err
+		}
+		failed, total := doctor(*offline)
+		return partial(failed, total, "checks")
+	}
+}
+
+func configCommand(fs *flag.FlagSet) func([]string) error {
+	return func(args []string) error {
+		if len(args) != 1 || args[0] != "print" {
+			return usageError("expected print")
+		}
+		conf, err := config.Read(configSources)
+		if err != nil {
+			return err
+		}
+		if err := conf.Print(os.Stdout); err != nil {
+			return err
+		}
+		return conf.Validate()
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hh_bot/dedup"
+	"hh_bot/internal/pgtest"
+	"hh_bot/metrics"
+	"hh_bot/migrations"
+	"hh_bot/models"
+	"hh_bot/scheduler"
+	"hh_bot/storage"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRunExitCodes(t *testing.T) {
+	tests := []struct {
+		args []string
+		code int
+		out  string
+	}{
+		{nil, exitUsage, "Commands:"},
+		{[]string{"help"}, exitOK, "Exit codes:"},
+		{[]string{"help", "search"}, exitOK, "-min-salary"},
+		{[]string{"help", "nope"}, exitUsage, "unknown command"},
+		{[]string{"nope"}, exitUsage, "unknown command"},
+		{[]string{"process", "-h"}, exitOK, "-max-jobs"},
+		{[]string{"process", "-bogus"}, exitUsage, "flag provided but not defined"},
+		{[]string{"process", "extra"}, exitUsage, "unexpected arguments: extra"},
+		{[]string{"search"}, exitUsage, "no query"},
+		{[]string{"import"}, exitUsage, "no files"},
+		{[]string{"export", "a.csv", "b.csv"}, exitUsage, "expected one output file"},
+		{[]string{"migrate", "sideways"}, exitUsage, "unknown migrate command"},
+		{[]string{"reprocess", "-since", "yesterday"}, exitUsage, "invalid -since"},
+		{[]string{"apply", "-max-jobs", "0"}, exitUsage, "-max-jobs must be positive"},
+		{[]string{"daemon", "-jitter", "-1s"}, exitUsage, "-jitter must not be negative"},
+		{[]string{"-bogus", "stats"}, exitUsage, "flag provided but not defined"},
+		{[]string{"-h"}, exitOK, "Commands:"},
+		{[]string{"config"}, exitUsage, "expected print"},
+		{[]string{"fetch", "-queries", "ML", "-search", "ml"}, exitUsage, "exclude each other"},
+		{[]string{"help", "search"}, exitOK, "add|list|enable|disable|remove"},
+		{[]string{"search", "add"}, exitUsage, "expected the name"},
+		{[]string{"search", "add", "-h"}, exitOK, "-cron"},
+		{[]string{"search", "disable"}, exitUsage, "expected the names or ids"},
+		{[]string{"search", "add", "-cron", "hourly", "ML"}, exitUsage, "invalid cron"},
+		{[]string{"search", "add", "-filter", "page=2", "ML"}, exitUsage, "cannot set page"},
+		{[]string{"search", "list", "-min-salary", "1"}, exitUsage, "flag provided but not defined: -min-salary"},
+		{[]string{"help", "search"}, exitOK, "-similar-to"},
+		{[]string{"search", "-similar-to", "42", "golang"}, exitUsage, "-similar-to takes no query"},
+		{[]string{"search", "--similar-to", "42", "-raw"}, exitUsage, "-similar-to only combines with -limit, not -raw"},
+		{[]string{"similar"}, exitUsage, "expected one vacancy id or file"},
+		{[]string{"runs"}, exitUsage, "expected show"},
+		{[]string{"runs", "show", "last"}, exitUsage, "invalid run id"},
+		{[]string{"runs", "show", "-limit", "0"}, exitUsage, "-limit must be positive"},
+		{[]string{"-set", "STORAGE_BACKEND=memory", "-set", "PROCESS_WORKERS=x", "config", "print"}, exitError, `PROCESS_WORKERS: invalid number "x"`},
+		{[]string{"-config", "missing.env", "stats"}, exitError, "failed to read config file"},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		code := run(tt.args, &stdout, &stderr)
+		if code != tt.code {
+			t.Errorf("run(%q) = %d, want %d; stderr: %s", tt.args, code, tt.code, stderr.String())
+		}
+		if out := stdout.String() + stderr.String(); !strings.Contains(out, tt.out) {
+			t.Errorf("run(%q) output does not mention %q:\n%s", tt.args, tt.out, out)
+		}
+	}
+}
+
+func TestPartial(t *testing.T) {
+	if err := partial(0, 10, "vacancies"); err != nil {
+		t.Fatalf("partial with no failures = %v", err)
+	}
+	err := partial(2, 10, "vacancies")
+	if _, ok := err.(partialError); !ok || err.Error() != "2 of 10 vacancies failed" {
+		t.Fatalf("partial = %#v", err)
+	}
+}
+
+func TestRunStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, storage.RunOK},
+		{partial(1, 3, "vacancies"), storage.RunPartial},
+		{errors.Join(partial(1, 3, "search requests"), partial(2, 5, "vacancies")), storage.RunPartial},
+		{errors.Join(partial(1, 3, "search requests"), errors.New("queue unavailable")), storage.RunFailed},
+		{errors.New("queue unavailable"), storage.RunFailed},
+	}
+	for _, tt := range tests {
+		if got := runStatus(tt.err); got != tt.want {
+			t.Errorf("runStatus(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+
+	run := storage.Run{Status: storage.RunRunning, Pages: 3, LLMCalls: 2}
+	if got, want := runCounts(run), "pages 3, saved 0, failed 0, LLM calls 2"; got != want {
+		t.Errorf("runCounts = %s, want %s", got, want)
+	}
+	if got := runState(run); got != "RUNNING OR KILLED" {
+		t.Errorf("runState = %s", got)
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	var out bytes.Buffer
+	logger := newLogger(&out, "json", "warn").With("run_id", 7)
+	logger.Info("dropped")
+	logger.Warn("job failed", "vacancy_id", "123", "attempt", 2)
+
+	var record map[string]any
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatalf("want one JSON record, got %q: %v", out.String(), err)
+	}
+	if record["msg"] != "job failed" || record["level"] != "WARN" || record["run_id"] != 7.0 ||
+		record["vacancy_id"] != "123" || record["attempt"] != 2.0 {
+		t.Errorf("unexpected record %v", record)
+	}
+
+	out.Reset()
+	newLogger(&out, "text", "debug").Debug("HH request", "status", 200)
+	if got := out.String(); !strings.Contains(got, "level=DEBUG") || !strings.Contains(got, `msg="HH request" status=200`) {
+		t.Errorf("unexpected text record %q", got)
+	}
+}
+
+func TestRunStatsLogger(t *testing.T) {
+	var outside *runStats
+	if outside.logger() != slog.Default() {
+		t.Errorf("outside of a run the default logger must be used")
+	}
+
+	var out bytes.Buffer
+	stats := &runStats{log: newLogger(&out, "json", "info").With("run_id", 7)}
+	stats.logger().Info("job processed")
+	if !strings.Contains(out.String(), `"run_id":7`) {
+		t.Errorf("record does not carry the run_id: %s", out.String())
+	}
+}
+
+func TestDaemonKeys(t *testing.T) {
+	tests := []struct {
+		kinds []string
+		want  []string
+	}{
+		{[]string{scheduler.KindFetch, scheduler.KindSync}, []string{"JOB_API_KEY", "JOB_API_URL"}},
+		{[]string{scheduler.KindProcess}, []string{"LLM_API_KEY", "LLM_API_URL", "MODEL", "SYSTEM_PROMPT"}},
+		{[]string{scheduler.KindSync}, []string{"JOB_API_KEY"}},
+	}
+	for _, tt := range tests {
+		var tasks []scheduler.Task
+		for _, kind := range tt.kinds {
+			tasks = append(tasks, scheduler.Task{Name: kind, Kind: kind})
+		}
+		if got := daemonKeys(tasks); !slices.Equal(got, tt.want) {
+			t.Errorf("daemonKeys(%v) = %v, want %v", tt.kinds, got, tt.want)
+		}
+	}
+}
+
+func TestSearchFilters(t *testing.T) {
+	filters := url.Values{}
+	if err := searchFilters(filters, "1, 2", "between1And3", 200000, []string{"schedule=remote"}); err != nil {
+		t.Fatalf("searchFilters: %v", err)
+	}
+	search := storage.SavedSearch{Query: "Go developer", Filters: filters}
+	want := `"Go developer" area=1,2 experience=between1And3 only_with_salary=true salary=200000 schedule=remote`
+	if got := describeSearch(search); got != want {
+		t.Fatalf("describeSearch = %s, want %s", got, want)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	var hh *httptest.Server
+	hh = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/vacancies":
+			fmt.Fprintf(w, `{"found": 2, "items": [{"id": "1", "url": "%[1]s/vacancies/1"}, {"id": "2", "url": "%[1]s/vacancies/2"}]}`, hh.URL)
+		case "/vacancies/1":
+			fmt.Fprint(w, `{"id": "1", "name": "Go developer", "description": "<p>Go</p>"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer hh.Close()
+
+	counters := map[prometheus.Counter]float64{
+		metrics.HHRequests.WithLabelValues("200"):            3,
+		metrics.HHRequests.WithLabelValues("404"):            1,
+		metrics.Vacancies.WithLabelValues("fetch", "saved"):  1,
+		metrics.Vacancies.WithLabelValues("fetch", "failed"): 1,
+	}
+	before := map[prometheus.Counter]float64{}
+	for counter := range counters {
+		before[counter] = testutil.ToFloat64(counter)
+	}
+
+	repo := storage.NewMemory()
+	search := storage.SavedSearch{Name: "golang", Query: "golang"}
+	run, _ := fetchSearch(hh.Client(), repo, nil, dedup.NewIndex(nil), search, hh.URL+"/vacancies", "key", nil)
+	if run.New != 1 {
+		t.Fatalf("fetchSearch saved %d vacancies, want 1", run.New)
+	}
+
+	server := httptest.NewServer(newServeMux(&app{repo: repo}))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for counter, want := range counters {
+		if got := testutil.ToFloat64(counter) - before[counter]; got != want {
+			t.Errorf("%s grew by %v, want %v", counter.Desc(), got, want)
+		}
+	}
+
+	for _, want := range []string{
+		`hh_bot_search_found{query="golang"} 2`,
+		`hh_bot_queue_jobs{state="pending"} 1`,
+		`hh_bot_queue_jobs{state="dead"} 0`,
+	} {
+		if !strings.Contains(string(body), want+"\n") {
+			t.Errorf("metrics do not contain %s", want)
+		}
+	}
+}
+
+func TestIngestRepeatedJob(t *testing.T) {
+	dbpool := pgtest.Open(t)
+	if _, err := migrations.Up(dbpool); err != nil {
+		t.Fatalf("migrations.Up: %v", err)
+	}
+	repo := storage.NewPostgres(dbpool)
+
+	job := models.JobAd{ID: "1", Name: "Go developer", Descrtiption: "<p>Go and Postgres</p>"}
+	if _, err := ingestJobs(repo, dbpool, dedup.NewIndex(nil), []models.JobAd{job, job}, nil); err != nil {
+		t.Fatalf("ingestJobs: %v", err)
+	}
+
+	fingerprints, err := storage.LoadFingerprints(dbpool)
+	if err != nil {
+		t.Fatalf("LoadFingerprints: %v", err)
+	}
+	if len(fingerprints) != 1 || !fingerprints[0].Canonical {
+		t.Fatalf("fingerprints = %+v, want one canonical", fingerprints)
+	}
+	leases, err := repo.LeaseJobs(storage.LeaseOptions{Limit: 10}, storage.DefaultRetryPolicy())
+	if err != nil {
+		t.Fatalf("LeaseJobs: %v", err)
+	}
+	if len(leases) != 1 || leases[0].Job.ID != "1" {
+		t.Fatalf("leases = %+v, want job 1", leases)
+	}
+}
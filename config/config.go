@@ -1,33 +1,404 @@
 package config
 
 import (
-	"github.com/joho/godotenv"
-	"log"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
 	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/joho/godotenv"
 )
 
+// Every setting is read from the key in its env tag. Values come from, in
+// increasing priority, the default tag, the config file, the environment
+// and -set flags; empty values count as unset. Settings tagged secret are
+// redacted by Print; "url" only hides the password of a URL.
 type Config struct {
-	JobAPIURL    string
-	JobAPIKey    string
-	LLMAPIURL    string
-	LLMAPIKey    string
-	DatabaseURL  string
-	Model        string
-	SystemPrompt string
+	JobAPIURL   string `env:"JOB_API_URL"`
+	JobAPIKey   string `env:"JOB_API_KEY" secret:"true"`
+	LLMAPIURL   string `env:"LLM_API_URL"`
+	LLMAPIKey   string `env:"LLM_API_KEY" secret:"true"`
+	DatabaseURL string `env:"DATABASE_URL" secret:"url"`
+	// StorageBackend is postgres (default), sqlite or memory.
+	StorageBackend string   `env:"STORAGE_BACKEND" default:"postgres" oneof:"postgres sqlite memory"`
+	SQLitePath     string   `env:"SQLITE_PATH" default:"hh_bot.db"`
+	Model          string   `env:"MODEL"`
+	SystemPrompt   string   `env:"SYSTEM_PROMPT"`
+	ResponseFormat string   `env:"RESPONSE_FORMAT"`
+	PromptVersion  string   `env:"PROMPT_VERSION"`
+	Temperature    *float64 `env:"TEMPERATURE"`
+
+	// HTTPTimeout limits every request to HH and the LLM API.
+	HTTPTimeout time.Duration `env:"HTTP_TIMEOUT" default:"20s" positive:"true"`
+
+	// LogFormat is text (default) or json, and LogLevel the least severe
+	// level logged: debug, info (default), warn or error.
+	LogFormat string `env:"LOG_FORMAT" default:"text" oneof:"text json"`
+	LogLevel  string `env:"LOG_LEVEL" default:"info" oneof:"debug info warn error"`
+
+	EmbeddingsAPIURL   string `env:"EMBEDDINGS_API_URL"`
+	EmbeddingsAPIKey   string `env:"EMBEDDINGS_API_KEY" secret:"true"`
+	EmbeddingsModel    string `env:"EMBEDDINGS_MODEL"`
+	EmbeddingsPGVector bool   `env:"EMBEDDINGS_PGVECTOR"`
+
+	// NegotiationsAPIURL and ResumeID are used to apply to vacancies with
+	// the resume of the JobAPIKey user.
+	NegotiationsAPIURL string `env:"NEGOTIATIONS_API_URL" default:"https://api.hh.ru/negotiations"`
+	ResumeID           string `env:"RESUME_ID"`
+
+	// QueueMaxAttempts is how many times a job is processed before it is
+	// moved to the dead-letter state. Failed jobs wait QueueBaseDelay,
+	// doubled on every attempt up to QueueMaxDelay, and a lease that is not
+	// finished within QueueLeaseTimeout is handed out again.
+	QueueMaxAttempts  int           `env:"QUEUE_MAX_ATTEMPTS" default:"5" positive:"true"`
+	QueueBaseDelay    time.Duration `env:"QUEUE_BASE_DELAY" default:"1m" positive:"true"`
+	QueueMaxDelay     time.Duration `env:"QUEUE_MAX_DELAY" default:"6h" positive:"true"`
+	QueueLeaseTimeout time.Duration `env:"QUEUE_LEASE_TIMEOUT" default:"10m" positive:"true"`
+
+	// ProcessOrder is the order jobs are processed in: queue (default),
+	// newest or fit. ProcessLimit caps the jobs processed in one run, and
+	// ProfileSkills are the skills the fit order matches vacancies against.
+	// ProcessWorkers jobs are processed at once.
+	ProcessOrder   string   `env:"PROCESS_ORDER" oneof:"queue newest fit"`
+	ProcessLimit   int      `env:"PROCESS_LIMIT"`
+	ProfileSkills  []string `env:"PROFILE_SKILLS"`
+	ProcessWorkers int      `env:"PROCESS_WORKERS" default:"1" positive:"true"`
+
+	// ExportPresets is an optional JSON file with export presets that
+	// extend and override the built-in ones.
+	ExportPresets string `env:"EXPORT_PRESETS"`
+
+	// DaemonSchedule is an optional JSON file with the daemon's tasks that
+	// replaces the built-in schedule.
+	DaemonSchedule string `env:"DAEMON_SCHEDULE"`
+
+	LetterMinLength  int      `env:"LETTER_MIN_LENGTH"`
+	LetterMaxLength  int      `env:"LETTER_MAX_LENGTH"`
+	BannedPhrases    []string `env:"BANNED_PHRASES"`
+	RequiredMentions []string `env:"REQUIRED_MENTIONS"`
+
+	// sources records the layer every setting came from. problems and
+	// invalid keep what could not be parsed for Validate.
+	sources  map[string]string
+	problems []string
+	invalid  map[string]bool
+}
+
+// Keys that commands calling HH or the LLM need besides storage settings.
+var (
+	HHKeys  = []string{"JOB_API_URL", "JOB_API_KEY"}
+	LLMKeys = []string{"LLM_API_URL", "LLM_API_KEY", "MODEL", "SYSTEM_PROMPT"}
+)
+
+// Sources are the layers above the defaults and the environment.
+type Sources struct {
+	// File is a KEY=VALUE file such as .env. When empty, .env in the
+	// working directory is read if there is one.
+	File string
+	// Flags are KEY=VALUE settings from the command line.
+	Flags []string
+}
+
+// ValidationError lists every missing or invalid setting.
+type ValidationError struct {
+	Problems []string
 }
 
-func LoadConfig() *Config {
-	err := godotenv.Load()
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+type field struct {
+	key      string
+	def      string
+	secret   string
+	positive bool
+	oneof    []string
+	index    int
+}
+
+var fields = func() []field {
+	t := reflect.TypeOf(Config{})
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag
+		if tag.Get("env") == "" {
+			continue
+		}
+		fields = append(fields, field{
+			key:      tag.Get("env"),
+			def:      tag.Get("default"),
+			secret:   tag.Get("secret"),
+			positive: tag.Get("positive") == "true",
+			oneof:    strings.Fields(tag.Get("oneof")),
+			index:    i,
+		})
+	}
+	return fields
+}()
+
+func findField(key string) (field, bool) {
+	for _, f := range fields {
+		if f.key == key {
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+// Load reads and validates the configuration.
+func Load(sources Sources) (*Config, error) {
+	c, err := Read(sources)
 	if err != nil {
-		log.Fatal("Error loading .env file\n", err)
-	}
-	return &Config{
-		JobAPIURL:    os.Getenv("JOB_API_URL"),
-		JobAPIKey:    os.Getenv("JOB_API_KEY"),
-		LLMAPIURL:    os.Getenv("LLM_API_URL"),
-		LLMAPIKey:    os.Getenv("LLM_API_KEY"),
-		DatabaseURL:  os.Getenv("DATABASE_URL"),
-		Model:        os.Getenv("MODEL"),
-		SystemPrompt: os.Getenv("SYSTEM_PROMPT"),
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Read merges the layers without validating them, so an invalid
+// configuration can still be printed. It only fails when the config file
+// cannot be read.
+func Read(sources Sources) (*Config, error) {
+	file, err := readFile(sources.File)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Config{sources: make(map[string]string), invalid: make(map[string]bool)}
+	flags := make(map[string]string)
+	for _, setting := range sources.Flags {
+		key, value, ok := strings.Cut(setting, "=")
+		if !ok {
+			c.problems = append(c.problems, fmt.Sprintf("-set %s: expected KEY=VALUE", setting))
+			continue
+		}
+		if _, ok := findField(key); !ok {
+			c.problems = append(c.problems, fmt.Sprintf("-set %s: unknown setting %s", setting, key))
+			continue
+		}
+		flags[key] = value
+	}
+
+	v := reflect.ValueOf(c).Elem()
+	for _, f := range fields {
+		value, source := f.def, "default"
+		if s := file[f.key]; s != "" {
+			value, source = s, "file"
+		}
+		if s := os.Getenv(f.key); s != "" {
+			value, source = s, "env"
+		}
+		if s, ok := flags[f.key]; ok {
+			value, source = s, "flag"
+		}
+		if value == "" {
+			source = "unset"
+		}
+		c.sources[f.key] = source
+
+		if err := setValue(v.Field(f.index), value); err != nil {
+			c.problems = append(c.problems, fmt.Sprintf("%s: %v (from %s)", f.key, err, source))
+			c.invalid[f.key] = true
+		}
+	}
+
+	return c, nil
+}
+
+func readFile(path string) (map[string]string, error) {
+	optional := path == ""
+	if optional {
+		path = ".env"
+	}
+	values, err := godotenv.Read(path)
+	if optional && errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	return values, nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func setValue(v reflect.Value, value string) error {
+	if value == "" {
+		v.SetZero()
+		return nil
+	}
+
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q, want e.g. 30s or 5m", value)
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(value)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q, want true or false", value)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Pointer && v.Type().Elem().Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		v.Set(reflect.ValueOf(&f))
+	case v.Kind() == reflect.Slice:
+		// Lists are separated by semicolons, as skills and phrases may
+		// contain commas.
+		var list []string
+		for _, item := range strings.Split(value, ";") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func formatValue(v reflect.Value) string {
+	switch {
+	case v.IsZero():
+		return ""
+	case v.Type() == durationType:
+		return time.Duration(v.Int()).String()
+	case v.Kind() == reflect.Pointer:
+		return strconv.FormatFloat(v.Elem().Float(), 'g', -1, 64)
+	case v.Kind() == reflect.Slice:
+		return strings.Join(v.Interface().([]string), ";")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// Validate reports every setting that could not be parsed or is out of
+// range, and the storage settings the backend needs.
+func (c *Config) Validate() error {
+	problems := slices.Clone(c.problems)
+
+	v := reflect.ValueOf(c).Elem()
+	for _, f := range fields {
+		if c.invalid[f.key] {
+			continue
+		}
+		value := v.Field(f.index)
+		switch value.Kind() {
+		case reflect.Int, reflect.Int64:
+			if value.Int() < 0 {
+				problems = append(problems, fmt.Sprintf("%s must not be negative", f.key))
+			} else if f.positive && value.Int() == 0 {
+				problems = append(problems, fmt.Sprintf("%s must be positive", f.key))
+			}
+		case reflect.String:
+			if s := value.String(); s != "" && len(f.oneof) > 0 && !slices.Contains(f.oneof, s) {
+				problems = append(problems, fmt.Sprintf("%s: unknown value %q, want one of %s", f.key, s, strings.Join(f.oneof, ", ")))
+			}
+		}
+	}
+
+	switch c.StorageBackend {
+	case "postgres":
+		if c.DatabaseURL == "" {
+			problems = append(problems, "DATABASE_URL is not set; the postgres backend needs it")
+		}
+	case "sqlite":
+		if c.SQLitePath == "" {
+			problems = append(problems, "SQLITE_PATH is not set; the sqlite backend needs it")
+		}
+	}
+	if c.Temperature != nil && (*c.Temperature < 0 || *c.Temperature > 2) {
+		problems = append(problems, "TEMPERATURE must be between 0 and 2")
+	}
+	if c.LetterMinLength > 0 && c.LetterMaxLength > 0 && c.LetterMinLength > c.LetterMaxLength {
+		problems = append(problems, "LETTER_MIN_LENGTH is greater than LETTER_MAX_LENGTH")
+	}
+	if c.QueueBaseDelay > c.QueueMaxDelay {
+		problems = append(problems, "QUEUE_BASE_DELAY is greater than QUEUE_MAX_DELAY")
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// Require reports every key that is not set, for commands that need more
+// than storage.
+func (c *Config) Require(keys ...string) error {
+	v := reflect.ValueOf(c).Elem()
+	var problems []string
+	for _, key := range keys {
+		f, ok := findField(key)
+		if !ok {
+			panic("config: unknown key " + key)
+		}
+		if v.Field(f.index).IsZero() {
+			problems = append(problems, key+" is not set")
+		}
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// Print writes every setting with the layer it came from. Secrets are
+// redacted.
+func (c *Config) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	v := reflect.ValueOf(c).Elem()
+	for _, f := range fields {
+		value := formatValue(v.Field(f.index))
+		if value != "" {
+			value = redact(f.secret, value)
+		}
+		source := c.sources[f.key]
+		if source == "" {
+			source = "unset"
+		}
+		fmt.Fprintf(tw, "%s=%s\t# %s\n", f.key, value, source)
+	}
+	return tw.Flush()
+}
+
+func redact(secret, value string) string {
+	switch secret {
+	case "":
+		return value
+	case "url":
+		// Only the password of a URL is hidden; a key=value connection
+		// string may hold one anywhere.
+		u, err := url.Parse(value)
+		if err != nil || u.Scheme == "" || u.Query().Has("password") {
+			return "xxxxx"
+		}
+		return u.Redacted()
+	default:
+		return "xxxxx"
 	}
 }
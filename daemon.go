@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hh_bot/config"
+	"hh_bot/scheduler"
+	"hh_bot/storage"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+)
+
+// daemonLockID keeps two daemons from working on the same database; it
+// differs from the lock migrations take.
+const daemonLockID = 0x68685f626f7464
+
+// recheckBatchSize is how many vacancies a recheck looks at per run.
+const recheckBatchSize = 100
+
+// runDaemon runs the scheduled tasks until SIGINT or SIGTERM. The status of
+// the tasks is written to statusFile after every change and served on
+// /status when addr is set.
+func runDaemon(a *app, tasks []scheduler.Task, jitter time.Duration, statusFile, addr string) error {
+	for _, task := range tasks {
+		if task.Kind == scheduler.KindSync || task.Kind == scheduler.KindRecheck {
+			if err := a.requirePostgres(fmt.Sprintf("task %s", task.Name)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := a.conf.Require(daemonKeys(tasks)...); err != nil {
+		return err
+	}
+
+	var opts storage.LeaseOptions
+	if slices.ContainsFunc(tasks, func(task scheduler.Task) bool { return task.Kind == scheduler.KindProcess }) {
+		var err error
+		if opts, err = loadOptions(a.conf, "", 0); err != nil {
+			return usageError(err.Error())
+		}
+		if err := a.registerParser(); err != nil {
+			return err
+		}
+	}
+
+	s, err := scheduler.New(tasks, jitter)
+	if err != nil {
+		return err
+	}
+	if statusFile != "" {
+		s.OnChange = func(status scheduler.Status) {
+			if err := scheduler.WriteStatusFile(statusFile, status); err != nil {
+				slog.Warn("failed to write status file", "path", statusFile, "err", err)
+			}
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if a.dbpool != nil {
+		release, ok, err := storage.TryLock(ctx, a.dbpool, daemonLockID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("another daemon is already running on this database")
+		}
+		defer release()
+	} else {
+		slog.Warn("no single-instance lock on this backend; do not start a second daemon", "backend", a.conf.StorageBackend)
+	}
+
+	serveErr := make(chan error, 1)
+	if addr != "" {
+		mux := newServeMux(a)
+		mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(s.Status())
+		})
+		go func() {
+			err := listen(ctx, addr, mux)
+			if err != nil {
+				// The daemon stops rather than run without its endpoint.
+				stop()
+			}
+			serveErr <- err
+		}()
+	} else {
+		serveErr <- nil
+	}
+
+	slog.Info("daemon started", "tasks", len(tasks))
+	s.Run(ctx, func(ctx context.Context, task scheduler.Task) error {
+		return runTask(a, task, opts)
+	})
+	slog.Info("daemon stopped")
+
+	return <-serveErr
+}
+
+// daemonKeys returns the settings the tasks need, the same ones their
+// commands require.
+func daemonKeys(tasks []scheduler.Task) []string {
+	var keys []string
+	for _, task := range tasks {
+		switch task.Kind {
+		case scheduler.KindFetch, scheduler.KindRecheck:
+			keys = append(keys, config.HHKeys...)
+		case scheduler.KindProcess:
+			keys = append(keys, config.LLMKeys...)
+		case scheduler.KindSync:
+			keys = append(keys, "JOB_API_KEY")
+		}
+	}
+	slices.Sort(keys)
+	return slices.Compact(keys)
+}
+
+// searchTasks schedules the enabled saved searches that have a schedule of
+// their own. Searches added or scheduled later are picked up on restart.
+func searchTasks(a *app) ([]scheduler.Task, error) {
+	if a.dbpool == nil {
+		return nil, nil
+	}
+	searches, err := storage.LoadSavedSearches(a.dbpool, storage.SavedSearchFilter{EnabledOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	var tasks []scheduler.Task
+	for _, search := range searches {
+		if search.Schedule == "" {
+			continue
+		}
+		tasks = append(tasks, scheduler.Task{
+			Name:   "search " + search.Name,
+			Kind:   scheduler.KindFetch,
+			Cron:   search.Schedule,
+			Search: search.Name,
+		})
+	}
+	return tasks, nil
+}
+
+// runTask runs a task as a recorded run named after it.
+func runTask(a *app, task scheduler.Task, opts storage.LeaseOptions) error {
+	return a.record("daemon "+task.Name, func(stats *runStats) error {
+		return runTaskKind(a, task, opts, stats)
+	})
+}
+
+func runTaskKind(a *app, task scheduler.Task, opts storage.LeaseOptions, stats *runStats) error {
+	switch task.Kind {
+	case scheduler.KindFetch:
+		// A task of a saved search runs it by name; other fetch tasks run
+		// their queries or the enabled searches without a schedule.
+		filter := storage.SavedSearchFilter{Ref: task.Search, EnabledOnly: true, Unscheduled: task.Search == ""}
+		searches, err := fetchSearches(a.dbpool, task.Queries, filter)
+		if err != nil {
+			return err
+		}
+		failed, requests := fetchJobAds(a.client, a.repo, a.dbpool, searches, a.conf.JobAPIURL, a.conf.JobAPIKey, stats)
+		return partial(failed, requests, "search requests")
+	case scheduler.KindProcess:
+		done, failed, err := processQueue(a.repo, a.client, a.conf, opts, stats)
+		if err != nil {
+			return err
+		}
+		return partial(failed, done+failed, "vacancies")
+	case scheduler.KindSync:
+		return syncNegotiations(a, stats)
+	case scheduler.KindRecheck:
+		return recheckJobs(a, recheckBatchSize, stats)
+	default:
+		return errors.New("unknown task " + task.Kind)
+	}
+}
@@ -0,0 +1,128 @@
+package dedup
+
+import (
+	"hash/fnv"
+	"hh_bot/models"
+	"math/bits"
+	"strings"
+	"unicode"
+)
+
+// MaxDistance is the largest Hamming distance between two SimHash
+// fingerprints that still counts as the same vacancy.
+const MaxDistance = 3
+
+const (
+	shingleSize = 3
+	bands       = MaxDistance + 1
+	bandBits    = 64 / bands
+)
+
+// Fingerprint computes a 64-bit SimHash over word shingles of the cleaned
+// description, with the title and employer added as heavier features.
+func Fingerprint(job *models.JobAd) uint64 {
+	var weights [64]int
+
+	add := func(feature string, weight int) {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+		for i := range 64 {
+			if sum&(1<<i) != 0 {
+				weights[i] += weight
+			} else {
+				weights[i] -= weight
+			}
+		}
+	}
+
+	words := tokenize(job.Descrtiption)
+	for i := 0; i+shingleSize <= len(words); i++ {
+		add(strings.Join(words[i:i+shingleSize], " "), 1)
+	}
+	if len(words) < shingleSize && len(words) > 0 {
+		add(strings.Join(words, " "), 1)
+	}
+
+	titleWeight := max(1, len(words)/10)
+	add("title:"+strings.Join(tokenize(job.Name), " "), titleWeight)
+	add("employer:"+strings.Join(tokenize(job.Employer.Name), " "), titleWeight)
+
+	var fingerprint uint64
+	for i, weight := range weights {
+		if weight > 0 {
+			fingerprint |= 1 << i
+		}
+	}
+	return fingerprint
+}
+
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// Index assigns vacancies to duplicate clusters. Fingerprints are split into
+// MaxDistance+1 bands; two fingerprints within MaxDistance bits always share
+// at least one band, so only candidates from matching bands are compared.
+type Index struct {
+	entries []models.Fingerprint
+	bands   [bands]map[uint64][]int
+}
+
+func NewIndex(entries []models.Fingerprint) *Index {
+	idx := &Index{}
+	for i := range idx.bands {
+		idx.bands[i] = make(map[uint64][]int)
+	}
+	for _, entry := range entries {
+		idx.insert(entry)
+	}
+	return idx
+}
+
+// Add places a vacancy into the cluster of its nearest duplicate, or starts
+// a new cluster with the vacancy as the canonical member.
+func (idx *Index) Add(jobID string, simhash uint64) models.Fingerprint {
+	entry := models.Fingerprint{JobID: jobID, SimHash: simhash, ClusterID: jobID, Canonical: true}
+
+	if match, ok := idx.nearest(simhash); ok {
+		entry.ClusterID = match.ClusterID
+		entry.Canonical = false
+	}
+
+	idx.insert(entry)
+	return entry
+}
+
+func (idx *Index) nearest(simhash uint64) (models.Fingerprint, bool) {
+	best, bestDistance := -1, MaxDistance+1
+	for band := range bands {
+		for _, i := range idx.bands[band][bandKey(simhash, band)] {
+			if d := Distance(simhash, idx.entries[i].SimHash); d < bestDistance {
+				best, bestDistance = i, d
+			}
+		}
+	}
+	if best < 0 {
+		return models.Fingerprint{}, false
+	}
+	return idx.entries[best], true
+}
+
+func (idx *Index) insert(entry models.Fingerprint) {
+	idx.entries = append(idx.entries, entry)
+	for band := range bands {
+		key := bandKey(entry.SimHash, band)
+		idx.bands[band][key] = append(idx.bands[band][key], len(idx.entries)-1)
+	}
+}
+
+func bandKey(simhash uint64, band int) uint64 {
+	return (simhash >> (band * bandBits)) & (1<<bandBits - 1)
+}
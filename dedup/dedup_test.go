@@ -0,0 +1,46 @@
+package dedup_test
+
+import (
+	"hh_bot/dedup"
+	"hh_bot/models"
+	"testing"
+)
+
+const description = `Мы ищем ML-инженера в команду рекомендаций. Обязанности: обучение и внедрение моделей ранжирования,
+проведение A/B-тестов, оптимизация инференса. Требования: Python, PyTorch, SQL, опыт работы с Spark и Airflow,
+понимание классических алгоритмов машинного обучения. Условия: удалённая работа, ДМС, гибкий график.`
+
+func newJob(id, name, employer, description string) *models.JobAd {
+	job := &models.JobAd{ID: id, Name: name, Descrtiption: description}
+	job.Employer.Name = employer
+	return job
+}
+
+func TestFingerprintNearDuplicates(t *testing.T) {
+	original := newJob("1", "ML Engineer", "Acme", description)
+	repost := newJob("2", "ML Engineer", "Acme", description+" Откликайтесь!")
+	other := newJob("3", "Data Analyst", "Globex", "Ищем аналитика данных: Excel, Power BI, SQL, построение отчётности для бизнеса.")
+
+	if d := dedup.Distance(dedup.Fingerprint(original), dedup.Fingerprint(repost)); d > dedup.MaxDistance {
+		t.Fatalf("expected repost to be a near-duplicate, distance %d", d)
+	}
+	if d := dedup.Distance(dedup.Fingerprint(original), dedup.Fingerprint(other)); d <= dedup.MaxDistance {
+		t.Fatalf("expected different vacancies to differ, distance %d", d)
+	}
+}
+
+func TestIndexClusters(t *testing.T) {
+	idx := dedup.NewIndex([]models.Fingerprint{
+		{JobID: "1", SimHash: 0xF0F0, ClusterID: "1", Canonical: true},
+	})
+
+	dup := idx.Add("2", 0xF0F1)
+	if dup.Canonical || dup.ClusterID != "1" {
+		t.Fatalf("expected job 2 to join cluster 1, got %+v", dup)
+	}
+
+	fresh := idx.Add("3", ^uint64(0xF0F0))
+	if !fresh.Canonical || fresh.ClusterID != "3" {
+		t.Fatalf("expected job 3 to start its own cluster, got %+v", fresh)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"hh_bot/config"
+	"hh_bot/export"
+	"hh_bot/jobfetcher"
+	"hh_bot/migrations"
+	"hh_bot/processor"
+	"net/url"
+	"slices"
+)
+
+// doctorCheck returns a short detail on success.
+type doctorCheck struct {
+	name string
+	run  func() (string, error)
+}
+
+// doctor prints the result of every check and returns how many failed, out
+// of how many ran. Checks that need storage are skipped when it does not
+// open.
+func doctor(offline bool) (int, int) {
+	a, openErr := openApp(false)
+	if a != nil {
+		defer a.Close()
+	}
+
+	checks := []doctorCheck{
+		{"storage", func() (string, error) {
+			if openErr != nil {
+				return "", openErr
+			}
+			if _, err := a.repo.QueueStats(); err != nil {
+				return "", err
+			}
+			return a.conf.StorageBackend, nil
+		}},
+	}
+	if openErr == nil {
+		checks = append(checks, doctorChecks(a, offline)...)
+	}
+
+	failed := 0
+	for _, check := range checks {
+		detail, err := check.run()
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL  %s: %v\n", check.name, err)
+			continue
+		}
+		if detail != "" {
+			fmt.Printf("ok    %s: %s\n", check.name, detail)
+		} else {
+			fmt.Printf("ok    %s\n", check.name)
+		}
+	}
+
+	return failed, len(checks)
+}
+
+func doctorChecks(a *app, offline bool) []doctorCheck {
+	conf := a.conf
+
+	checks := []doctorCheck{
+		{"settings", func() (string, error) {
+			return "", conf.Require(slices.Concat(config.HHKeys, config.LLMKeys)...)
+		}},
+		{"response format", func() (string, error) {
+			if conf.ResponseFormat == "" {
+				return processor.ParserForModel(conf.Model).Name() + " (from the model)", nil
+			}
+			parser, err := processor.ParserByName(conf.ResponseFormat)
+			if err != nil {
+				return "", err
+			}
+			return parser.Name(), nil
+		}},
+		{"processing order", func() (string, error) {
+			opts, err := loadOptions(conf, "", 0)
+			if err != nil {
+				return "", err
+			}
+			if opts.Order == "" {
+				return "queue", nil
+			}
+			return opts.Order, nil
+		}},
+		{"export presets", func() (string, error) {
+			presets, err := export.LoadPresets(conf.ExportPresets)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%d presets", len(presets)), nil
+		}},
+		{"resume", func() (string, error) {
+			if conf.ResumeID == "" {
+				return "RESUME_ID not set, apply needs -resume", nil
+			}
+			return conf.ResumeID, nil
+		}},
+	}
+
+	if a.dbpool != nil {
+		checks = append(checks, doctorCheck{"migrations", func() (string, error) {
+			if err := migrations.Check(a.dbpool); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("version %d", migrations.Latest()), nil
+		}})
+	}
+
+	if !offline {
+		checks = append(checks, doctorCheck{"HH API", func() (string, error) {
+			params := url.Values{}
+			params.Add("text", "Go")
+			params.Add("per_page", "1")
+			response, err := jobfetcher.FetchJobs(a.client, conf.JobAPIURL+"?"+params.Encode(), conf.JobAPIKey)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%d vacancies found for a test query", response.Found), nil
+		}})
+	}
+
+	return checks
+}
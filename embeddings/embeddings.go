@@ -0,0 +1,189 @@
+package embeddings
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Provider turns texts into embedding vectors.
+type Provider interface {
+	Model() string
+	Embed(texts []string) ([][]float32, error)
+}
+
+// HTTPProvider calls an OpenAI-compatible /embeddings endpoint.
+type HTTPProvider struct {
+	client *http.Client
+	apiKey string
+	apiURL string
+	model  string
+}
+
+func NewHTTPProvider(client *http.Client, apiKey, apiURL, model string) *HTTPProvider {
+	return &HTTPProvider{client: client, apiKey: apiKey, apiURL: apiURL, model: model}
+}
+
+type embeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type embeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+func (p *HTTPProvider) Model() string { return p.model }
+
+func (p *HTTPProvider) Embed(texts []string) ([][]float32, error) {
+	payload, err := json.Marshal(embeddingRequest{Model: p.model, Input: texts})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request payload: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.apiURL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.apiKey)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, body)
+	}
+
+	var embeddingResp embeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&embeddingResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if len(embeddingResp.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(embeddingResp.Data))
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, item := range embeddingResp.Data {
+		if item.Index < 0 || item.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding index %d is out of range", item.Index)
+		}
+		vectors[item.Index] = item.Embedding
+	}
+
+	return vectors, nil
+}
+
+// HashingProvider is a local stand-in that embeds texts with the hashing
+// trick over lowercased words. It needs no network access and is
+// deterministic, which makes it suitable for tests and offline setups.
+type HashingProvider struct {
+	Dimensions int
+}
+
+func (p HashingProvider) Model() string {
+	return fmt.Sprintf("hashing-%d", p.dimensions())
+}
+
+func (p HashingProvider) Embed(texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector := make([]float32, p.dimensions())
+		words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, word := range words {
+			h := fnv.New32a()
+			h.Write([]byte(word))
+			sum := h.Sum32()
+			sign := float32(1)
+			if sum&1 == 1 {
+				sign = -1
+			}
+			vector[int(sum>>1)%len(vector)] += sign
+		}
+		vectors[i] = Normalize(vector)
+	}
+	return vectors, nil
+}
+
+func (p HashingProvider) dimensions() int {
+	if p.Dimensions <= 0 {
+		return 256
+	}
+	return p.Dimensions
+}
+
+func Normalize(vector []float32) []float32 {
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		return vector
+	}
+	norm = math.Sqrt(norm)
+	for i := range vector {
+		vector[i] = float32(float64(vector[i]) / norm)
+	}
+	return vector
+}
+
+func Cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+type Match struct {
+	JobID string
+	Score float64
+}
+
+// Rank orders candidates by cosine similarity to the query, best first, and
+// returns at most limit matches.
+func Rank(query []float32, candidates map[string][]float32, limit int) []Match {
+	matches := make([]Match, 0, len(candidates))
+	for id, vector := range candidates {
+		matches = append(matches, Match{JobID: id, Score: Cosine(query, vector)})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score == matches[j].Score {
+			return matches[i].JobID < matches[j].JobID
+		}
+		return matches[i].Score > matches[j].Score
+	})
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
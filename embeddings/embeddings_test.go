@@ -0,0 +1,53 @@
+package embeddings_test
+
+import (
+	"encoding/json"
+	"hh_bot/embeddings"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRankWithHashingProvider(t *testing.T) {
+	provider := embeddings.HashingProvider{}
+	vectors, err := provider.Embed([]string{
+		"ML engineer: Python, PyTorch, model training and deployment",
+		"Machine learning engineer, PyTorch and Python, deployment of models",
+		"Accountant: 1C, tax reporting, payroll",
+	})
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+
+	matches := embeddings.Rank(vectors[0], map[string][]float32{
+		"ml":         vectors[1],
+		"accountant": vectors[2],
+	}, 1)
+	if len(matches) != 1 || matches[0].JobID != "ml" {
+		t.Fatalf("expected the ML vacancy to rank first, got %+v", matches)
+	}
+}
+
+func TestHTTPProvider(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer key" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data": [{"index": 1, "embedding": [0, 1]}, {"index": 0, "embedding": [1, 0]}]}`))
+	}))
+	defer mockServer.Close()
+
+	provider := embeddings.NewHTTPProvider(&http.Client{Timeout: 5 * time.Second}, "key", mockServer.URL, "test-model")
+	vectors, err := provider.Embed([]string{"first", "second"})
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+
+	got, _ := json.Marshal(vectors)
+	if string(got) != "[[1,0],[0,1]]" {
+		t.Fatalf("embeddings are not ordered by index: %s", got)
+	}
+}
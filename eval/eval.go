@@ -0,0 +1,260 @@
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"hh_bot/models"
+	"hh_bot/processor"
+	"hh_bot/validator"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const DefaultRubric = `You are reviewing a cover letter written for a vacancy.
+Score it from 1 to 10 for how specific it is to the vacancy, how well it matches the required skills, and how natural and professional it sounds.
+Answer with a JSON object {"score": <1-10>, "reason": "<one sentence>"} and nothing else.`
+
+type Config struct {
+	// Golden lists the ids of stored vacancies every variant is run against.
+	Golden   []string     `json:"golden"`
+	Judge    *JudgeConfig `json:"judge"`
+	Variants []Variant    `json:"variants"`
+}
+
+type JudgeConfig struct {
+	Model  string `json:"model"`
+	Rubric string `json:"rubric"`
+}
+
+type Variant struct {
+	Name        string   `json:"name"`
+	Model       string   `json:"model"`
+	Prompt      string   `json:"prompt"`
+	PromptFile  string   `json:"prompt_file"`
+	Temperature *float64 `json:"temperature"`
+	// Prices are in USD per million tokens.
+	InputPrice  float64 `json:"input_price_per_million"`
+	OutputPrice float64 `json:"output_price_per_million"`
+}
+
+type Result struct {
+	Variant    string
+	Model      string
+	PromptHash string
+	Jobs       int
+	Failed     int
+	Valid      int
+	CheckScore float64
+	// Regenerations counts the letters written after the first ones
+	// failed validation, and ValidAfterRegeneration the vacancies whose
+	// letter passed once regenerated. They do not count towards the
+	// scores, tokens, cost or latency, which describe the first letters.
+	Regenerations          int
+	ValidAfterRegeneration int
+	JudgeScore             float64
+	Judged                 int
+	PromptTokens           int
+	CompletionTokens       int
+	Cost                   float64
+	MeanLatency            time.Duration
+	P95Latency             time.Duration
+}
+
+// LoadConfig reads an eval config. Prompt files are resolved relative to the
+// config file.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read eval config: %w", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to decode eval config: %w", err)
+	}
+
+	if len(cfg.Variants) == 0 {
+		return nil, fmt.Errorf("eval config has no variants")
+	}
+
+	for i, variant := range cfg.Variants {
+		if variant.Name == "" {
+			cfg.Variants[i].Name = fmt.Sprintf("variant-%d", i+1)
+		}
+		if variant.PromptFile == "" {
+			continue
+		}
+		promptPath := variant.PromptFile
+		if !filepath.IsAbs(promptPath) {
+			promptPath = filepath.Join(filepath.Dir(path), promptPath)
+		}
+		prompt, err := os.ReadFile(promptPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read prompt for %s: %w", variant.Name, err)
+		}
+		cfg.Variants[i].Prompt = string(prompt)
+	}
+
+	return &cfg, nil
+}
+
+// Run generates a letter for every golden vacancy with every variant and
+// scores the first letters with the validator and, if configured, an LLM
+// judge. Letters that fail validation are then regenerated as process does
+// and counted separately. A nil validator scores with the default rules.
+func Run(jobs []models.JobAd, cfg *Config, provider processor.Provider, letterValidator *validator.Validator) []Result {
+	if letterValidator == nil {
+		letterValidator = validator.NewDefault(validator.Options{})
+	}
+	results := make([]Result, 0, len(cfg.Variants))
+
+	for _, variant := range cfg.Variants {
+		fmt.Printf("Evaluating %s on %d jobs\n", variant.Name, len(jobs))
+
+		metered := processor.NewMeteredProvider(provider)
+		settings := processor.Settings{
+			Model:       variant.Model,
+			Prompt:      variant.Prompt,
+			Temperature: variant.Temperature,
+		}
+
+		result := Result{
+			Variant:    variant.Name,
+			Model:      variant.Model,
+			PromptHash: processor.PromptHash(variant.Prompt),
+			Jobs:       len(jobs),
+		}
+		var latencies []time.Duration
+		var checkTotal, judgeTotal float64
+
+		for _, job := range jobs {
+			start := time.Now()
+			draft, err := processor.NewDraft(&job, metered, settings)
+			latencies = append(latencies, time.Since(start))
+			if err != nil {
+				fmt.Printf("%s failed on job %s: %s\n", variant.Name, job.ID, err)
+				result.Failed++
+				continue
+			}
+
+			validation := letterValidator.Validate(draft.Letter.CoverLetter, &job)
+			checkTotal += validation.Score
+			if validation.Valid {
+				result.Valid++
+			}
+
+			if cfg.Judge != nil {
+				score, err := judge(provider, cfg.Judge, &job, draft.Letter.CoverLetter)
+				if err != nil {
+					fmt.Printf("Judge failed on job %s: %s\n", job.ID, err)
+				} else {
+					judgeTotal += score
+					result.Judged++
+				}
+			}
+
+			if !validation.Valid {
+				regenerated := draft.Finish(provider, letterValidator)
+				result.Regenerations += regenerated.Validation.Attempts - 1
+				if regenerated.Validation.Valid {
+					result.ValidAfterRegeneration++
+				}
+			}
+		}
+
+		if succeeded := result.Jobs - result.Failed; succeeded > 0 {
+			result.CheckScore = checkTotal / float64(succeeded)
+		}
+		if result.Judged > 0 {
+			result.JudgeScore = judgeTotal / float64(result.Judged)
+		}
+		_, usage := metered.Usage()
+		result.PromptTokens = usage.PromptTokens
+		result.CompletionTokens = usage.CompletionTokens
+		result.Cost = (float64(result.PromptTokens)*variant.InputPrice + float64(result.CompletionTokens)*variant.OutputPrice) / 1e6
+		result.MeanLatency, result.P95Latency = latencyStats(latencies)
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+type judgeResponse struct {
+	Score  float64 `json:"score"`
+	Reason string  `json:"reason"`
+}
+
+// judge asks the judge model to score a letter and returns the score
+// normalized to 0..1.
+func judge(provider processor.Provider, cfg *JudgeConfig, job *models.JobAd, letter string) (float64, error) {
+	rubric := cfg.Rubric
+	if rubric == "" {
+		rubric = DefaultRubric
+	}
+
+	request := models.GroqAPIRequest{
+		Messages: []models.Message{
+			{Role: "system", Content: rubric},
+			{Role: "user", Content: fmt.Sprintf("Vacancy: %s\n\n%s\n\nCover letter:\n%s", job.Name, job.Descrtiption, letter)},
+		},
+		Model:          cfg.Model,
+		ResponseFormat: &models.ResponseFormat{Type: "json_object"},
+	}
+
+	completion, err := provider.Complete(request)
+	if err != nil {
+		return 0, err
+	}
+
+	var resp judgeResponse
+	if err := json.Unmarshal([]byte(strings.TrimSpace(completion.Message.Content)), &resp); err != nil {
+		return 0, fmt.Errorf("failed to decode judge response: %w", err)
+	}
+	if resp.Score < 1 || resp.Score > 10 {
+		return 0, fmt.Errorf("judge score %v is out of range", resp.Score)
+	}
+
+	return (resp.Score - 1) / 9, nil
+}
+
+func latencyStats(latencies []time.Duration) (time.Duration, time.Duration) {
+	if len(latencies) == 0 {
+		return 0, 0
+	}
+
+	sorted := append([]time.Duration{}, latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, latency := range sorted {
+		total += latency
+	}
+
+	p95 := sorted[(len(sorted)*95+99)/100-1]
+	return total / time.Duration(len(sorted)), p95
+}
+
+func PrintTable(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VARIANT\tMODEL\tPROMPT\tJOBS\tFAILED\tVALID\tCHECKS\tREGENERATED\tVALID AFTER\tJUDGE\tTOKENS IN/OUT\tCOST $\tAVG LATENCY\tP95 LATENCY")
+
+	for _, r := range results {
+		judgeScore := "-"
+		if r.Judged > 0 {
+			judgeScore = fmt.Sprintf("%.2f", r.JudgeScore)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%.2f\t%d\t%d\t%s\t%d/%d\t%.4f\t%s\t%s\n",
+			r.Variant, r.Model, r.PromptHash, r.Jobs, r.Failed, r.Valid, r.CheckScore,
+			r.Regenerations, r.Valid+r.ValidAfterRegeneration, judgeScore,
+			r.PromptTokens, r.CompletionTokens, r.Cost,
+			r.MeanLatency.Round(time.Millisecond), r.P95Latency.Round(time.Millisecond))
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,116 @@
+package eval_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"hh_bot/eval"
+	"hh_bot/models"
+	"hh_bot/processor"
+	"hh_bot/validator"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request models.GroqAPIRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		content := ""
+		switch request.Model {
+		case "good-model":
+			content = "Здравствуйте! Хочу присоединиться к вашей команде ML-инженером."
+		case "bad-model":
+			content = "Здравствуйте! С уважением, [Ваше имя]"
+		case "judge-model":
+			content = `{"score": 10, "reason": "specific"}`
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(models.GroqAPIResponse{
+			Choices: []models.Choice{{Message: models.Message{Content: content}}},
+			Usage:   models.Usage{PromptTokens: 100, CompletionTokens: 50, TotalTokens: 150},
+		})
+	}))
+	defer mockServer.Close()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	provider := processor.NewGroqProvider(client, "key", mockServer.URL)
+	letterValidator := validator.NewDefault(validator.Options{MinLength: 10, MaxLength: 1000})
+
+	jobs := []models.JobAd{
+		{ID: "1", Name: "ML-инженер", Descrtiption: "Ищем ML-инженера в команду поиска."},
+		{ID: "2", Name: "Data Scientist", Descrtiption: "Ищем специалиста по анализу данных."},
+	}
+	cfg := &eval.Config{
+		Judge: &eval.JudgeConfig{Model: "judge-model"},
+		Variants: []eval.Variant{
+			{Name: "good", Model: "good-model", Prompt: "a", InputPrice: 1, OutputPrice: 2},
+			{Name: "bad", Model: "bad-model", Prompt: "b"},
+		},
+	}
+
+	results := eval.Run(jobs, cfg, provider, letterValidator)
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+
+	good, bad := results[0], results[1]
+	if good.Valid != 2 || good.CheckScore != 1 || good.JudgeScore != 1 || good.Judged != 2 {
+		t.Fatalf("unexpected result for good variant: %+v", good)
+	}
+	if good.PromptTokens != 200 || good.CompletionTokens != 100 {
+		t.Fatalf("judge tokens must not be billed to the variant: %+v", good)
+	}
+	if want := (200*1.0 + 100*2.0) / 1e6; good.Cost != want {
+		t.Fatalf("expected cost %v, got %v", want, good.Cost)
+	}
+
+	if good.Regenerations != 0 || good.ValidAfterRegeneration != 0 {
+		t.Fatalf("valid letters must not be regenerated: %+v", good)
+	}
+
+	// The bad variant is regenerated MaxRegenerations times and never
+	// passes, but only its first letters are billed and scored.
+	if bad.Valid != 0 || bad.CheckScore >= 1 || bad.ValidAfterRegeneration != 0 {
+		t.Fatalf("unexpected result for bad variant: %+v", bad)
+	}
+	if bad.Regenerations != 2*processor.MaxRegenerations {
+		t.Fatalf("expected %d regenerations, got %d", 2*processor.MaxRegenerations, bad.Regenerations)
+	}
+	if bad.PromptTokens != 200 {
+		t.Fatalf("regenerations must not be billed to the variant: %+v", bad)
+	}
+
+	var out bytes.Buffer
+	if err := eval.PrintTable(&out, results); err != nil {
+		t.Fatalf("PrintTable failed: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 3 {
+		t.Fatalf("expected header and 2 rows, got:\n%s", out.String())
+	}
+}
+
+func TestRunWithoutValidator(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(models.GroqAPIResponse{
+			Choices: []models.Choice{{Message: models.Message{Content: "Здравствуйте! Хочу к вам в команду."}}},
+		})
+	}))
+	defer mockServer.Close()
+
+	provider := processor.NewGroqProvider(mockServer.Client(), "key", mockServer.URL)
+	jobs := []models.JobAd{{ID: "1", Name: "ML-инженер", Descrtiption: "Ищем ML-инженера."}}
+	cfg := &eval.Config{Variants: []eval.Variant{{Name: "short", Model: "m", Prompt: "a"}}}
+
+	results := eval.Run(jobs, cfg, provider, nil)
+	if len(results) != 1 || results[0].Failed != 0 || results[0].Valid != 0 {
+		t.Fatalf("a short letter must fail the default length rule: %+v", results)
+	}
+}
@@ -0,0 +1,250 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"hh_bot/storage"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+const (
+	FormatCSV     = "csv"
+	FormatJSONL   = "jsonl"
+	FormatParquet = "parquet"
+)
+
+// RowWriter writes rows whose values are in the order of the columns it was
+// created with. Values are the Go types returned by pgx: string, int32,
+// int64, float64, bool, time.Time or nil.
+type RowWriter interface {
+	Write(values []any) error
+	Close() error
+}
+
+// FormatFromPath guesses the format from a file extension.
+func FormatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl", ".ndjson":
+		return FormatJSONL
+	case ".parquet":
+		return FormatParquet
+	default:
+		return FormatCSV
+	}
+}
+
+func NewWriter(format string, w io.Writer, columns []storage.ExportColumn) (RowWriter, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w, columns)
+	case FormatJSONL:
+		return &jsonlWriter{encoder: json.NewEncoder(w), columns: columns}, nil
+	case FormatParquet:
+		return newParquetWriter(w, columns)
+	default:
+		return nil, fmt.Errorf("unknown export format %q, expected csv, jsonl or parquet", format)
+	}
+}
+
+type csvWriter struct {
+	writer *csv.Writer
+	record []string
+	rows   int
+}
+
+func newCSVWriter(w io.Writer, columns []storage.ExportColumn) (*csvWriter, error) {
+	header := make([]string, len(columns))
+	for i, c := range columns {
+		header[i] = c.Name
+	}
+	writer := csv.NewWriter(w)
+	if err := writer.Write(header); err != nil {
+		return nil, err
+	}
+	return &csvWriter{writer: writer, record: make([]string, len(columns))}, nil
+}
+
+func (w *csvWriter) Write(values []any) error {
+	for i, value := range values {
+		w.record[i] = formatValue(value)
+	}
+	if err := w.writer.Write(w.record); err != nil {
+		return err
+	}
+	// csv.Writer buffers; flush regularly so output streams.
+	w.rows++
+	if w.rows%1000 == 0 {
+		w.writer.Flush()
+		return w.writer.Error()
+	}
+	return nil
+}
+
+func (w *csvWriter) Close() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+func formatValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+type jsonlWriter struct {
+	encoder *json.Encoder
+	columns []storage.ExportColumn
+}
+
+// Write encodes the row as an object with keys in column order.
+func (w *jsonlWriter) Write(values []any) error {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, value := range values {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(w.columns[i].Name)
+		b.Write(key)
+		b.WriteByte(':')
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("failed to encode column %s: %w", w.columns[i].Name, err)
+		}
+		b.Write(encoded)
+	}
+	b.WriteByte('}')
+	return w.encoder.Encode(json.RawMessage(b.String()))
+}
+
+func (w *jsonlWriter) Close() error {
+	return nil
+}
+
+// rowGroupSize bounds how many rows the parquet writer buffers in memory
+// before flushing a row group.
+const rowGroupSize = 10000
+
+type parquetWriter struct {
+	writer  *parquet.Writer
+	columns []storage.ExportColumn
+	// leaf maps a column position to its parquet column index; parquet
+	// orders the leaves of a group by name.
+	leaf []int
+}
+
+func newParquetWriter(w io.Writer, columns []storage.ExportColumn) (*parquetWriter, error) {
+	group := parquet.Group{}
+	for _, c := range columns {
+		var node parquet.Node
+		switch c.Type {
+		case storage.TypeString:
+			node = parquet.String()
+		case storage.TypeInt:
+			node = parquet.Int(64)
+		case storage.TypeFloat:
+			node = parquet.Leaf(parquet.DoubleType)
+		case storage.TypeBool:
+			node = parquet.Leaf(parquet.BooleanType)
+		case storage.TypeTime:
+			node = parquet.Timestamp(parquet.Millisecond)
+		default:
+			return nil, fmt.Errorf("column %s has unknown type %q", c.Name, c.Type)
+		}
+		if _, ok := group[c.Name]; ok {
+			return nil, fmt.Errorf("column %s is selected twice", c.Name)
+		}
+		group[c.Name] = parquet.Optional(node)
+	}
+
+	schema := parquet.NewSchema("job_ads", group)
+	index := make(map[string]int)
+	for i, path := range schema.Columns() {
+		index[path[0]] = i
+	}
+	leaf := make([]int, len(columns))
+	for i, c := range columns {
+		leaf[i] = index[c.Name]
+	}
+
+	return &parquetWriter{
+		writer:  parquet.NewWriter(w, schema, parquet.MaxRowsPerRowGroup(rowGroupSize)),
+		columns: columns,
+		leaf:    leaf,
+	}, nil
+}
+
+func (w *parquetWriter) Write(values []any) error {
+	// Values must be in column index order rather than selection order.
+	row := make(parquet.Row, len(values))
+	for i, value := range values {
+		v, err := parquetValue(w.columns[i], value)
+		if err != nil {
+			return err
+		}
+		definition := 1
+		if v.IsNull() {
+			definition = 0
+		}
+		row[w.leaf[i]] = v.Level(0, definition, w.leaf[i])
+	}
+	_, err := w.writer.WriteRows([]parquet.Row{row})
+	return err
+}
+
+func parquetValue(c storage.ExportColumn, value any) (parquet.Value, error) {
+	if value == nil {
+		return parquet.NullValue(), nil
+	}
+	switch c.Type {
+	case storage.TypeString:
+		return parquet.ValueOf(formatValue(value)), nil
+	case storage.TypeInt:
+		switch v := value.(type) {
+		case int16:
+			return parquet.Int64Value(int64(v)), nil
+		case int32:
+			return parquet.Int64Value(int64(v)), nil
+		case int64:
+			return parquet.Int64Value(v), nil
+		case int:
+			return parquet.Int64Value(int64(v)), nil
+		}
+	case storage.TypeFloat:
+		switch v := value.(type) {
+		case float64:
+			return parquet.ValueOf(v), nil
+		case float32:
+			return parquet.ValueOf(float64(v)), nil
+		}
+	case storage.TypeBool:
+		if v, ok := value.(bool); ok {
+			return parquet.ValueOf(v), nil
+		}
+	case storage.TypeTime:
+		if v, ok := value.(time.Time); ok {
+			return parquet.Int64Value(v.UnixMilli()), nil
+		}
+	}
+	return parquet.Value{}, fmt.Errorf("column %s: cannot write %T as %s", c.Name, value, c.Type)
+}
+
+func (w *parquetWriter) Close() error {
+	return w.writer.Close()
+}
@@ -0,0 +1,190 @@
+package export_test
+
+import (
+	"bytes"
+	"hh_bot/export"
+	"hh_bot/storage"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+var published = time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC)
+
+func testColumns(t *testing.T) []storage.ExportColumn {
+	columns, err := storage.LookupExportColumns([]string{"name", "id", "salary_from", "score", "valid", "published_at"})
+	if err != nil {
+		t.Fatalf("LookupExportColumns: %v", err)
+	}
+	return columns
+}
+
+func testRows() [][]any {
+	return [][]any{
+		{"ML engineer, \"senior\"", "1", int32(250000), 0.75, true, published},
+		{"Data scientist", "2", nil, nil, nil, nil},
+	}
+}
+
+func write(t *testing.T, format string) []byte {
+	var buf bytes.Buffer
+	w, err := export.NewWriter(format, &buf, testColumns(t))
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	for _, row := range testRows() {
+		if err := w.Write(row); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestCSV(t *testing.T) {
+	got := string(write(t, export.FormatCSV))
+	want := `name,id,salary_from,score,valid,published_at
+"ML engineer, ""senior""",1,250000,0.75,true,2024-05-01T09:30:00Z
+Data scientist,2,,,,
+`
+	if got != want {
+		t.Errorf("CSV output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestJSONL(t *testing.T) {
+	got := string(write(t, export.FormatJSONL))
+	want := `{"name":"ML engineer, \"senior\"","id":"1","salary_from":250000,"score":0.75,"valid":true,"published_at":"2024-05-01T09:30:00Z"}
+{"name":"Data scientist","id":"2","salary_from":null,"score":null,"valid":null,"published_at":null}
+`
+	if got != want {
+		t.Errorf("JSONL output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestParquet(t *testing.T) {
+	data := write(t, export.FormatParquet)
+
+	type row struct {
+		Name        string   `parquet:"name,optional"`
+		ID          string   `parquet:"id,optional"`
+		SalaryFrom  *int64   `parquet:"salary_from,optional"`
+		Score       *float64 `parquet:"score,optional"`
+		Valid       *bool    `parquet:"valid,optional"`
+		PublishedAt *int64   `parquet:"published_at,optional"`
+	}
+
+	rows, err := parquet.Read[row](bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("parquet.Read: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, want 2", len(rows))
+	}
+
+	first := rows[0]
+	if first.Name != `ML engineer, "senior"` || first.ID != "1" || first.SalaryFrom == nil || *first.SalaryFrom != 250000 ||
+		first.Score == nil || *first.Score != 0.75 || first.Valid == nil || !*first.Valid ||
+		first.PublishedAt == nil || *first.PublishedAt != published.UnixMilli() {
+		t.Errorf("unexpected first row: %+v", first)
+	}
+
+	second := rows[1]
+	if second.ID != "2" || second.SalaryFrom != nil || second.Score != nil || second.Valid != nil || second.PublishedAt != nil {
+		t.Errorf("unexpected second row: %+v", second)
+	}
+}
+
+func TestFormatFromPath(t *testing.T) {
+	cases := map[string]string{
+		"out.csv":          export.FormatCSV,
+		"out.JSONL":        export.FormatJSONL,
+		"dir/out.parquet":  export.FormatParquet,
+		"-":                export.FormatCSV,
+		"letters.ndjson":   export.FormatJSONL,
+		"no-extension":     export.FormatCSV,
+		"archive.tar.json": export.FormatCSV,
+	}
+	for path, want := range cases {
+		if got := export.FormatFromPath(path); got != want {
+			t.Errorf("FormatFromPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestUnknownColumn(t *testing.T) {
+	if _, err := storage.LookupExportColumns([]string{"id", "salary"}); err == nil || !strings.Contains(err.Error(), "salary") {
+		t.Errorf("expected an unknown column error, got %v", err)
+	}
+}
+
+func TestResolveDate(t *testing.T) {
+	now := time.Date(2024, 5, 10, 15, 4, 5, 0, time.UTC)
+	cases := map[string]time.Time{
+		"":           {},
+		"today":      time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC),
+		"yesterday":  time.Date(2024, 5, 9, 0, 0, 0, 0, time.UTC),
+		"-7d":        time.Date(2024, 5, 3, 0, 0, 0, 0, time.UTC),
+		"2024-01-02": time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+	}
+	for value, want := range cases {
+		got, err := export.ResolveDate(value, now)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("ResolveDate(%q) = %v, %v, want %v", value, got, err, want)
+		}
+	}
+
+	if _, err := export.ResolveDate("last week", now); err == nil {
+		t.Error("expected an error for an unknown date expression")
+	}
+}
+
+func TestLoadPresets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "presets.json")
+	custom := `[
+		{"name": "letters-today", "columns": ["id", "cover_letter"], "status": "valid", "date_field": "processed", "since": "today"},
+		{"name": "moscow-ml", "columns": ["id", "name"], "query": "ml", "min_score": 0.8}
+	]`
+	if err := os.WriteFile(path, []byte(custom), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	presets, err := export.LoadPresets(path)
+	if err != nil {
+		t.Fatalf("LoadPresets: %v", err)
+	}
+	if len(presets["letters-today"].Columns) != 2 {
+		t.Errorf("custom preset did not override the built-in one: %+v", presets["letters-today"])
+	}
+	if _, ok := presets["dead-letters"]; !ok {
+		t.Error("built-in presets missing")
+	}
+
+	now := time.Date(2024, 5, 10, 15, 0, 0, 0, time.UTC)
+	filter, err := presets["letters-today"].Filter(now)
+	if err != nil {
+		t.Fatalf("Filter: %v", err)
+	}
+	if filter.Status != "valid" || filter.DateField != "processed" || !filter.Since.Equal(time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected filter: %+v", filter)
+	}
+
+	filter, _ = presets["moscow-ml"].Filter(now)
+	if filter.MinScore == nil || *filter.MinScore != 0.8 || filter.Query != "ml" {
+		t.Errorf("unexpected filter: %+v", filter)
+	}
+
+	bad := filepath.Join(t.TempDir(), "bad.json")
+	if err := os.WriteFile(bad, []byte(`[{"name": "x", "columns": ["nope"]}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := export.LoadPresets(bad); err == nil {
+		t.Error("expected an error for a preset with an unknown column")
+	}
+}
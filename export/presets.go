@@ -0,0 +1,124 @@
+package export
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"hh_bot/storage"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed presets.json
+var presetsJSON []byte
+
+// Preset is a saved export: columns plus filters. Dates are "today",
+// "yesterday", a relative "-7d" or YYYY-MM-DD and are resolved when the
+// export runs.
+type Preset struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Format      string   `json:"format"`
+	Columns     []string `json:"columns"`
+	Status      string   `json:"status"`
+	DateField   string   `json:"date_field"`
+	Since       string   `json:"since"`
+	Until       string   `json:"until"`
+	Query       string   `json:"query"`
+	MinScore    *float64 `json:"min_score"`
+}
+
+// LoadPresets returns the built-in presets, overridden and extended by the
+// presets in path if it is not empty.
+func LoadPresets(path string) (map[string]Preset, error) {
+	presets := make(map[string]Preset)
+	if err := addPresets(presets, presetsJSON); err != nil {
+		return nil, fmt.Errorf("invalid built-in presets: %w", err)
+	}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read presets: %w", err)
+		}
+		if err := addPresets(presets, data); err != nil {
+			return nil, fmt.Errorf("invalid presets in %s: %w", path, err)
+		}
+	}
+
+	return presets, nil
+}
+
+func addPresets(presets map[string]Preset, data []byte) error {
+	var list []Preset
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	for _, p := range list {
+		if p.Name == "" {
+			return fmt.Errorf("preset without a name")
+		}
+		if _, err := storage.LookupExportColumns(p.Columns); err != nil {
+			return fmt.Errorf("preset %s: %w", p.Name, err)
+		}
+		presets[p.Name] = p
+	}
+	return nil
+}
+
+func PresetNames(presets map[string]Preset) []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Filter resolves the preset's dates relative to now.
+func (p Preset) Filter(now time.Time) (storage.ExportFilter, error) {
+	filter := storage.ExportFilter{
+		DateField: p.DateField,
+		Status:    p.Status,
+		Query:     p.Query,
+		MinScore:  p.MinScore,
+	}
+
+	var err error
+	if filter.Since, err = ResolveDate(p.Since, now); err != nil {
+		return filter, fmt.Errorf("invalid since: %w", err)
+	}
+	if filter.Until, err = ResolveDate(p.Until, now); err != nil {
+		return filter, fmt.Errorf("invalid until: %w", err)
+	}
+	return filter, nil
+}
+
+var reRelativeDate = regexp.MustCompile(`^-(\d+)d$`)
+
+// ResolveDate turns a date expression into the start of that day in now's
+// location. An empty value gives the zero time.
+func ResolveDate(value string, now time.Time) (time.Time, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	switch value {
+	case "":
+		return time.Time{}, nil
+	case "today":
+		return today, nil
+	case "yesterday":
+		return today.AddDate(0, 0, -1), nil
+	case "tomorrow":
+		return today.AddDate(0, 0, 1), nil
+	}
+
+	if match := reRelativeDate.FindStringSubmatch(value); match != nil {
+		days, _ := strconv.Atoi(match[1])
+		return today.AddDate(0, 0, -days), nil
+	}
+
+	return time.ParseInLocation(time.DateOnly, value, now.Location())
+}
@@ -0,0 +1,233 @@
+package htmltext
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	reSpaces        = regexp.MustCompile(`[ \t\r\n\f\x{00a0}]+`)
+	reTrailingSpace = regexp.MustCompile(`(?m)[ \t]+$`)
+	reBlankLines    = regexp.MustCompile(`\n{3,}`)
+)
+
+// ToMarkdown converts a vacancy description to Markdown, keeping headings,
+// lists, emphasis and paragraph breaks.
+func ToMarkdown(input string) string {
+	return convert(input, true)
+}
+
+// ToText converts a vacancy description to plain text. Lists and paragraph
+// breaks are kept, heading and emphasis markers are dropped.
+func ToText(input string) string {
+	return convert(input, false)
+}
+
+// Excerpt puts s on one line and cuts it to max characters, marking the
+// cut with an ellipsis. It keeps error messages that quote a response body
+// short.
+func Excerpt(s string, max int) string {
+	text := []rune(strings.Join(strings.Fields(s), " "))
+	if len(text) > max {
+		return string(text[:max]) + "…"
+	}
+	return string(text)
+}
+
+type list struct {
+	ordered bool
+	index   int
+}
+
+// converter writes output as it goes. Line breaks, spaces and opening
+// emphasis markers are kept pending until the next text, so they can be
+// merged or dropped without rewriting what was already written.
+type converter struct {
+	b        strings.Builder
+	markdown bool
+	lists    []list
+	skip     int
+
+	// breaks is the number of pending line breaks, space a pending space
+	// and open the pending opening emphasis markers.
+	breaks int
+	space  bool
+	open   string
+	// spaced is set when the output is empty or ends with a space or a
+	// line break, so that no space is needed before the next text.
+	spaced bool
+}
+
+func convert(input string, markdown bool) string {
+	c := &converter{markdown: markdown, spaced: true}
+	z := html.NewTokenizer(strings.NewReader(input))
+
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return c.String()
+		case html.TextToken:
+			if c.skip == 0 {
+				c.text(string(z.Text()))
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			c.start(atom.Lookup(name))
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			c.end(atom.Lookup(name))
+		}
+	}
+}
+
+func (c *converter) start(tag atom.Atom) {
+	switch tag {
+	case atom.Script, atom.Style, atom.Head:
+		c.skip++
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Blockquote, atom.Table, atom.Pre:
+		c.breakLines(2)
+	case atom.Tr:
+		c.breakLines(1)
+	case atom.Td, atom.Th:
+		c.text(" ")
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		c.breakLines(2)
+		if c.markdown {
+			level, _ := strconv.Atoi(tag.String()[1:])
+			c.write(strings.Repeat("#", level) + " ")
+		}
+	case atom.Ul, atom.Ol:
+		if len(c.lists) == 0 {
+			c.breakLines(2)
+		}
+		c.lists = append(c.lists, list{ordered: tag == atom.Ol})
+	case atom.Li:
+		c.breakLines(1)
+		depth := len(c.lists)
+		if depth == 0 {
+			c.write("- ")
+			return
+		}
+		indent := strings.Repeat("  ", depth-1)
+		current := &c.lists[depth-1]
+		if current.ordered {
+			current.index++
+			c.write(indent + strconv.Itoa(current.index) + ". ")
+		} else {
+			c.write(indent + "- ")
+		}
+	case atom.Br:
+		c.breakLines(1)
+	case atom.Hr:
+		c.breakLines(2)
+		if c.markdown {
+			c.write("---")
+		}
+		c.breakLines(2)
+	case atom.Strong, atom.B:
+		c.emphasis("**")
+	case atom.Em, atom.I:
+		c.emphasis("_")
+	}
+}
+
+func (c *converter) end(tag atom.Atom) {
+	switch tag {
+	case atom.Script, atom.Style, atom.Head:
+		if c.skip > 0 {
+			c.skip--
+		}
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Blockquote, atom.Table, atom.Pre,
+		atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		c.breakLines(2)
+	case atom.Ul, atom.Ol:
+		if len(c.lists) > 0 {
+			c.lists = c.lists[:len(c.lists)-1]
+		}
+		if len(c.lists) == 0 {
+			c.breakLines(2)
+		} else {
+			c.breakLines(1)
+		}
+	case atom.Li:
+		c.breakLines(1)
+	case atom.Strong, atom.B:
+		c.closeEmphasis("**")
+	case atom.Em, atom.I:
+		c.closeEmphasis("_")
+	}
+}
+
+// text writes a run of text with whitespace collapsed. Entities are already
+// decoded by the tokenizer. Spaces at either end stay pending.
+func (c *converter) text(s string) {
+	s = reSpaces.ReplaceAllString(s, " ")
+	if s == "" {
+		return
+	}
+	if strings.HasPrefix(s, " ") {
+		c.space = true
+	}
+	trailing := strings.HasSuffix(s, " ")
+	if s = strings.Trim(s, " "); s != "" {
+		c.write(s)
+	}
+	if trailing {
+		c.space = true
+	}
+}
+
+// write writes s after the pending line breaks, space and opening markers.
+// Breaks before any output are dropped, and so is a space after a break.
+func (c *converter) write(s string) {
+	if c.breaks > 0 && c.b.Len() > 0 {
+		c.b.WriteString(strings.Repeat("\n", c.breaks))
+		c.spaced = true
+	}
+	c.breaks = 0
+	if c.space && !c.spaced {
+		c.b.WriteString(" ")
+	}
+	c.space = false
+	c.b.WriteString(c.open)
+	c.open = ""
+	c.b.WriteString(s)
+	c.spaced = strings.HasSuffix(s, " ")
+}
+
+func (c *converter) emphasis(marker string) {
+	if c.markdown {
+		c.open += marker
+	}
+}
+
+// closeEmphasis writes the closing marker right after the text, before any
+// pending space or break. Empty emphasis such as <strong></strong> is
+// dropped.
+func (c *converter) closeEmphasis(marker string) {
+	if !c.markdown {
+		return
+	}
+	if strings.HasSuffix(c.open, marker) {
+		c.open = strings.TrimSuffix(c.open, marker)
+		return
+	}
+	c.b.WriteString(marker)
+	c.spaced = false
+}
+
+// breakLines ends the current line with at least n line breaks in all.
+func (c *converter) breakLines(n int) {
+	c.breaks = max(c.breaks, n)
+	c.space = false
+}
+
+func (c *converter) String() string {
+	s := reTrailingSpace.ReplaceAllString(c.b.String(), "")
+	s = reBlankLines.ReplaceAllString(s, "\n\n")
+	return strings.TrimSpace(s)
+}
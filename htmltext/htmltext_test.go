@@ -0,0 +1,71 @@
+package htmltext_test
+
+import (
+	"hh_bot/htmltext"
+	"testing"
+)
+
+const description = `<p><strong>Обязанности:</strong></p>
+<ul>
+<li>разработка&nbsp;ML-моделей;</li>
+<li>работа с &quot;большими&quot; данными</li>
+</ul>
+<h2>Условия</h2><p>Офис в Москве<br />Гибкий   график</p><script>alert(1)</script>`
+
+func TestToMarkdown(t *testing.T) {
+	want := "**Обязанности:**\n\n- разработка ML-моделей;\n- работа с \"большими\" данными\n\n## Условия\n\nОфис в Москве\nГибкий график"
+	if got := htmltext.ToMarkdown(description); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestToText(t *testing.T) {
+	want := "Обязанности:\n\n- разработка ML-моделей;\n- работа с \"большими\" данными\n\nУсловия\n\nОфис в Москве\nГибкий график"
+	if got := htmltext.ToText(description); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestNestedOrderedList(t *testing.T) {
+	input := `<ol><li>Python<ul><li>pandas</li></ul></li><li>SQL</li></ol>`
+	want := "1. Python\n  - pandas\n2. SQL"
+	if got := htmltext.ToText(input); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestEmphasis(t *testing.T) {
+	tests := []struct {
+		input, want string
+	}{
+		{`word<b>bold</b>`, "word**bold**"},
+		{`a <b>bold</b> word`, "a **bold** word"},
+		{`a<b> bold </b>word`, "a **bold** word"},
+		{`<i><b>both</b></i>`, "_**both**_"},
+		{`empty<strong></strong> <em> </em>emphasis`, "empty emphasis"},
+		{`<b>title<br></b>text`, "**title**\ntext"},
+	}
+	for _, tt := range tests {
+		if got := htmltext.ToMarkdown(tt.input); got != tt.want {
+			t.Errorf("ToMarkdown(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	tests := []struct {
+		input string
+		max   int
+		want  string
+	}{
+		{"", 10, ""},
+		{" <html>\n  <body>Bad gateway</body>\n</html> ", 100, "<html> <body>Bad gateway</body> </html>"},
+		{"Ошибка сервера", 6, "Ошибка…"},
+		{"exactly ten", 11, "exactly ten"},
+	}
+	for _, tt := range tests {
+		if got := htmltext.Excerpt(tt.input, tt.max); got != tt.want {
+			t.Errorf("Excerpt(%q, %d) = %q, want %q", tt.input, tt.max, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hh_bot/models"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Rejection is a record that could not be imported.
+type Rejection struct {
+	Source string
+	ID     string
+	Reason string
+}
+
+type Report struct {
+	Files    int
+	Records  int
+	Accepted int
+	Rejected []Rejection
+}
+
+// maxLineSize bounds a single JSONL record; vacancy descriptions are large
+// but well below this.
+const maxLineSize = 16 << 20
+
+// Import reads HH vacancy JSON from files and directories and calls fn for
+// every valid vacancy. Files may hold a single object, a stream of objects,
+// an array of objects, or JSONL (.jsonl and .ndjson are read line by line so
+// one broken line does not lose the rest of the file). Directories are
+// walked recursively for .json, .jsonl and .ndjson files.
+func Import(paths []string, fn func(job models.JobAd) error) (*Report, error) {
+	report := &Report{}
+
+	files, err := collectFiles(paths)
+	if err != nil {
+		return report, err
+	}
+
+	for _, path := range files {
+		report.Files++
+		if err := importFile(path, report, fn); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+func collectFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			switch strings.ToLower(filepath.Ext(p)) {
+			case ".json", ".jsonl", ".ndjson":
+				if !d.IsDir() {
+					files = append(files, p)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+func importFile(path string, report *Report, fn func(job models.JobAd) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// handle returns only errors from fn; bad records end up in the report.
+	handle := func(source string, raw json.RawMessage) error {
+		report.Records++
+		job, err := decode(raw)
+		if err != nil {
+			report.Rejected = append(report.Rejected, Rejection{Source: source, ID: job.ID, Reason: err.Error()})
+			return nil
+		}
+		report.Accepted++
+		return fn(job)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl", ".ndjson":
+		return importLines(path, f, handle)
+	default:
+		return importStream(path, f, report, handle)
+	}
+}
+
+func importLines(path string, r io.Reader, handle func(string, json.RawMessage) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		raw := make(json.RawMessage, len(text))
+		copy(raw, text)
+		if err := handle(fmt.Sprintf("%s:%d", path, line), raw); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return nil
+}
+
+// importStream decodes a sequence of top-level values, expanding arrays.
+// A syntax error ends the file, as the decoder cannot resynchronize.
+func importStream(path string, r io.Reader, report *Report, handle func(string, json.RawMessage) error) error {
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	record := 0
+
+	reject := func(err error) {
+		report.Rejected = append(report.Rejected, Rejection{
+			Source: fmt.Sprintf("%s: after record %d", path, record),
+			Reason: fmt.Sprintf("unreadable JSON, rest of file skipped: %v", err),
+		})
+	}
+
+	for {
+		var raw json.RawMessage
+		err := decoder.Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			reject(err)
+			return nil
+		}
+
+		if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '[' {
+			var items []json.RawMessage
+			if err := json.Unmarshal(raw, &items); err != nil {
+				reject(err)
+				return nil
+			}
+			for _, item := range items {
+				record++
+				if err := handle(fmt.Sprintf("%s: record %d", path, record), item); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		record++
+		if err := handle(fmt.Sprintf("%s: record %d", path, record), raw); err != nil {
+			return err
+		}
+	}
+}
+
+// decode parses one vacancy and checks that it can be processed. The
+// returned job carries the id when it could be read, for the report.
+func decode(raw json.RawMessage) (models.JobAd, error) {
+	var probe struct {
+		ID    json.RawMessage `json:"id"`
+		Items json.RawMessage `json:"items"`
+	}
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return models.JobAd{}, fmt.Errorf("not a JSON object: %v", err)
+	}
+
+	var job models.JobAd
+	if err := json.Unmarshal(raw, &job); err != nil {
+		var id string
+		json.Unmarshal(probe.ID, &id)
+		return models.JobAd{ID: id}, fmt.Errorf("invalid vacancy: %v", err)
+	}
+
+	switch {
+	case job.ID == "" && probe.Items != nil:
+		return job, errors.New("search results page, not a vacancy; fetch the vacancies themselves")
+	case job.ID == "":
+		return job, errors.New("missing id")
+	case strings.TrimSpace(job.Name) == "":
+		return job, errors.New("missing name")
+	case strings.TrimSpace(job.Descrtiption) == "":
+		return job, errors.New("missing description; search results only carry snippets")
+	}
+
+	return job, nil
+}
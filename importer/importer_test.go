@@ -0,0 +1,66 @@
+package importer_test
+
+import (
+	"hh_bot/importer"
+	"hh_bot/models"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImport(t *testing.T) {
+	var ids []string
+	report, err := importer.Import([]string{"testdata"}, func(job models.JobAd) error {
+		ids = append(ids, job.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+
+	if got := strings.Join(ids, ","); got != "101,104,107,100" {
+		t.Errorf("imported %s, want 101,104,107,100", got)
+	}
+	if report.Files != 3 || report.Records != 8 || report.Accepted != 4 {
+		t.Errorf("unexpected report counts: %+v", report)
+	}
+
+	want := map[string]string{
+		filepath.Join("testdata", "array.json") + ": record 2":    "missing description",
+		filepath.Join("testdata", "array.json") + ": record 3":    "invalid vacancy",
+		filepath.Join("testdata", "nested", "lines.jsonl") + ":2": "not a JSON object",
+		filepath.Join("testdata", "nested", "lines.jsonl") + ":4": "search results page",
+	}
+	if len(report.Rejected) != len(want) {
+		t.Fatalf("rejected %+v", report.Rejected)
+	}
+	for _, r := range report.Rejected {
+		reason, ok := want[r.Source]
+		if !ok || !strings.Contains(r.Reason, reason) {
+			t.Errorf("unexpected rejection %+v", r)
+		}
+	}
+	if report.Rejected[1].ID != "103" {
+		t.Errorf("rejection should carry the id when it is readable: %+v", report.Rejected[1])
+	}
+}
+
+func TestImportSingleObject(t *testing.T) {
+	var jobs []models.JobAd
+	_, err := importer.Import([]string{filepath.Join("testdata", "single.json")}, func(job models.JobAd) error {
+		jobs = append(jobs, job)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].Employer.Name != "Acme" || !strings.Contains(jobs[0].Descrtiption, "<strong>") {
+		t.Errorf("unexpected jobs: %+v", jobs)
+	}
+}
+
+func TestImportMissingPath(t *testing.T) {
+	if _, err := importer.Import([]string{"testdata/missing.json"}, func(models.JobAd) error { return nil }); err == nil {
+		t.Error("expected an error for a missing path")
+	}
+}
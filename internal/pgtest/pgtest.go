@@ -0,0 +1,58 @@
+// Package pgtest opens a Postgres database for tests. The tests are skipped
+// unless TEST_DATABASE_URL points to a database they may create schemas in.
+package pgtest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Open returns a pool whose search_path is a fresh empty schema, dropped
+// with everything in it when the test ends.
+func Open(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+
+	schema := fmt.Sprintf("hh_bot_test_%d", time.Now().UnixNano())
+	if err := exec(ctx, url, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := exec(ctx, url, "DROP SCHEMA "+schema+" CASCADE"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	config, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// public stays on the path for extensions such as pgvector.
+	config.ConnConfig.RuntimeParams["search_path"] = schema + ",public"
+	dbpool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(dbpool.Close)
+	return dbpool
+}
+
+func exec(ctx context.Context, url, query string) error {
+	conn, err := pgx.Connect(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+	_, err = conn.Exec(ctx, query)
+	return err
+}
@@ -4,12 +4,32 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"hh_bot/metrics"
 	"hh_bot/models"
 	"io"
+	"log/slog"
 	"net/http"
 	"time"
 )
 
+// send makes an HH API request, counts it in the metrics and logs it with
+// its latency at debug level.
+func send(client *http.Client, req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := client.Do(req)
+	latency := time.Since(start)
+	metrics.HHRequestDuration.Observe(latency.Seconds())
+	attrs := []any{"method", req.Method, "url", req.URL.Redacted(), "latency", latency}
+	if err != nil {
+		metrics.HHRequests.WithLabelValues(metrics.Status(0, err)).Inc()
+		slog.Debug("HH request failed", append(attrs, "err", err)...)
+		return nil, err
+	}
+	metrics.HHRequests.WithLabelValues(metrics.Status(resp.StatusCode, nil)).Inc()
+	slog.Debug("HH request", append(attrs, "status", resp.StatusCode)...)
+	return resp, nil
+}
+
 func FetchJobs(client *http.Client, url, jobAPIKey string) (*models.JobSearchResponse, error) {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -24,7 +44,7 @@ func FetchJobs(client *http.Client, url, jobAPIKey string) (*models.JobSearchRes
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("User-Agent", "Aplication aplier")
 
-	resp, err := client.Do(req)
+	resp, err := send(client, req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to make request: %w", err)
 	}
@@ -32,7 +52,7 @@ func FetchJobs(client *http.Client, url, jobAPIKey string) (*models.JobSearchRes
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status code: %d, response: %s", resp.StatusCode, string(body))
+		return nil, &APIError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	var searchResponse models.JobSearchResponse
@@ -56,7 +76,7 @@ func ExtractJobData(client *http.Client, jobAPIKey string, job models.JobListing
 	req.Header.Add("Authorization", "Bearer "+jobAPIKey)
 	req.Header.Add("Contetn-Type", "application/json")
 
-	resp, err := client.Do(req)
+	resp, err := send(client, req)
 	if err != nil {
 		return models.JobAd{}, fmt.Errorf("failed to fetch job %s: %v", job.ID, err)
 	}
@@ -75,3 +95,36 @@ func ExtractJobData(client *http.Client, jobAPIKey string, job models.JobListing
 
 	return jobData, nil
 }
+
+// FetchVacancy loads a vacancy by its API URL. A vacancy that was removed
+// from HH is reported as an *APIError with status 404.
+func FetchVacancy(client *http.Client, url, jobAPIKey string) (models.JobAd, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return models.JobAd{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Add("Authorization", "Bearer "+jobAPIKey)
+	req.Header.Add("User-Agent", "Aplication aplier")
+
+	resp, err := send(client, req)
+	if err != nil {
+		return models.JobAd{}, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return models.JobAd{}, &APIError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	var jobData models.JobAd
+	if err := json.NewDecoder(resp.Body).Decode(&jobData); err != nil {
+		return models.JobAd{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return jobData, nil
+}
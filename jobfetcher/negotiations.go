@@ -0,0 +1,142 @@
+package jobfetcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hh_bot/htmltext"
+	"hh_bot/models"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIError is an HH API response with an unexpected status code. A 4xx
+// status means HH refused the request itself, so retrying will not help.
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d, response: %s", e.StatusCode, summarizeBody(e.Body))
+}
+
+// summarizeBody reduces an error response to the types and values of HH's
+// errors, or else to an excerpt. APIError.Body keeps all of it.
+func summarizeBody(body string) string {
+	var response struct {
+		Errors []struct {
+			Type  string `json:"type"`
+			Value string `json:"value"`
+		} `json:"errors"`
+	}
+	if json.Unmarshal([]byte(body), &response) == nil && len(response.Errors) > 0 {
+		parts := make([]string, len(response.Errors))
+		for i, e := range response.Errors {
+			parts[i] = e.Type
+			if e.Value != "" {
+				parts[i] += "/" + e.Value
+			}
+		}
+		return strings.Join(parts, ", ")
+	}
+
+	return htmltext.Excerpt(body, 200)
+}
+
+func (e *APIError) Permanent() bool {
+	return e.StatusCode >= 400 && e.StatusCode < 500 && e.StatusCode != http.StatusTooManyRequests
+}
+
+// ErrNotSent marks an Apply that failed before the request left, such as
+// one whose connection could not be opened, so HH cannot have received it.
+// After any other failure HH may have created the negotiation.
+var ErrNotSent = errors.New("application was not sent")
+
+// Apply responds to a vacancy with a resume and a cover letter and returns
+// the id of the created negotiation.
+func Apply(client *http.Client, apiURL, jobAPIKey, vacancyID, resumeID, message string) (string, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	form := url.Values{}
+	form.Set("vacancy_id", vacancyID)
+	form.Set("resume_id", resumeID)
+	form.Set("message", message)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w: %w", ErrNotSent, err)
+	}
+	req.Header.Add("Authorization", "Bearer "+jobAPIKey)
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("User-Agent", "Aplication aplier")
+
+	resp, err := send(client, req)
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return "", fmt.Errorf("failed to make request: %w: %w", ErrNotSent, err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return "", &APIError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	// The new negotiation is only named by the Location header.
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", nil
+	}
+	return path.Base(location), nil
+}
+
+// FetchNegotiations loads one page of the user's negotiations, most recently
+// updated first.
+func FetchNegotiations(client *http.Client, apiURL, jobAPIKey string, page int) (*models.NegotiationsResponse, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	params := url.Values{}
+	params.Add("page", strconv.Itoa(page))
+	params.Add("per_page", "100")
+	params.Add("order_by", "updated_at")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Add("Authorization", "Bearer "+jobAPIKey)
+	req.Header.Add("User-Agent", "Aplication aplier")
+
+	resp, err := send(client, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &APIError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	var negotiations models.NegotiationsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&negotiations); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &negotiations, nil
+}
@@ -0,0 +1,124 @@
+package jobfetcher_test
+
+import (
+	"errors"
+	"hh_bot/jobfetcher"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Authorization") != "Bearer key" {
+			t.Errorf("unexpected request %s with %q", r.Method, r.Header.Get("Authorization"))
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		switch r.PostForm.Get("vacancy_id") {
+		case "1":
+			if r.PostForm.Get("resume_id") != "r1" || r.PostForm.Get("message") != "Hello" {
+				t.Errorf("unexpected form %v", r.PostForm)
+			}
+			w.Header().Set("Location", "/negotiations/777")
+			w.WriteHeader(http.StatusCreated)
+		case "2":
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":[{"type":"negotiations","value":"test_required"}]}`))
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	id, err := jobfetcher.Apply(server.Client(), server.URL, "key", "1", "r1", "Hello")
+	if err != nil || id != "777" {
+		t.Fatalf("Apply = %q, %v, want 777", id, err)
+	}
+
+	var apiErr *jobfetcher.APIError
+	_, err = jobfetcher.Apply(server.Client(), server.URL, "key", "2", "r1", "Hello")
+	if !errors.As(err, &apiErr) || !apiErr.Permanent() {
+		t.Fatalf("a refused application must be a permanent error, got %v", err)
+	}
+	_, err = jobfetcher.Apply(server.Client(), server.URL, "key", "3", "r1", "Hello")
+	if !errors.As(err, &apiErr) || apiErr.Permanent() {
+		t.Fatalf("a gateway error must be retried, got %v", err)
+	}
+	if errors.Is(err, jobfetcher.ErrNotSent) {
+		t.Fatalf("HH may have received an application answered by a gateway error")
+	}
+
+	server.Close()
+	_, err = jobfetcher.Apply(server.Client(), server.URL, "key", "1", "r1", "Hello")
+	if !errors.Is(err, jobfetcher.ErrNotSent) {
+		t.Fatalf("a refused connection must not send the application, got %v", err)
+	}
+}
+
+func TestFetchNegotiations(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") != "1" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		w.Write([]byte(`{"items":[{"id":"9","state":{"id":"invitation","name":"Приглашение"},
+			"updated_at":"2024-05-01T12:00:00+0300","resume":{"id":"r1"},"vacancy":{"id":"42","name":"ML Engineer"}}],
+			"found":101,"page":1,"pages":2,"per_page":100}`))
+	}))
+	defer server.Close()
+
+	response, err := jobfetcher.FetchNegotiations(server.Client(), server.URL, "key", 1)
+	if err != nil {
+		t.Fatalf("FetchNegotiations: %v", err)
+	}
+	if len(response.Items) != 1 || response.Pages != 2 {
+		t.Fatalf("unexpected response %+v", response)
+	}
+	item := response.Items[0]
+	if item.ID != "9" || item.State.ID != "invitation" || item.Vacancy.ID != "42" || item.Resume.ID != "r1" {
+		t.Fatalf("unexpected negotiation %+v", item)
+	}
+}
+
+func TestFetchVacancy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/vacancies/1":
+			w.Write([]byte(`{"id":"1","name":"ML Engineer","archived":true}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	job, err := jobfetcher.FetchVacancy(server.Client(), server.URL+"/vacancies/1", "key")
+	if err != nil || job.ID != "1" || !job.Archived {
+		t.Fatalf("FetchVacancy = %+v, %v, want archived vacancy 1", job, err)
+	}
+
+	var apiErr *jobfetcher.APIError
+	_, err = jobfetcher.FetchVacancy(server.Client(), server.URL+"/vacancies/2", "key")
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("a removed vacancy must be a 404 error, got %v", err)
+	}
+}
+
+func TestAPIErrorMessage(t *testing.T) {
+	tests := []struct {
+		body, want string
+	}{
+		{`{"errors":[{"type":"negotiations","value":"test_required"},{"type":"bad_authorization"}]}`,
+			"unexpected status code: 403, response: negotiations/test_required, bad_authorization"},
+		{"<html>\n  <body>Bad   gateway</body>\n</html>",
+			"unexpected status code: 403, response: <html> <body>Bad gateway</body> </html>"},
+		{strings.Repeat("я", 300), "unexpected status code: 403, response: " + strings.Repeat("я", 200) + "…"},
+	}
+	for _, tt := range tests {
+		err := &jobfetcher.APIError{StatusCode: http.StatusForbidden, Body: tt.body}
+		if got := err.Error(); got != tt.want {
+			t.Errorf("Error() = %q, want %q", got, tt.want)
+		}
+	}
+}
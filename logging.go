@@ -0,0 +1,18 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+)
+
+// newLogger returns a logger that writes records of level and above to w as
+// text or JSON. Both were checked by config.Validate.
+func newLogger(w io.Writer, format, level string) *slog.Logger {
+	var l slog.Level
+	l.UnmarshalText([]byte(level))
+	opts := &slog.HandlerOptions{Level: l}
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
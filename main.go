@@ -1,183 +1,987 @@
 package main
 
 import (
+	"bufio"
 	"context"
-	"flag"
+	"errors"
 	"fmt"
 	"hh_bot/config"
+	"hh_bot/dedup"
+	"hh_bot/embeddings"
+	"hh_bot/eval"
+	"hh_bot/export"
+	"hh_bot/htmltext"
+	"hh_bot/importer"
 	"hh_bot/jobfetcher"
+	"hh_bot/metrics"
+	"hh_bot/migrations"
 	"hh_bot/models"
 	"hh_bot/processor"
+	"hh_bot/skills"
 	"hh_bot/storage"
-	"log"
+	"hh_bot/validator"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"os"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-var (
-	fetch   = flag.Bool("fetch", false, "flag for downloading job ads")
-	process = flag.Bool("process", false, "flag for processing job ads")
-)
+// queueBatchSize is how many jobs one processor leases at a time. Small
+// batches keep the other processors busy when several run at once.
+const queueBatchSize = 10
+
+// importBatchSize matches a search page, the unit fetchAndSaveJobAds saves.
+const importBatchSize = 100
+
+// defaultQueries are fetched on backends without saved searches; on
+// Postgres they are the searches the migrations start with.
+var defaultQueries = []string{
+	"ML Engineer",
+	"Data science",
+	"Data Scientist",
+	"Дата сайентист",
+	"Датасайентист",
+	"ML",
+	"Machine Learning Engineer",
+	"ML-инженер",
+}
 
 func main() {
-	flag.Parse()
-	conf, client, dbpool, err := initialize()
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func runMigrate(dbpool *pgxpool.Pool, command string, steps int) error {
+	switch command {
+	case "up":
+		done, err := migrations.Up(dbpool)
+		for _, m := range done {
+			fmt.Printf("Applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Printf("Database schema is up to date.\n")
+		}
+		return err
+	case "down":
+		done, err := migrations.Down(dbpool, steps)
+		for _, m := range done {
+			fmt.Printf("Reverted %04d_%s\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		states, err := migrations.Status(dbpool)
+		if err != nil {
+			return err
+		}
+		for _, state := range states {
+			applied := "pending"
+			if state.AppliedAt != nil {
+				applied = state.AppliedAt.Format(time.DateTime)
+			}
+			fmt.Printf("%04d_%s\t%s\n", state.Version, state.Name, applied)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up, down or status", command)
+	}
+}
+
+func runEval(dbpool *pgxpool.Pool, client *http.Client, conf *config.Config, path string) error {
+	evalConf, err := eval.LoadConfig(path)
+	if err != nil {
+		return err
+	}
 
+	jobs, err := storage.LoadJobsByIDs(dbpool, evalConf.Golden)
 	if err != nil {
-		log.Fatalf("Initialization failed: %s", err)
+		return err
+	}
+	if len(jobs) == 0 {
+		return fmt.Errorf("none of the %d golden jobs were found", len(evalConf.Golden))
+	}
+
+	provider := processor.NewGroqProvider(client, conf.LLMAPIKey, conf.LLMAPIURL)
+	results := eval.Run(jobs, evalConf, provider, newLetterValidator(conf))
+
+	return eval.PrintTable(os.Stdout, results)
+}
+
+func newLetterValidator(conf *config.Config) *validator.Validator {
+	return validator.NewDefault(validator.Options{
+		MinLength:        conf.LetterMinLength,
+		MaxLength:        conf.LetterMaxLength,
+		BannedPhrases:    conf.BannedPhrases,
+		RequiredMentions: conf.RequiredMentions,
+	})
+}
+
+func newSettings(conf *config.Config) processor.Settings {
+	return processor.Settings{
+		Model:         conf.Model,
+		Prompt:        conf.SystemPrompt,
+		PromptVersion: conf.PromptVersion,
+		Temperature:   conf.Temperature,
 	}
-	defer dbpool.Close()
-	fmt.Printf("%v, %v\n", *fetch, *process)
+}
 
-	jobQueries := []string{
-		"ML Engineer",
-		"Data science",
-		"Data Scientist",
-		"Дата сайентист",
-		"Датасайентист",
-		"ML",
-		"Machine Learning Engineer",
-		"ML-инженер",
+func retryPolicy(conf *config.Config) storage.RetryPolicy {
+	policy := storage.DefaultRetryPolicy()
+	if conf.QueueMaxAttempts > 0 {
+		policy.MaxAttempts = conf.QueueMaxAttempts
+	}
+	if conf.QueueBaseDelay > 0 {
+		policy.BaseDelay = conf.QueueBaseDelay
+	}
+	if conf.QueueMaxDelay > 0 {
+		policy.MaxDelay = conf.QueueMaxDelay
+	}
+	if conf.QueueLeaseTimeout > 0 {
+		policy.LeaseTimeout = conf.QueueLeaseTimeout
 	}
+	return policy
+}
 
-	if *fetch {
-		fmt.Printf("Fetching jobs\n")
-		fetchJobAds(client, dbpool, jobQueries, conf.JobAPIURL, conf.JobAPIKey)
+// loadOptions orders and limits the jobs of a processing run. The order and
+// maxJobs flags override the config when set.
+func loadOptions(conf *config.Config, order string, maxJobs int) (storage.LeaseOptions, error) {
+	opts := storage.LeaseOptions{
+		Order:         conf.ProcessOrder,
+		Limit:         conf.ProcessLimit,
+		ProfileSkills: conf.ProfileSkills,
+	}
+	if order != "" {
+		opts.Order = order
+	}
+	if maxJobs > 0 {
+		opts.Limit = maxJobs
+	}
+	if err := storage.CheckOrder(opts.Order); err != nil {
+		return opts, err
 	}
+	if opts.Order == storage.OrderFit && len(opts.ProfileSkills) == 0 {
+		return opts, fmt.Errorf("the fit order needs PROFILE_SKILLS")
+	}
+	return opts, nil
+}
 
-	if *process {
-		fmt.Printf("Processing jobs\n")
-		unprocessedJob, err := storage.LoadUnprocessedJobs(dbpool)
+// processQueue leases queued jobs in small batches until none are ready or
+// the limit is reached, and returns how many succeeded and failed. The jobs
+// of a batch are processed by up to PROCESS_WORKERS goroutines. Failed jobs
+// are retried by a later run once their backoff has passed.
+func processQueue(repo storage.Repository, client *http.Client, conf *config.Config, opts storage.LeaseOptions, stats *runStats) (int, int, error) {
+	letterValidator := newLetterValidator(conf)
+	settings := newSettings(conf)
+	provider := processor.NewMeteredProvider(processor.NewGroqProvider(client, conf.LLMAPIKey, conf.LLMAPIURL))
+	defer countUsage(stats, provider)
+	policy := retryPolicy(conf)
+	workers := max(conf.ProcessWorkers, 1)
+	batchSize := max(queueBatchSize, workers)
+
+	var mu sync.Mutex
+	var done, failed int
+	for opts.Limit == 0 || done+failed < opts.Limit {
+		batch := opts
+		batch.Limit = batchSize
+		if opts.Limit > 0 {
+			batch.Limit = min(batchSize, opts.Limit-done-failed)
+		}
+
+		leases, err := repo.LeaseJobs(batch, policy)
 		if err != nil {
-			log.Fatal("failed to load unprocessed jobs", err)
+			return done, failed, fmt.Errorf("failed to lease jobs: %w", err)
+		}
+		if len(leases) == 0 {
+			break
 		}
 
-		for _, job := range unprocessedJob {
-			processAndSaveJob(dbpool, &job, client, conf.Model, conf.SystemPrompt, conf.LLMAPIKey, conf.LLMAPIURL)
-			log.Printf("Jobs %s with id %s processed successfully", job.Name, job.ID)
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, workers)
+		for _, lease := range leases {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+
+				err := processAndSaveJob(repo, &lease.Job, &lease, provider, letterValidator, settings, stats)
+				mu.Lock()
+				if err == nil {
+					done++
+				} else {
+					failed++
+				}
+				mu.Unlock()
+				stats.add(func(run *storage.Run) {
+					if err == nil {
+						run.Saved++
+					} else {
+						run.Failed++
+					}
+				})
+				if err == nil {
+					return
+				}
+				if errors.Is(err, storage.ErrLeaseLost) {
+					stats.logger().Warn("job lease expired before it was finished", "vacancy_id", lease.Job.ID, "attempt", lease.Attempts)
+					return
+				}
+
+				status, failErr := repo.FailJob(lease, err, policy)
+				if failErr != nil {
+					stats.logger().Error("failed to record job failure", "vacancy_id", lease.Job.ID, "attempt", lease.Attempts,
+						"err", failErr, "cause", err)
+					return
+				}
+				stats.logger().Warn("job failed", "vacancy_id", lease.Job.ID, "attempt", lease.Attempts, "status", status, "err", err)
+			}()
+		}
+		wg.Wait()
+	}
+
+	fmt.Printf("Processed %d jobs, %d failed.\n", done, failed)
+	return done, failed, nil
+}
 
+// processJobs regenerates the letters of jobs and returns how many failed.
+func processJobs(repo storage.Repository, client *http.Client, conf *config.Config, jobs []models.JobAd, stats *runStats) int {
+	letterValidator := newLetterValidator(conf)
+	settings := newSettings(conf)
+	provider := processor.NewMeteredProvider(processor.NewGroqProvider(client, conf.LLMAPIKey, conf.LLMAPIURL))
+	defer countUsage(stats, provider)
+
+	failed := 0
+	for _, job := range jobs {
+		if err := processAndSaveJob(repo, &job, nil, provider, letterValidator, settings, stats); err != nil {
+			stats.logger().Warn("job failed", "vacancy_id", job.ID, "err", err)
+			failed++
+			stats.add(func(run *storage.Run) { run.Failed++ })
+			continue
 		}
+		stats.add(func(run *storage.Run) { run.Saved++ })
 	}
+	return failed
 }
 
-func fetchJobAds(client *http.Client, dbpool *pgxpool.Pool, jobQueries []string, queryURL, jobApiKey string) {
-	jobMap := make(map[string]int)
+func searchJobs(dbpool *pgxpool.Pool, query storage.SearchQuery) error {
+	results, err := storage.SearchJobs(dbpool, query)
+	if err != nil {
+		return err
+	}
 
-	for _, query := range jobQueries {
-		jobMap[query] = 0
+	for _, r := range results {
+		fmt.Printf("%s\t%.3f\t%s — %s, %s%s [%s]\n", r.JobID, r.Rank, r.Name, r.Employer, r.Area, formatSalary(r), r.Status)
+		fmt.Printf("\t%s\n\n", strings.Join(strings.Fields(r.Snippet), " "))
 	}
+	fmt.Printf("Found %d job ads.\n", len(results))
 
-	for job := range jobMap {
-		params := url.Values{}
-		params.Add("experience", "noExperience")
-		params.Add("experience", "between1And3")
-		params.Add("text", job)
-		jobsUrl := queryURL + "?" + params.Encode()
+	return nil
+}
+
+func formatSalary(r storage.SearchResult) string {
+	switch {
+	case r.SalaryFrom != nil && r.SalaryTo != nil:
+		return fmt.Sprintf(", %d–%d %s", *r.SalaryFrom, *r.SalaryTo, r.Currency)
+	case r.SalaryFrom != nil:
+		return fmt.Sprintf(", from %d %s", *r.SalaryFrom, r.Currency)
+	case r.SalaryTo != nil:
+		return fmt.Sprintf(", up to %d %s", *r.SalaryTo, r.Currency)
+	}
+	return ""
+}
 
-		jobs, err := jobfetcher.FetchJobs(client, jobsUrl, jobApiKey)
+func listExportPresets(conf *config.Config) error {
+	presets, err := export.LoadPresets(conf.ExportPresets)
+	if err != nil {
+		return err
+	}
+	for _, name := range export.PresetNames(presets) {
+		p := presets[name]
+		fmt.Printf("%s\t%s\n\tcolumns: %s\n", p.Name, p.Description, strings.Join(p.Columns, ","))
+	}
+	return nil
+}
 
+// exportJobs applies the named preset, if any, and then the explicitly set
+// fields of overrides on top of it. A negative MinScore is unset.
+func exportJobs(dbpool *pgxpool.Pool, conf *config.Config, path, presetName, columnList string, overrides export.Preset) error {
+	preset := export.Preset{Columns: storage.DefaultExportColumns}
+	if presetName != "" {
+		presets, err := export.LoadPresets(conf.ExportPresets)
 		if err != nil {
-			fmt.Printf("Failed to fetch jobs for %s: %v\n", job, err)
-			continue
+			return err
+		}
+		var ok bool
+		if preset, ok = presets[presetName]; !ok {
+			return fmt.Errorf("unknown preset %q, available: %s", presetName, strings.Join(export.PresetNames(presets), ", "))
 		}
-		jobMap[job] = jobs.Found
-		fmt.Printf("Found %d jobs for '%s' query.\n", jobs.Found, job)
 	}
 
-	for job := range jobMap {
-		for i := 1; i*100 <= jobMap[job]; i++ {
-			page := strconv.Itoa(i)
-			params := url.Values{}
-			params.Add("experience", "noExperience")
-			params.Add("experience", "between1And3")
-			params.Add("text", job)
-			params.Add("per_page", "100")
-			params.Add("page", page)
+	if columnList != "" {
+		preset.Columns = strings.Split(columnList, ",")
+	}
+	fields := map[*string]string{
+		&preset.Format:    overrides.Format,
+		&preset.DateField: overrides.DateField,
+		&preset.Since:     overrides.Since,
+		&preset.Until:     overrides.Until,
+		&preset.Status:    overrides.Status,
+		&preset.Query:     overrides.Query,
+	}
+	for field, value := range fields {
+		if value != "" {
+			*field = value
+		}
+	}
+	if overrides.MinScore != nil && *overrides.MinScore >= 0 {
+		preset.MinScore = overrides.MinScore
+	}
+	if preset.Format == "" {
+		preset.Format = export.FormatFromPath(path)
+	}
 
-			fetchURL := queryURL + "?" + params.Encode()
+	columns, err := storage.LookupExportColumns(preset.Columns)
+	if err != nil {
+		return err
+	}
+	filter, err := preset.Filter(time.Now())
+	if err != nil {
+		return err
+	}
 
-			err := fetchAndSaveJobAds(client, fetchURL, jobApiKey, dbpool)
+	out := os.Stdout
+	if path != "-" {
+		if out, err = os.Create(path); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	buffered := bufio.NewWriter(out)
 
-			if err != nil {
-				fmt.Printf("Job processing for %s failed: %v\n", job, err)
-			}
+	writer, err := export.NewWriter(preset.Format, buffered, columns)
+	if err != nil {
+		return err
+	}
+
+	rows := 0
+	err = storage.StreamExport(dbpool, columns, filter, func(values []any) error {
+		rows++
+		return writer.Write(values)
+	})
+	if err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+
+	if path != "-" {
+		// Close reports the write errors that only show up on flush to disk.
+		if err := out.Close(); err != nil {
+			return err
+		}
+		fmt.Printf("Exported %d job ads to %s.\n", rows, path)
+	}
+	return nil
+}
 
+// printStats shows the queue counts, the application counts on Postgres and,
+// with listDead, the dead jobs with their last error.
+func printStats(a *app, listDead bool) error {
+	stats, err := a.repo.QueueStats()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Queue: %d pending, %d in progress, %d done, %d failed, %d dead, %d duplicates.\n",
+		stats[storage.StatusPending], stats[storage.StatusInProgress], stats[storage.StatusDone],
+		stats[storage.StatusFailed], stats[storage.StatusDead], stats[storage.StatusDuplicate])
+
+	if a.dbpool != nil {
+		negotiations, err := storage.NegotiationStats(a.dbpool)
+		if err != nil {
+			return err
+		}
+		states := make([]string, 0, len(negotiations))
+		for state := range negotiations {
+			states = append(states, state)
+		}
+		sort.Strings(states)
+		for _, state := range states {
+			fmt.Printf("Applications %s: %d\n", state, negotiations[state])
 		}
 	}
 
+	if !listDead {
+		return nil
+	}
+	dead, err := a.repo.DeadJobs()
+	if err != nil {
+		return err
+	}
+	for _, entry := range dead {
+		fmt.Printf("%s\t%s\t%d attempts\t%s\t%s\n", entry.JobID, entry.UpdatedAt.Format(time.DateTime),
+			entry.Attempts, entry.Name, entry.LastError)
+	}
+	return nil
 }
 
-func initialize() (*config.Config, *http.Client, *pgxpool.Pool, error) {
-	conf := config.LoadConfig()
+// reprocessFilter builds a filter from flag values; since and until are
+// dates in YYYY-MM-DD form.
+func reprocessFilter(filter storage.ReprocessFilter, since, until string) (storage.ReprocessFilter, error) {
+	var err error
+	if since != "" {
+		if filter.Since, err = time.Parse(time.DateOnly, since); err != nil {
+			return filter, fmt.Errorf("invalid -since: %w", err)
+		}
+	}
+	if until != "" {
+		if filter.Until, err = time.Parse(time.DateOnly, until); err != nil {
+			return filter, fmt.Errorf("invalid -until: %w", err)
+		}
+	}
+
+	if filter.IsEmpty() {
+		return filter, fmt.Errorf("at least one of -prompt-hash, -prompt-version, -model, -since, -until or -status is required")
+	}
+
+	return filter, nil
+}
+
+// defaultFilters are the HH parameters of searches given on the command
+// line or in the schedule.
+var defaultFilters = url.Values{"experience": {"noExperience", "between1And3"}}
+
+// fetchSearches returns what a fetch runs: queries when given, else the
+// saved searches matching filter on Postgres and the default queries on the
+// other backends.
+func fetchSearches(dbpool *pgxpool.Pool, queries []string, filter storage.SavedSearchFilter) ([]storage.SavedSearch, error) {
+	if len(queries) == 0 && dbpool != nil {
+		return storage.LoadSavedSearches(dbpool, filter)
+	}
+	if len(queries) == 0 {
+		queries = defaultQueries
+	}
 
-	client := &http.Client{Timeout: 20 * time.Second}
+	var searches []storage.SavedSearch
+	for _, query := range queries {
+		searches = append(searches, storage.SavedSearch{Name: query, Query: query, Filters: defaultFilters, Enabled: true})
+	}
+	return searches, nil
+}
 
-	dbpool, err := pgxpool.New(context.Background(), conf.DatabaseURL)
+// fetchJobAds saves the new vacancies of every search and returns how many
+// of the search requests failed, out of how many. The counts of saved
+// searches are recorded per run.
+func fetchJobAds(client *http.Client, repo storage.Repository, dbpool *pgxpool.Pool, searches []storage.SavedSearch, queryURL, jobApiKey string, stats *runStats) (int, int) {
+	index := loadDedupIndex(dbpool, stats)
+
+	var requests, failed int
+	var totalInserted, totalSkipped int
+	for _, search := range searches {
+		run, n := fetchSearch(client, repo, dbpool, index, search, queryURL, jobApiKey, stats)
+		requests += n
+		failed += run.Failed
+		totalInserted += run.New
+		totalSkipped += run.Duplicates
+		stats.add(func(r *storage.Run) {
+			r.Queries = append(r.Queries, storage.QueryCount{
+				Query: search.Name, Found: run.Found, New: run.New, Duplicates: run.Duplicates, Failed: run.Failed,
+			})
+			r.Saved += run.New
+			r.Skipped += run.Duplicates
+			r.Failed += run.Failed
+		})
+
+		if search.ID != 0 && dbpool != nil {
+			if err := storage.SaveSearchRun(dbpool, run); err != nil {
+				stats.logger().Warn("failed to record search stats", "query", search.Name, "err", err)
+			}
+		}
+	}
+
+	fmt.Printf("Fetch finished: %d new job ads saved, %d already stored.\n", totalInserted, totalSkipped)
+	return failed, requests
+}
+
+// fetchSearch saves the vacancies of one search page by page and returns
+// the run and how many requests it made. HH returns at most 2000 vacancies
+// per search.
+func fetchSearch(client *http.Client, repo storage.Repository, dbpool *pgxpool.Pool, index *dedup.Index, search storage.SavedSearch, queryURL, jobApiKey string, stats *runStats) (storage.SearchRun, int) {
+	const perPage, maxResults = 100, 2000
+
+	run := storage.SearchRun{SearchID: search.ID, StartedAt: time.Now()}
+
+	params := url.Values{}
+	for key, values := range search.Filters {
+		params[key] = slices.Clone(values)
+	}
+	params.Set("text", search.Query)
+
+	jobs, err := jobfetcher.FetchJobs(client, queryURL+"?"+params.Encode(), jobApiKey)
+	requests := 1
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("Unable to create connection pool: %w\n", err)
+		stats.logger().Error("search failed", "query", search.Name, "err", err)
+		run.Failed++
+		run.FinishedAt = time.Now()
+		return run, requests
 	}
+	run.Found = jobs.Found
+	metrics.SearchFound.WithLabelValues(search.Name).Set(float64(jobs.Found))
+	stats.add(func(r *storage.Run) { r.Pages++ })
+	stats.logger().Info("search found jobs", "query", search.Name, "found", jobs.Found)
 
-	return conf, client, dbpool, nil
+	params.Set("per_page", strconv.Itoa(perPage))
+	for page := 0; page*perPage < min(jobs.Found, maxResults); page++ {
+		params.Set("page", strconv.Itoa(page))
+
+		inserted, skipped, err := fetchAndSaveJobAds(client, queryURL+"?"+params.Encode(), jobApiKey, repo, dbpool, index, stats)
+		run.New += inserted
+		run.Duplicates += skipped
+		requests++
+
+		if err != nil {
+			stats.logger().Error("search page failed", "query", search.Name, "page", page, "err", err)
+			run.Failed++
+		}
+	}
+
+	run.FinishedAt = time.Now()
+	return run, requests
 }
 
-func fetchAndSaveJobAds(client *http.Client, fetchURL, jobAPIKey string, dbpool *pgxpool.Pool) error {
+// initialize opens the configured storage backend. The pool is only set for
+// the postgres backend; features beyond storage.Repository need it.
+func initialize(sources config.Sources) (*config.Config, *http.Client, storage.Repository, *pgxpool.Pool, error) {
+	conf, err := config.Load(sources)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	slog.SetDefault(newLogger(os.Stderr, conf.LogFormat, conf.LogLevel))
+
+	client := &http.Client{Timeout: conf.HTTPTimeout}
+
+	switch conf.StorageBackend {
+	case "postgres":
+		dbpool, err := pgxpool.New(context.Background(), conf.DatabaseURL)
+		if err != nil {
+			return nil, nil, nil, nil, fmt.Errorf("Unable to create connection pool: %w\n", err)
+		}
+		return conf, client, storage.NewPostgres(dbpool), dbpool, nil
+	case "sqlite":
+		repo, err := storage.NewSQLite(conf.SQLitePath)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		return conf, client, repo, nil, nil
+	case "memory":
+		return conf, client, storage.NewMemory(), nil, nil
+	default:
+		return nil, nil, nil, nil, fmt.Errorf("unknown STORAGE_BACKEND %q, expected postgres, sqlite or memory", conf.StorageBackend)
+	}
+}
+
+func loadDedupIndex(dbpool *pgxpool.Pool, stats *runStats) *dedup.Index {
+	var fingerprints []models.Fingerprint
+	if dbpool != nil {
+		var err error
+		fingerprints, err = storage.LoadFingerprints(dbpool)
+		if err != nil {
+			stats.logger().Warn("failed to load fingerprints, duplicates will not be detected", "err", err)
+		}
+	}
+	return dedup.NewIndex(fingerprints)
+}
+
+// importJobAds feeds vacancy dumps through ingestJobs in batches and
+// returns the import report.
+func importJobAds(repo storage.Repository, dbpool *pgxpool.Pool, paths []string, stats *runStats) (*importer.Report, error) {
+	index := loadDedupIndex(dbpool, stats)
+
+	var batch []models.JobAd
+	var inserted int
+	flush := func() error {
+		n, err := ingestJobs(repo, dbpool, index, batch, stats)
+		inserted += n
+		batch = batch[:0]
+		return err
+	}
+
+	report, err := importer.Import(paths, func(job models.JobAd) error {
+		batch = append(batch, job)
+		if len(batch) < importBatchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil {
+		err = flush()
+	}
+
+	for _, r := range report.Rejected {
+		if r.ID != "" {
+			fmt.Printf("rejected %s (id %s): %s\n", r.Source, r.ID, r.Reason)
+		} else {
+			fmt.Printf("rejected %s: %s\n", r.Source, r.Reason)
+		}
+	}
+	fmt.Printf("Read %d records from %d files: %d new job ads saved, %d already stored, %d rejected.\n",
+		report.Records, report.Files, inserted, report.Accepted-inserted, len(report.Rejected))
+	metrics.Vacancies.WithLabelValues("import", "saved").Add(float64(inserted))
+	metrics.Vacancies.WithLabelValues("import", "skipped").Add(float64(report.Accepted - inserted))
+	metrics.Vacancies.WithLabelValues("import", "failed").Add(float64(len(report.Rejected)))
+	stats.add(func(run *storage.Run) {
+		run.Saved += inserted
+		run.Skipped += report.Accepted - inserted
+		run.Failed += len(report.Rejected)
+	})
+
+	return report, err
+}
+
+// fetchAndSaveJobAds stores the new vacancies of one search page and returns
+// how many were inserted and how many were already stored.
+func fetchAndSaveJobAds(client *http.Client, fetchURL, jobAPIKey string, repo storage.Repository, dbpool *pgxpool.Pool, index *dedup.Index, stats *runStats) (int, int, error) {
 
 	fetchedJobs, err := jobfetcher.FetchJobs(client, fetchURL, jobAPIKey)
 	if err != nil {
-		return err
+		return 0, 0, err
 	}
+	stats.add(func(run *storage.Run) { run.Pages++ })
 
+	ids := make([]string, 0, len(fetchedJobs.Items))
 	for _, job := range fetchedJobs.Items {
-		exist, err := storage.GetJobID(dbpool, job.ID)
-		if err != nil {
-			log.Printf("failed to check duplicate: %v", err)
-		}
-		if exist {
+		ids = append(ids, job.ID)
+	}
+	existing, err := repo.ExistingJobIDs(ids)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to check duplicates: %w", err)
+	}
+
+	var newJobs []models.JobAd
+	for _, job := range fetchedJobs.Items {
+		if existing[job.ID] {
 			continue
 		}
 		jobData, err := jobfetcher.ExtractJobData(client, jobAPIKey, job)
 		if err != nil {
-			log.Printf("failed to extract job data: %v", err)
+			stats.logger().Warn("failed to fetch vacancy", "vacancy_id", job.ID, "err", err)
+			metrics.Vacancies.WithLabelValues("fetch", "failed").Inc()
+			stats.add(func(run *storage.Run) { run.Failed++ })
 			continue
 		}
+		stats.add(func(run *storage.Run) { run.Details++ })
+
+		newJobs = append(newJobs, jobData)
+	}
+
+	inserted, err := ingestJobs(repo, dbpool, index, newJobs, stats)
+	if err != nil {
+		return 0, len(existing), err
+	}
+
+	skipped := len(existing) + len(newJobs) - inserted
+	metrics.Vacancies.WithLabelValues("fetch", "saved").Add(float64(inserted))
+	metrics.Vacancies.WithLabelValues("fetch", "skipped").Add(float64(skipped))
+	return inserted, skipped, nil
+}
+
+// ingestJobs is the common path of fetched and imported vacancies: it keeps
+// the HTML description next to a Markdown one, saves and queues the new
+// vacancies, and then stores the skills and dedup fingerprints of every
+// vacancy that lacks them, including ones saved in part by an earlier run.
+// It returns how many vacancies were new.
+func ingestJobs(repo storage.Repository, dbpool *pgxpool.Pool, index *dedup.Index, jobs []models.JobAd, stats *runStats) (int, error) {
+	for i := range jobs {
+		jobs[i].DescriptionHTML = jobs[i].Descrtiption
+		jobs[i].Descrtiption = htmltext.ToMarkdown(jobs[i].DescriptionHTML)
+	}
+
+	inserted, err := repo.SaveJobs(jobs)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save jobs to data base: %w", err)
+	}
+
+	if dbpool == nil {
+		return len(inserted), nil
+	}
+
+	ids := make([]string, len(jobs))
+	for i := range jobs {
+		ids[i] = jobs[i].ID
+	}
+	noSkills, noFingerprint, err := storage.LoadJobsMissingExtraction(dbpool, ids)
+	if err != nil {
+		stats.logger().Warn("failed to look up jobs missing skills or fingerprints", "err", err)
+		return len(inserted), nil
+	}
+
+	// A batch may hold a vacancy twice, as when two queries find it. Only
+	// its first copy is extracted, or the fingerprint of the second would
+	// match the first and mark the vacancy as its own duplicate.
+	for i := range jobs {
+		jobData := &jobs[i]
+
+		if noSkills[jobData.ID] {
+			delete(noSkills, jobData.ID)
+			err = storage.SaveJobSkills(dbpool, jobData.ID, skills.Default().ForJob(jobData))
+			if err != nil {
+				stats.logger().Warn("failed to save job skills", "vacancy_id", jobData.ID, "err", err)
+			}
+		}
+
+		if noFingerprint[jobData.ID] {
+			delete(noFingerprint, jobData.ID)
+			fingerprint := index.Add(jobData.ID, dedup.Fingerprint(jobData))
+			if !fingerprint.Canonical {
+				stats.logger().Info("job is a duplicate", "vacancy_id", jobData.ID, "duplicate_of", fingerprint.ClusterID)
+			}
+			err = storage.SaveFingerprint(dbpool, fingerprint)
+			if err != nil {
+				stats.logger().Warn("failed to save job fingerprint", "vacancy_id", jobData.ID, "err", err)
+			}
+		}
+	}
+
+	return len(inserted), nil
+}
+
+// newEmbeddingsProvider falls back to the offline hashing provider, which
+// only matches shared words, when no embeddings API is configured.
+func newEmbeddingsProvider(conf *config.Config, client *http.Client) embeddings.Provider {
+	if conf.EmbeddingsAPIURL == "" {
+		provider := embeddings.HashingProvider{}
+		slog.Warn("EMBEDDINGS_API_URL is not set, using the offline hashing embeddings", "model", provider.Model())
+		return provider
+	}
+	return embeddings.NewHTTPProvider(client, conf.EmbeddingsAPIKey, conf.EmbeddingsAPIURL, conf.EmbeddingsModel)
+}
+
+func embeddingText(job *models.JobAd) string {
+	return job.Name + "\n\n" + job.Descrtiption
+}
+
+func embedStoredJobs(dbpool *pgxpool.Pool, provider embeddings.Provider, pgvector bool) (int, error) {
+	const batchSize = 32
+
+	jobs, err := storage.LoadJobsWithoutEmbedding(dbpool, provider.Model())
+	if err != nil {
+		return 0, err
+	}
+
+	embedded := 0
+	for start := 0; start < len(jobs); start += batchSize {
+		batch := jobs[start:min(start+batchSize, len(jobs))]
+
+		texts := make([]string, len(batch))
+		for i := range batch {
+			texts[i] = embeddingText(&batch[i])
+		}
 
-		jobData.Descrtiption = processor.RemoveHTMLTags(jobData.Descrtiption)
+		vectors, err := provider.Embed(texts)
+		if err != nil {
+			return embedded, err
+		}
+
+		for i, vector := range vectors {
+			if err := storage.SaveEmbedding(dbpool, batch[i].ID, provider.Model(), vector, pgvector); err != nil {
+				return embedded, fmt.Errorf("failed to save embedding of job %s: %w", batch[i].ID, err)
+			}
+			embedded++
+		}
+	}
+
+	return embedded, nil
+}
+
+// searchSimilar prints the stored job ads closest to a job ad id or to the
+// contents of a file, such as a resume.
+func searchSimilar(dbpool *pgxpool.Pool, provider embeddings.Provider, pgvector bool, target string, limit int) error {
+	var query []float32
+	excludeID := ""
 
-		err = storage.SaveJobToDB(dbpool, &jobData)
+	if content, err := os.ReadFile(target); err == nil {
+		vectors, err := provider.Embed([]string{htmltext.ToText(string(content))})
 		if err != nil {
-			log.Printf("failed to save job to data base: %v", err)
+			return err
+		}
+		query = vectors[0]
+	} else {
+		excludeID = target
+		query, err = storage.LoadEmbedding(dbpool, target, provider.Model())
+		if err != nil {
+			return err
+		}
+		if query == nil {
+			jobs, err := storage.LoadJobsByIDs(dbpool, []string{target})
+			if err != nil {
+				return err
+			}
+			if len(jobs) == 0 {
+				return fmt.Errorf("%s is neither a readable file nor a stored job ad", target)
+			}
+			vectors, err := provider.Embed([]string{embeddingText(&jobs[0])})
+			if err != nil {
+				return err
+			}
+			query = vectors[0]
 		}
+	}
 
-		err = storage.SaveUnprocessedJobToDB(dbpool, job.ID)
+	var matches []embeddings.Match
+	if pgvector {
+		found, err := storage.SearchSimilar(dbpool, provider.Model(), query, limit+1)
 		if err != nil {
-			log.Printf("failed to save unprocessed job to data base: %v", err)
+			return err
+		}
+		matches = found
+	} else {
+		candidates, err := storage.LoadEmbeddings(dbpool, provider.Model())
+		if err != nil {
+			return err
+		}
+		delete(candidates, excludeID)
+		matches = embeddings.Rank(query, candidates, limit)
+	}
+
+	ids := make([]string, 0, len(matches))
+	for _, match := range matches {
+		ids = append(ids, match.JobID)
+	}
+	jobs, err := storage.LoadJobsByIDs(dbpool, ids)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]string, len(jobs))
+	for _, job := range jobs {
+		names[job.ID] = job.Name
+	}
+
+	shown := 0
+	for _, match := range matches {
+		if match.JobID == excludeID || shown == limit {
+			continue
 		}
+		fmt.Printf("%.3f\t%s\t%s\n", match.Score, match.JobID, names[match.JobID])
+		shown++
 	}
 
 	return nil
+}
 
+func normalizeStoredSkills(dbpool *pgxpool.Pool, top int) error {
+	jobs, err := storage.LoadJobsForSkills(dbpool)
+	if err != nil {
+		return err
+	}
+
+	taxonomy := skills.Default()
+	for _, job := range jobs {
+		if err := storage.SaveJobSkills(dbpool, job.ID, taxonomy.ForJob(&job)); err != nil {
+			return fmt.Errorf("failed to save skills of job %s: %w", job.ID, err)
+		}
+	}
+	fmt.Printf("Normalized skills of %d job ads.\n", len(jobs))
+
+	counts, err := storage.TopSkills(dbpool, top)
+	if err != nil {
+		return err
+	}
+	for _, count := range counts {
+		fmt.Printf("%5d\t%s\t%s\n", count.Jobs, count.Category, count.Skill)
+	}
+
+	return nil
 }
 
-func processAndSaveJob(dbpool *pgxpool.Pool, job *models.JobAd, client *http.Client, model, prompt, llmApiKey, llmApiURL string) error {
+// extractMissingSkills stores the skills of vacancies that have none, such
+// as ones whose skills failed to save on ingest.
+func extractMissingSkills(dbpool *pgxpool.Pool) (int, error) {
+	jobs, err := storage.LoadJobsWithoutSkills(dbpool)
+	if err != nil {
+		return 0, err
+	}
 
-	description, err := processor.ProcessJob(job, client, model, prompt, llmApiKey, llmApiURL)
+	taxonomy := skills.Default()
+	for _, job := range jobs {
+		if err := storage.SaveJobSkills(dbpool, job.ID, taxonomy.ForJob(&job)); err != nil {
+			return 0, fmt.Errorf("failed to save skills of job %s: %w", job.ID, err)
+		}
+	}
 
+	return len(jobs), nil
+}
+
+func fingerprintStoredJobs(dbpool *pgxpool.Pool) (int, int, error) {
+	fingerprints, err := storage.LoadFingerprints(dbpool)
 	if err != nil {
-		return fmt.Errorf("Failed to process job %s: %v\n", job.ID, err)
-	} else {
-		fmt.Printf("Succsessfully procesed job %s.\n", job.Name)
+		return 0, 0, err
 	}
+	index := dedup.NewIndex(fingerprints)
 
-	err = storage.UpdateProcessedJob(dbpool, job.ID, description[1], description[0])
+	jobs, err := storage.LoadJobsWithoutFingerprint(dbpool)
 	if err != nil {
-		return fmt.Errorf("Failed to save job %s: %v\n", job.ID, err)
+		return 0, 0, err
+	}
+
+	duplicates := 0
+	for _, job := range jobs {
+		fingerprint := index.Add(job.ID, dedup.Fingerprint(&job))
+		if !fingerprint.Canonical {
+			duplicates++
+		}
+		if err := storage.SaveFingerprint(dbpool, fingerprint); err != nil {
+			return 0, 0, fmt.Errorf("failed to save fingerprint of job %s: %w", job.ID, err)
+		}
+	}
+
+	return len(jobs), duplicates, nil
+}
+
+// countUsage adds the LLM calls and tokens of provider to stats.
+func countUsage(stats *runStats, provider *processor.MeteredProvider) {
+	calls, usage := provider.Usage()
+	stats.add(func(run *storage.Run) {
+		run.LLMCalls += calls
+		run.LLMTokens += usage.TotalTokens
+	})
+}
+
+// processAndSaveJob writes a letter for job and saves it, completing lease
+// when the job came from the queue.
+func processAndSaveJob(repo storage.Repository, job *models.JobAd, lease *storage.Lease, provider processor.Provider, letterValidator *validator.Validator, settings processor.Settings, stats *runStats) error {
+
+	start := time.Now()
+	result, err := processor.ProcessJob(job, provider, letterValidator, settings)
+	if err != nil {
+		return fmt.Errorf("failed to process job %s: %w", job.ID, err)
+	}
+
+	if lease != nil {
+		err = repo.CompleteJob(*lease, result)
 	} else {
-		log.Printf("Succsessfully saved processed job %s\n", job.Name)
+		err = repo.UpdateProcessedJob(result)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save job %s: %w", job.ID, err)
 	}
 
+	attrs := []any{"vacancy_id", job.ID, "name", job.Name, "latency", time.Since(start)}
+	if !result.Validation.Valid {
+		stats.logger().Warn("job processed, cover letter saved with validation violations",
+			append(attrs, "violations", len(result.Validation.Violations))...)
+	} else {
+		stats.logger().Info("job processed", attrs...)
+	}
 	return nil
 }
@@ -0,0 +1,117 @@
+// Package metrics holds the Prometheus metrics of hh_bot. They are counted
+// by the packages that do the work and served on /metrics by serve and
+// daemon.
+package metrics
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds every metric below along with the Go runtime and process
+// metrics.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HHRequests counts HH API requests by status code, or "error" when
+	// no response arrived.
+	HHRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "hh_bot_hh_requests_total",
+		Help: "HH API requests by response status.",
+	}, []string{"status"})
+	HHRequestDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "hh_bot_hh_request_duration_seconds",
+		Help:    "Latency of HH API requests.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	// Vacancies counts vacancies by source (fetch or import) and result:
+	// saved, skipped when already stored, or failed.
+	Vacancies = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "hh_bot_vacancies_total",
+		Help: "Vacancies ingested by source and result.",
+	}, []string{"source", "result"})
+
+	// SearchFound is how many vacancies HH found for a search query on its
+	// latest fetch.
+	SearchFound = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "hh_bot_search_found",
+		Help: "Vacancies HH found for a search query on its latest fetch.",
+	}, []string{"query"})
+
+	// LLMRequests counts LLM API requests by status code, or "error".
+	LLMRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "hh_bot_llm_requests_total",
+		Help: "LLM API requests by response status.",
+	}, []string{"status"})
+	LLMRequestDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "hh_bot_llm_request_duration_seconds",
+		Help:    "Latency of LLM API requests.",
+		Buckets: prometheus.ExponentialBuckets(0.25, 2, 8),
+	})
+	LLMTokens = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "hh_bot_llm_tokens_total",
+		Help: "Tokens used by LLM completions.",
+	})
+	// LLMRetries counts repeated LLM requests by reason: rate_limit and
+	// error retry a request, repair asks to fix an unparsable answer and
+	// validation to rewrite a letter that failed validation.
+	LLMRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "hh_bot_llm_retries_total",
+		Help: "Repeated LLM requests by reason.",
+	}, []string{"reason"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HHRequests, HHRequestDuration, Vacancies, SearchFound,
+		LLMRequests, LLMRequestDuration, LLMTokens, LLMRetries,
+	)
+}
+
+// Status is the status label of a request: its status code, or "error"
+// when it failed without a response.
+func Status(code int, err error) string {
+	if err != nil {
+		return "error"
+	}
+	return strconv.Itoa(code)
+}
+
+// Handler serves Registry together with the processing queue depth by
+// state, which queueStats reads at every scrape. When queueStats fails the
+// error is logged and the scrape is served without the queue depth.
+func Handler(queueStats func() (map[string]int, error)) http.Handler {
+	queue := prometheus.NewRegistry()
+	queue.MustRegister(queueCollector(queueStats))
+	return promhttp.HandlerFor(prometheus.Gatherers{Registry, queue}, promhttp.HandlerOpts{
+		ErrorLog:      slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+		ErrorHandling: promhttp.ContinueOnError,
+	})
+}
+
+var queueDesc = prometheus.NewDesc("hh_bot_queue_jobs", "Jobs in the processing queue by state.", []string{"state"}, nil)
+
+type queueCollector func() (map[string]int, error)
+
+func (c queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueDesc
+}
+
+func (c queueCollector) Collect(ch chan<- prometheus.Metric) {
+	stats, err := c()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(queueDesc, err)
+		return
+	}
+	for state, count := range stats {
+		ch <- prometheus.MustNewConstMetric(queueDesc, prometheus.GaugeValue, float64(count), state)
+	}
+}
@@ -0,0 +1,104 @@
+package metrics_test
+
+import (
+	"errors"
+	"hh_bot/metrics"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T, handler http.Handler) (int, string) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+// sample returns the value of series in a scrape, or 0 when it is absent.
+func sample(t *testing.T, body, series string) float64 {
+	t.Helper()
+	for _, line := range strings.Split(body, "\n") {
+		value, ok := strings.CutPrefix(line, series+" ")
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+		return v
+	}
+	return 0
+}
+
+func TestHandler(t *testing.T) {
+	handler := metrics.Handler(func() (map[string]int, error) {
+		return map[string]int{"pending": 3, "dead": 1}, nil
+	})
+	counters := map[string]float64{
+		`hh_bot_hh_requests_total{status="404"}`:             1,
+		`hh_bot_hh_requests_total{status="error"}`:           1,
+		`hh_bot_llm_tokens_total`:                            150,
+		`hh_bot_llm_request_duration_seconds_bucket{le="2"}`: 1,
+		`hh_bot_llm_request_duration_seconds_bucket{le="1"}`: 0,
+		`hh_bot_llm_request_duration_seconds_count`:          1,
+	}
+	_, before := scrape(t, handler)
+
+	metrics.HHRequests.WithLabelValues(metrics.Status(http.StatusNotFound, nil)).Inc()
+	metrics.HHRequests.WithLabelValues(metrics.Status(0, errors.New("timeout"))).Inc()
+	metrics.SearchFound.WithLabelValues("Golang").Set(42)
+	metrics.LLMTokens.Add(150)
+	metrics.LLMRequestDuration.Observe(1.5)
+
+	code, body := scrape(t, handler)
+	if code != http.StatusOK {
+		t.Fatalf("status %d:\n%s", code, body)
+	}
+	for series, want := range counters {
+		if got := sample(t, body, series) - sample(t, before, series); got != want {
+			t.Errorf("%s grew by %v, want %v", series, got, want)
+		}
+	}
+	for series, want := range map[string]float64{
+		`hh_bot_search_found{query="Golang"}`: 42,
+		`hh_bot_queue_jobs{state="pending"}`:  3,
+		`hh_bot_queue_jobs{state="dead"}`:     1,
+	} {
+		if got := sample(t, body, series); got != want {
+			t.Errorf("%s = %v, want %v", series, got, want)
+		}
+	}
+	if !strings.Contains(body, "go_goroutines ") {
+		t.Error("metrics do not contain go_goroutines")
+	}
+
+	code, body = scrape(t, metrics.Handler(func() (map[string]int, error) {
+		return nil, errors.New("database is down")
+	}))
+	if code != http.StatusOK {
+		t.Fatalf("a failing queue must not fail the scrape, got %d:\n%s", code, body)
+	}
+	if strings.Contains(body, "hh_bot_queue_jobs{") {
+		t.Errorf("metrics contain the queue depth of a failing queue:\n%s", body)
+	}
+	for _, want := range []string{"go_goroutines ", "hh_bot_llm_tokens_total "} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics of a failing queue do not contain %s", want)
+		}
+	}
+}
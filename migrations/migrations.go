@@ -0,0 +1,282 @@
+package migrations
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// lockID keeps two migrate runs from applying the same migration at once.
+const lockID = 0x68685f626f74
+
+var reFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+var ErrOutOfDate = errors.New("database schema is out of date")
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type State struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+func All() ([]Migration, error) {
+	entries, err := fs.ReadDir(files, "sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := reFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		data, err := files.ReadFile(path.Join("sql", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if match[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both up and down files", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+func Latest() int {
+	migrations, err := All()
+	if err != nil || len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+func ensureTable(ctx context.Context, dbpool *pgxpool.Pool) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)
+	`
+	_, err := dbpool.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	return nil
+}
+
+func applied(ctx context.Context, dbpool *pgxpool.Pool) (map[int]time.Time, error) {
+	rows, err := dbpool.Query(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	versions := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		versions[version] = appliedAt
+	}
+
+	return versions, rows.Err()
+}
+
+// Up applies every pending migration, each in its own transaction.
+func Up(dbpool *pgxpool.Pool) ([]Migration, error) {
+	ctx := context.Background()
+
+	migrations, err := All()
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureTable(ctx, dbpool); err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range migrations {
+		ran := false
+		err := pgx.BeginFunc(ctx, dbpool, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, lockID); err != nil {
+				return err
+			}
+
+			var exists bool
+			err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, m.Version).Scan(&exists)
+			if err != nil || exists {
+				return err
+			}
+
+			if _, err := tx.Exec(ctx, m.Up); err != nil {
+				return err
+			}
+			_, err = tx.Exec(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name)
+			ran = err == nil
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+		if ran {
+			done = append(done, m)
+		}
+	}
+
+	return done, nil
+}
+
+// Down reverts the last steps applied migrations.
+func Down(dbpool *pgxpool.Pool, steps int) ([]Migration, error) {
+	ctx := context.Background()
+
+	migrations, err := All()
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureTable(ctx, dbpool); err != nil {
+		return nil, err
+	}
+
+	versions, err := applied(ctx, dbpool)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		m := migrations[i]
+		if _, ok := versions[m.Version]; !ok {
+			continue
+		}
+
+		err := pgx.BeginFunc(ctx, dbpool, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, lockID); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, m.Down); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, `DELETE FROM schema_migrations WHERE version = $1`, m.Version)
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("reverting migration %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+
+	return done, nil
+}
+
+func Status(dbpool *pgxpool.Pool) ([]State, error) {
+	ctx := context.Background()
+
+	migrations, err := All()
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureTable(ctx, dbpool); err != nil {
+		return nil, err
+	}
+
+	versions, err := applied(ctx, dbpool)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]State, 0, len(migrations))
+	for _, m := range migrations {
+		state := State{Version: m.Version, Name: m.Name}
+		if appliedAt, ok := versions[m.Version]; ok {
+			state.AppliedAt = &appliedAt
+		}
+		states = append(states, state)
+	}
+
+	return states, nil
+}
+
+// Check returns ErrOutOfDate unless the database has exactly the embedded
+// migrations applied. It only reads: a database without schema_migrations
+// has no migrations applied.
+func Check(dbpool *pgxpool.Pool) error {
+	ctx := context.Background()
+
+	migrations, err := All()
+	if err != nil {
+		return err
+	}
+
+	var exists bool
+	err = dbpool.QueryRow(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to look up schema_migrations: %w", err)
+	}
+	versions := make(map[int]time.Time)
+	if exists {
+		versions, err = applied(ctx, dbpool)
+		if err != nil {
+			return err
+		}
+	}
+
+	pending := 0
+	for _, m := range migrations {
+		if _, ok := versions[m.Version]; !ok {
+			pending++
+		}
+		delete(versions, m.Version)
+	}
+	if len(versions) > 0 {
+		return fmt.Errorf("%w: database has %d migrations unknown to this binary, upgrade hh_bot", ErrOutOfDate, len(versions))
+	}
+	if pending > 0 {
+		return fmt.Errorf("%w: %d pending migrations, run migrate up", ErrOutOfDate, pending)
+	}
+
+	return nil
+}
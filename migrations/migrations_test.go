@@ -0,0 +1,31 @@
+package migrations_test
+
+import (
+	"context"
+	"errors"
+	"hh_bot/internal/pgtest"
+	"hh_bot/migrations"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	dbpool := pgtest.Open(t)
+
+	if err := migrations.Check(dbpool); !errors.Is(err, migrations.ErrOutOfDate) {
+		t.Fatalf("an empty database must be out of date, got %v", err)
+	}
+	var exists bool
+	if err := dbpool.QueryRow(context.Background(), `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists); err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("Check created schema_migrations")
+	}
+
+	if _, err := migrations.Up(dbpool); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrations.Check(dbpool); err != nil {
+		t.Fatalf("a migrated database must pass, got %v", err)
+	}
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS processed_job_ads;
+DROP TABLE IF EXISTS job_ads;
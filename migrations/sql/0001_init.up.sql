@@ -0,0 +1,54 @@
+CREATE TABLE IF NOT EXISTS job_ads (
+    id                        TEXT PRIMARY KEY,
+    accept_handicapped        BOOLEAN NOT NULL DEFAULT false,
+    accept_incomplete_resumes BOOLEAN NOT NULL DEFAULT false,
+    accept_kids               BOOLEAN NOT NULL DEFAULT false,
+    accept_temporary          BOOLEAN NOT NULL DEFAULT false,
+    allow_messages            BOOLEAN NOT NULL DEFAULT false,
+    alternate_url             TEXT,
+    apply_alternate_url       TEXT,
+    approved                  BOOLEAN NOT NULL DEFAULT false,
+    archived                  BOOLEAN NOT NULL DEFAULT false,
+    area                      JSONB,
+    billing_type              JSONB,
+    code                      TEXT,
+    contacts                  JSONB,
+    department                JSONB,
+    description               TEXT,
+    driver_license_types      JSONB,
+    employer                  JSONB,
+    employment_form           JSONB,
+    experience                JSONB,
+    fly_in_fly_out_duration   JSONB,
+    has_test                  BOOLEAN NOT NULL DEFAULT false,
+    initial_created_at        TIMESTAMPTZ,
+    insider_interview         JSONB,
+    internship                BOOLEAN NOT NULL DEFAULT false,
+    key_skills                JSONB,
+    languages                 JSONB,
+    name                      TEXT NOT NULL,
+    negotiations_url          TEXT,
+    night_shifts              BOOLEAN NOT NULL DEFAULT false,
+    premium                   BOOLEAN NOT NULL DEFAULT false,
+    professional_roles        JSONB,
+    published_at              TIMESTAMPTZ,
+    relations                 JSONB,
+    response_letter_required  BOOLEAN NOT NULL DEFAULT false,
+    response_url              TEXT,
+    salary                    JSONB,
+    suitable_resumes_url      TEXT,
+    test                      JSONB,
+    type                      JSONB,
+    video_vacancy             JSONB,
+    work_format               JSONB,
+    work_schedule_by_days     JSONB,
+    working_hours             JSONB,
+    address                   JSONB
+);
+
+CREATE TABLE IF NOT EXISTS processed_job_ads (
+    job_id       TEXT PRIMARY KEY REFERENCES job_ads (id) ON DELETE CASCADE,
+    processed    BOOLEAN NOT NULL DEFAULT false,
+    cover_letter TEXT,
+    thinking     TEXT
+);
@@ -0,0 +1,3 @@
+ALTER TABLE processed_job_ads
+    DROP COLUMN IF EXISTS validation,
+    DROP COLUMN IF EXISTS valid;
@@ -0,0 +1,3 @@
+ALTER TABLE processed_job_ads
+    ADD COLUMN IF NOT EXISTS validation JSONB,
+    ADD COLUMN IF NOT EXISTS valid      BOOLEAN;
@@ -0,0 +1 @@
+ALTER TABLE job_ads DROP COLUMN IF EXISTS description_html;
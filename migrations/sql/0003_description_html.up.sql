@@ -0,0 +1 @@
+ALTER TABLE job_ads ADD COLUMN IF NOT EXISTS description_html TEXT;
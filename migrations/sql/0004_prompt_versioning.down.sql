@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS job_letters;
+
+ALTER TABLE processed_job_ads
+    DROP COLUMN IF EXISTS prompt_hash,
+    DROP COLUMN IF EXISTS prompt_version,
+    DROP COLUMN IF EXISTS model,
+    DROP COLUMN IF EXISTS params,
+    DROP COLUMN IF EXISTS processed_at;
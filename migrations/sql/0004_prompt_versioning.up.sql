@@ -0,0 +1,28 @@
+ALTER TABLE processed_job_ads
+    ADD COLUMN IF NOT EXISTS prompt_hash    TEXT,
+    ADD COLUMN IF NOT EXISTS prompt_version TEXT,
+    ADD COLUMN IF NOT EXISTS model          TEXT,
+    ADD COLUMN IF NOT EXISTS params         JSONB,
+    ADD COLUMN IF NOT EXISTS processed_at   TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS job_letters (
+    id             BIGSERIAL PRIMARY KEY,
+    job_id         TEXT NOT NULL REFERENCES job_ads (id) ON DELETE CASCADE,
+    cover_letter   TEXT NOT NULL,
+    thinking       TEXT,
+    validation     JSONB,
+    valid          BOOLEAN,
+    prompt_hash    TEXT,
+    prompt_version TEXT,
+    model          TEXT,
+    params         JSONB,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS job_letters_job_id_idx ON job_letters (job_id, created_at);
+
+-- Keep letters generated before versioning existed.
+INSERT INTO job_letters (job_id, cover_letter, thinking, validation, valid)
+SELECT job_id, cover_letter, thinking, validation, valid
+FROM processed_job_ads
+WHERE processed AND cover_letter IS NOT NULL;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS job_fingerprints (
+    job_id     TEXT PRIMARY KEY REFERENCES job_ads (id) ON DELETE CASCADE,
+    simhash    BIGINT NOT NULL,
+    cluster_id TEXT NOT NULL,
+    canonical  BOOLEAN NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS job_fingerprints_cluster_id_idx ON job_fingerprints (cluster_id);
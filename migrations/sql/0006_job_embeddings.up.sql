@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS job_embeddings (
+    job_id    TEXT PRIMARY KEY REFERENCES job_ads (id) ON DELETE CASCADE,
+    model     TEXT NOT NULL,
+    embedding REAL[] NOT NULL
+);
+
+-- The pgvector column is optional: without the extension similarity search
+-- falls back to ranking the REAL[] embeddings in process.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_available_extensions WHERE name = 'vector') THEN
+        CREATE EXTENSION IF NOT EXISTS vector;
+        ALTER TABLE job_embeddings ADD COLUMN IF NOT EXISTS embedding_vec vector;
+    END IF;
+END
+$$;
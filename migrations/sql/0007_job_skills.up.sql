@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS job_skills (
+    job_id   TEXT NOT NULL REFERENCES job_ads (id) ON DELETE CASCADE,
+    skill    TEXT NOT NULL,
+    category TEXT NOT NULL,
+    source   TEXT NOT NULL,
+    PRIMARY KEY (job_id, skill)
+);
+
+CREATE INDEX IF NOT EXISTS job_skills_skill_idx ON job_skills (skill);
@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS processed_job_ads_ready_idx;
+
+ALTER TABLE processed_job_ads
+    DROP CONSTRAINT IF EXISTS processed_job_ads_status_check,
+    DROP COLUMN IF EXISTS status,
+    DROP COLUMN IF EXISTS attempts,
+    DROP COLUMN IF EXISTS last_error,
+    DROP COLUMN IF EXISTS next_attempt_at,
+    DROP COLUMN IF EXISTS leased_until,
+    DROP COLUMN IF EXISTS updated_at;
@@ -0,0 +1,18 @@
+ALTER TABLE processed_job_ads
+    ADD COLUMN IF NOT EXISTS status          TEXT NOT NULL DEFAULT 'pending',
+    ADD COLUMN IF NOT EXISTS attempts        INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS last_error      TEXT,
+    ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN IF NOT EXISTS leased_until    TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS updated_at      TIMESTAMPTZ NOT NULL DEFAULT now();
+
+ALTER TABLE processed_job_ads
+    ADD CONSTRAINT processed_job_ads_status_check
+    CHECK (status IN ('pending', 'in_progress', 'done', 'failed', 'dead'));
+
+UPDATE processed_job_ads SET status = 'done' WHERE processed;
+
+-- Serves the lease query, which only looks at items that may be picked up.
+CREATE INDEX IF NOT EXISTS processed_job_ads_ready_idx
+    ON processed_job_ads (next_attempt_at)
+    WHERE status IN ('pending', 'failed', 'in_progress');
@@ -0,0 +1,24 @@
+DROP TRIGGER IF EXISTS job_ads_normalize ON job_ads;
+DROP FUNCTION IF EXISTS job_ads_normalize();
+DROP FUNCTION IF EXISTS normalize_job_ad(job_ads);
+DROP FUNCTION IF EXISTS json_array_or_empty(JSONB);
+
+DROP TABLE IF EXISTS job_metro_stations;
+DROP TABLE IF EXISTS job_schedules;
+DROP TABLE IF EXISTS job_work_formats;
+DROP TABLE IF EXISTS areas;
+DROP TABLE IF EXISTS employers;
+
+ALTER TABLE job_ads
+    DROP COLUMN IF EXISTS salary_from,
+    DROP COLUMN IF EXISTS salary_to,
+    DROP COLUMN IF EXISTS salary_currency,
+    DROP COLUMN IF EXISTS salary_gross,
+    DROP COLUMN IF EXISTS employer_id,
+    DROP COLUMN IF EXISTS employer_name,
+    DROP COLUMN IF EXISTS area_id,
+    DROP COLUMN IF EXISTS area_name,
+    DROP COLUMN IF EXISTS experience_id,
+    DROP COLUMN IF EXISTS experience_name,
+    DROP COLUMN IF EXISTS employment_form_id,
+    DROP COLUMN IF EXISTS employment_form_name;
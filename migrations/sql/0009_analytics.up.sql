@@ -0,0 +1,133 @@
+-- Scalar fields of the nested JSONB columns, kept in sync by Postgres itself.
+-- Adding stored generated columns rewrites the table, which backfills them.
+ALTER TABLE job_ads
+    ADD COLUMN IF NOT EXISTS salary_from          INTEGER GENERATED ALWAYS AS ((salary->>'from')::integer) STORED,
+    ADD COLUMN IF NOT EXISTS salary_to            INTEGER GENERATED ALWAYS AS ((salary->>'to')::integer) STORED,
+    ADD COLUMN IF NOT EXISTS salary_currency      TEXT GENERATED ALWAYS AS (nullif(salary->>'currency', '')) STORED,
+    ADD COLUMN IF NOT EXISTS salary_gross         BOOLEAN GENERATED ALWAYS AS ((salary->>'gross')::boolean) STORED,
+    ADD COLUMN IF NOT EXISTS employer_id          TEXT GENERATED ALWAYS AS (nullif(employer->>'id', '')) STORED,
+    ADD COLUMN IF NOT EXISTS employer_name        TEXT GENERATED ALWAYS AS (nullif(employer->>'name', '')) STORED,
+    ADD COLUMN IF NOT EXISTS area_id              TEXT GENERATED ALWAYS AS (nullif(area->>'id', '')) STORED,
+    ADD COLUMN IF NOT EXISTS area_name            TEXT GENERATED ALWAYS AS (nullif(area->>'name', '')) STORED,
+    ADD COLUMN IF NOT EXISTS experience_id        TEXT GENERATED ALWAYS AS (nullif(experience->>'id', '')) STORED,
+    ADD COLUMN IF NOT EXISTS experience_name      TEXT GENERATED ALWAYS AS (nullif(experience->>'name', '')) STORED,
+    ADD COLUMN IF NOT EXISTS employment_form_id   TEXT GENERATED ALWAYS AS (nullif(employment_form->>'id', '')) STORED,
+    ADD COLUMN IF NOT EXISTS employment_form_name TEXT GENERATED ALWAYS AS (nullif(employment_form->>'name', '')) STORED;
+
+CREATE INDEX IF NOT EXISTS job_ads_area_id_idx ON job_ads (area_id);
+CREATE INDEX IF NOT EXISTS job_ads_employer_id_idx ON job_ads (employer_id);
+CREATE INDEX IF NOT EXISTS job_ads_experience_id_idx ON job_ads (experience_id);
+
+-- Multi-valued fields go to child tables maintained by a trigger.
+CREATE TABLE IF NOT EXISTS employers (
+    id            TEXT PRIMARY KEY,
+    name          TEXT,
+    accredited_it BOOLEAN NOT NULL DEFAULT false,
+    trusted       BOOLEAN NOT NULL DEFAULT false,
+    alternate_url TEXT
+);
+
+CREATE TABLE IF NOT EXISTS areas (
+    id   TEXT PRIMARY KEY,
+    name TEXT
+);
+
+CREATE TABLE IF NOT EXISTS job_work_formats (
+    job_id TEXT NOT NULL REFERENCES job_ads (id) ON DELETE CASCADE,
+    id     TEXT NOT NULL,
+    name   TEXT,
+    PRIMARY KEY (job_id, id)
+);
+
+-- kind is "days" for work_schedule_by_days and "hours" for working_hours.
+CREATE TABLE IF NOT EXISTS job_schedules (
+    job_id TEXT NOT NULL REFERENCES job_ads (id) ON DELETE CASCADE,
+    kind   TEXT NOT NULL,
+    id     TEXT NOT NULL,
+    name   TEXT,
+    PRIMARY KEY (job_id, kind, id)
+);
+
+CREATE TABLE IF NOT EXISTS job_metro_stations (
+    job_id       TEXT NOT NULL REFERENCES job_ads (id) ON DELETE CASCADE,
+    station_id   TEXT NOT NULL,
+    station_name TEXT,
+    line_id      TEXT,
+    line_name    TEXT,
+    lat          DOUBLE PRECISION,
+    lng          DOUBLE PRECISION,
+    PRIMARY KEY (job_id, station_id)
+);
+
+CREATE INDEX IF NOT EXISTS job_metro_stations_station_id_idx ON job_metro_stations (station_id);
+
+CREATE OR REPLACE FUNCTION json_array_or_empty(value JSONB) RETURNS JSONB AS $$
+    SELECT CASE WHEN jsonb_typeof(value) = 'array' THEN value ELSE '[]'::jsonb END
+$$ LANGUAGE sql IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION normalize_job_ad(job job_ads) RETURNS void AS $$
+BEGIN
+    IF job.employer_id IS NOT NULL THEN
+        INSERT INTO employers (id, name, accredited_it, trusted, alternate_url)
+        VALUES (
+            job.employer_id,
+            job.employer_name,
+            coalesce((job.employer->>'accredited_it_employer')::boolean, false),
+            coalesce((job.employer->>'trusted')::boolean, false),
+            nullif(job.employer->>'alternate_url', '')
+        )
+        ON CONFLICT (id) DO UPDATE SET
+            name = EXCLUDED.name,
+            accredited_it = EXCLUDED.accredited_it,
+            trusted = EXCLUDED.trusted,
+            alternate_url = EXCLUDED.alternate_url;
+    END IF;
+
+    IF job.area_id IS NOT NULL THEN
+        INSERT INTO areas (id, name) VALUES (job.area_id, job.area_name)
+        ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name;
+    END IF;
+
+    DELETE FROM job_work_formats WHERE job_id = job.id;
+    INSERT INTO job_work_formats (job_id, id, name)
+    SELECT job.id, f->>'id', f->>'name'
+    FROM jsonb_array_elements(json_array_or_empty(job.work_format)) f
+    WHERE nullif(f->>'id', '') IS NOT NULL
+    ON CONFLICT DO NOTHING;
+
+    DELETE FROM job_schedules WHERE job_id = job.id;
+    INSERT INTO job_schedules (job_id, kind, id, name)
+    SELECT job.id, 'days', s->>'id', s->>'name'
+    FROM jsonb_array_elements(json_array_or_empty(job.work_schedule_by_days)) s
+    WHERE nullif(s->>'id', '') IS NOT NULL
+    UNION ALL
+    SELECT job.id, 'hours', s->>'id', s->>'name'
+    FROM jsonb_array_elements(json_array_or_empty(job.working_hours)) s
+    WHERE nullif(s->>'id', '') IS NOT NULL
+    ON CONFLICT DO NOTHING;
+
+    DELETE FROM job_metro_stations WHERE job_id = job.id;
+    INSERT INTO job_metro_stations (job_id, station_id, station_name, line_id, line_name, lat, lng)
+    SELECT job.id, m->>'station_id', m->>'station_name', m->>'line_id', m->>'line_name',
+        (m->>'lat')::double precision, (m->>'lng')::double precision
+    FROM jsonb_array_elements(json_array_or_empty(job.address->'metro_stations')) m
+    WHERE nullif(m->>'station_id', '') IS NOT NULL
+    ON CONFLICT DO NOTHING;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION job_ads_normalize() RETURNS trigger AS $$
+BEGIN
+    PERFORM normalize_job_ad(NEW);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS job_ads_normalize ON job_ads;
+CREATE TRIGGER job_ads_normalize
+    AFTER INSERT OR UPDATE OF employer, area, work_format, work_schedule_by_days, working_hours, address
+    ON job_ads
+    FOR EACH ROW EXECUTE FUNCTION job_ads_normalize();
+
+-- Backfill the child tables for rows stored before this migration.
+SELECT normalize_job_ad(j) FROM job_ads j;
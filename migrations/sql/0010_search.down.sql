@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS job_ads_search_vector_idx;
+ALTER TABLE job_ads DROP COLUMN IF EXISTS search_vector;
+DROP TEXT SEARCH CONFIGURATION IF EXISTS ru_en;
@@ -0,0 +1,24 @@
+-- ru_en stems Cyrillic words as Russian and Latin words as English, so
+-- "разработчиков Python developers" matches both "разработчик" and "developer".
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = 'ru_en') THEN
+        CREATE TEXT SEARCH CONFIGURATION ru_en (COPY = russian);
+    END IF;
+END
+$$;
+
+ALTER TEXT SEARCH CONFIGURATION ru_en
+    ALTER MAPPING FOR word, hword, hword_part WITH russian_stem;
+ALTER TEXT SEARCH CONFIGURATION ru_en
+    ALTER MAPPING FOR asciiword, asciihword, hword_asciipart WITH english_stem;
+
+-- Titles weigh most, then key skills, then the description.
+ALTER TABLE job_ads
+    ADD COLUMN IF NOT EXISTS search_vector TSVECTOR GENERATED ALWAYS AS (
+        setweight(to_tsvector('ru_en', coalesce(name, '')), 'A') ||
+        setweight(jsonb_to_tsvector('ru_en', coalesce(key_skills, '[]'::jsonb), '["string"]'), 'B') ||
+        setweight(to_tsvector('ru_en', coalesce(description, '')), 'C')
+    ) STORED;
+
+CREATE INDEX IF NOT EXISTS job_ads_search_vector_idx ON job_ads USING GIN (search_vector);
@@ -0,0 +1,15 @@
+-- One row per vacancy a cover letter was sent to. state is 'sent' until the
+-- first sync, then the HH negotiation state (response, invitation, discard,
+-- ...). Applications HH refused are kept as 'rejected' so they are not sent
+-- again.
+CREATE TABLE IF NOT EXISTS negotiations (
+    job_id         TEXT PRIMARY KEY REFERENCES job_ads (id) ON DELETE CASCADE,
+    negotiation_id TEXT,
+    resume_id      TEXT NOT NULL,
+    state          TEXT NOT NULL,
+    error          TEXT,
+    applied_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS negotiations_state_idx ON negotiations (state);
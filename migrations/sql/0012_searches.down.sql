@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS search_runs;
+DROP TABLE IF EXISTS searches;
@@ -0,0 +1,38 @@
+-- Saved searches replace the hard-coded query list. filters holds extra HH
+-- search parameters such as area or experience, each with a list of values.
+-- schedule is an optional cron expression for the daemon; searches without
+-- one run with every fetch.
+CREATE TABLE IF NOT EXISTS searches (
+    id         SERIAL PRIMARY KEY,
+    name       TEXT NOT NULL UNIQUE,
+    query      TEXT NOT NULL,
+    filters    JSONB NOT NULL DEFAULT '{}',
+    schedule   TEXT,
+    owner      TEXT,
+    enabled    BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- One row per search and fetch: how many vacancies HH found, how many were
+-- new, how many were already stored and how many requests failed.
+CREATE TABLE IF NOT EXISTS search_runs (
+    id          BIGSERIAL PRIMARY KEY,
+    search_id   INTEGER NOT NULL REFERENCES searches (id) ON DELETE CASCADE,
+    started_at  TIMESTAMPTZ NOT NULL,
+    finished_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    found       INTEGER NOT NULL,
+    new         INTEGER NOT NULL,
+    duplicates  INTEGER NOT NULL,
+    failed      INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS search_runs_search_id_idx ON search_runs (search_id, started_at DESC);
+
+-- The queries fetch used before searches were stored.
+INSERT INTO searches (name, query, filters)
+SELECT q, q, '{"experience": ["noExperience", "between1And3"]}'
+FROM unnest(ARRAY[
+    'ML Engineer', 'Data science', 'Data Scientist', 'Дата сайентист',
+    'Датасайентист', 'ML', 'Machine Learning Engineer', 'ML-инженер'
+]) AS q
+ON CONFLICT (name) DO NOTHING;
@@ -0,0 +1,22 @@
+-- One row per command or daemon task that changes data. status is
+-- 'running' until the run ends, then 'ok', 'partial' when some items failed
+-- or 'failed' with the error; a row left 'running' without finished_at is a
+-- run that was killed. queries holds the counts of every fetched search.
+CREATE TABLE IF NOT EXISTS runs (
+    id          BIGSERIAL PRIMARY KEY,
+    mode        TEXT NOT NULL,
+    started_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    finished_at TIMESTAMPTZ,
+    status      TEXT NOT NULL DEFAULT 'running',
+    error       TEXT,
+    queries     JSONB NOT NULL DEFAULT '[]',
+    pages       INTEGER NOT NULL DEFAULT 0,
+    details     INTEGER NOT NULL DEFAULT 0,
+    saved       INTEGER NOT NULL DEFAULT 0,
+    skipped     INTEGER NOT NULL DEFAULT 0,
+    failed      INTEGER NOT NULL DEFAULT 0,
+    llm_calls   INTEGER NOT NULL DEFAULT 0,
+    llm_tokens  BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS runs_started_at_idx ON runs (started_at DESC);
@@ -0,0 +1,6 @@
+-- Only one embedding per vacancy fits the old key; the others are dropped.
+DELETE FROM job_embeddings a USING job_embeddings b
+WHERE a.job_id = b.job_id AND a.model > b.model;
+
+ALTER TABLE job_embeddings DROP CONSTRAINT IF EXISTS job_embeddings_pkey;
+ALTER TABLE job_embeddings ADD PRIMARY KEY (job_id);
@@ -0,0 +1,4 @@
+-- Embeddings of different models are kept side by side, so trying a new
+-- model does not overwrite the vectors of the current one.
+ALTER TABLE job_embeddings DROP CONSTRAINT IF EXISTS job_embeddings_pkey;
+ALTER TABLE job_embeddings ADD PRIMARY KEY (job_id, model);
@@ -1,7 +1,9 @@
 package models
 
 import (
+	"database/sql/driver"
 	"encoding/json"
+	"fmt"
 	"time"
 )
 
@@ -36,7 +38,7 @@ type DriverLicenseType struct {
 	ID string `json:"id"`
 }
 
-type Employer struct {
+type Employer struct {
 	NamedEntity
 	Accredited_it_employer bool `json:"accredited_it_employer"`
 	EmployerRating         struct {
@@ -101,7 +103,7 @@ type Salary struct {
 	To       *int   `json:"to"`
 	Currency string `json:"currency"`
 	Gross    bool   `json:"gross"`
-}
+}
 
 type Snippet struct {
 	Requirement    string `json:"requirement"`
@@ -140,6 +142,7 @@ type JobAd struct {
 	Contacts                *Contacts           `json:"contacts"`
 	Department              *NamedEntity        `json:"department"`
 	Descrtiption            string              `json:"description"`
+	DescriptionHTML         string              `json:"-"`
 	DriverLicenseTypes      []DriverLicenseType `json:"driver_license_types"`
 	Employer                Employer            `json:"employer"`
 	EmploymentForm          NamedEntity         `json:"employment_form"`
@@ -229,14 +232,43 @@ type JobSearchResponse struct {
 	PerPage int          `json:"per_page"`
 }
 
+type Negotiation struct {
+	ID        string      `json:"id"`
+	State     NamedEntity `json:"state"`
+	UpdatedAt CustomTime  `json:"updated_at"`
+	Resume    *struct {
+		ID string `json:"id"`
+	} `json:"resume"`
+	Vacancy struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"vacancy"`
+}
+
+type NegotiationsResponse struct {
+	Items   []Negotiation `json:"items"`
+	Found   int           `json:"found"`
+	Page    int           `json:"page"`
+	Pages   int           `json:"pages"`
+	PerPage int           `json:"per_page"`
+}
+
 type GroqAPIRequest struct {
-	Messages []Message `json:"messages"`
-	Model    string    `json:"model"`
+	Messages        []Message       `json:"messages"`
+	Model           string          `json:"model"`
+	ResponseFormat  *ResponseFormat `json:"response_format,omitempty"`
+	ReasoningFormat string          `json:"reasoning_format,omitempty"`
+	Temperature     *float64        `json:"temperature,omitempty"`
+}
+
+type ResponseFormat struct {
+	Type string `json:"type"`
 }
 
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role      string `json:"role"`
+	Content   string `json:"content"`
+	Reasoning string `json:"reasoning,omitempty"`
 }
 
 type GroqAPIResponse struct {
@@ -245,6 +277,7 @@ type GroqAPIResponse struct {
 	Created int64    `json:"created"`
 	Model   string   `json:"model"`
 	Choices []Choice `json:"choices"`
+	Usage   Usage    `json:"usage"`
 }
 
 type Choice struct {
@@ -269,17 +302,62 @@ type Usage struct {
 	TotalTime        float64 `json:"total_time"`
 }
 
+type ProcessedJob struct {
+	JobID         string
+	CoverLetter   string
+	Thinking      string
+	Validation    *LetterValidation
+	PromptHash    string
+	PromptVersion string
+	Model         string
+	Params        map[string]any
+	ProcessedAt   time.Time
+}
+
+type LetterValidation struct {
+	Valid      bool        `json:"valid"`
+	Score      float64     `json:"score"`
+	Attempts   int         `json:"attempts"`
+	Violations []Violation `json:"violations"`
+}
+
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+type Fingerprint struct {
+	JobID     string
+	SimHash   uint64
+	ClusterID string
+	Canonical bool
+}
+
+type JobSkill struct {
+	JobID    string
+	Skill    string
+	Category string
+	Source   string
+}
+
 type CustomTime time.Time
 
 func (ct *CustomTime) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
 	var timeStr string
 	if err := json.Unmarshal(data, &timeStr); err != nil {
 		return err
-	}
+	}
 	const inputTimeFormat = "2006-01-02T15:04:05-0700"
 	t, err := time.Parse(inputTimeFormat, timeStr)
 	if err != nil {
-		return err
+		// MarshalJSON writes RFC 3339, so accept it back as well.
+		var rfcErr error
+		if t, rfcErr = time.Parse(time.RFC3339, timeStr); rfcErr != nil {
+			return err
+		}
 	}
 
 	*ct = CustomTime(t)
@@ -293,3 +371,20 @@ func (ct CustomTime) MarshalJSON() ([]byte, error) {
 func (ct CustomTime) String() string {
 	return time.Time(ct).Format(time.RFC3339)
 }
+
+func (ct CustomTime) Value() (driver.Value, error) {
+	return time.Time(ct), nil
+}
+
+func (ct *CustomTime) Scan(src any) error {
+	if src == nil {
+		*ct = CustomTime{}
+		return nil
+	}
+	t, ok := src.(time.Time)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into CustomTime", src)
+	}
+	*ct = CustomTime(t)
+	return nil
+}
@@ -0,0 +1,204 @@
+package processor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hh_bot/models"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type ResponseParser interface {
+	Name() string
+	Prepare(request *models.GroqAPIRequest)
+	Parse(message models.Message) (*models.ProcessedJob, error)
+	RepairPrompt(err error) string
+}
+
+var (
+	reThinkBlock = regexp.MustCompile(`(?s)<think>(.*?)</think>`)
+	reAfterThink = regexp.MustCompile(`(?s)</think>(.*)`)
+	reJSONObject = regexp.MustCompile("(?s)^```(?:json)?\\s*(\\{.*\\})\\s*```$")
+)
+
+var ErrEmptyCoverLetter = errors.New("cover letter is empty")
+
+type ThinkTagParser struct{}
+
+func (ThinkTagParser) Name() string { return "think" }
+
+func (ThinkTagParser) Prepare(request *models.GroqAPIRequest) {}
+
+func (ThinkTagParser) Parse(message models.Message) (*models.ProcessedJob, error) {
+	think := reThinkBlock.FindStringSubmatch(message.Content)
+	afterThink := reAfterThink.FindStringSubmatch(message.Content)
+
+	if len(think) < 2 || len(afterThink) < 2 {
+		return nil, errors.New("Failed to extract cover letter from LLM response")
+	}
+
+	return newProcessedJob(think[1], afterThink[1])
+}
+
+func (ThinkTagParser) RepairPrompt(err error) string {
+	return fmt.Sprintf("Your previous answer could not be parsed (%s). "+
+		"Reply again with your reasoning inside <think></think> tags followed by the cover letter only.", err)
+}
+
+type ReasoningFieldParser struct{}
+
+func (ReasoningFieldParser) Name() string { return "reasoning" }
+
+func (ReasoningFieldParser) Prepare(request *models.GroqAPIRequest) {
+	request.ReasoningFormat = "parsed"
+}
+
+func (ReasoningFieldParser) Parse(message models.Message) (*models.ProcessedJob, error) {
+	content := message.Content
+	reasoning := message.Reasoning
+	if reasoning == "" {
+		if think := reThinkBlock.FindStringSubmatch(content); len(think) == 2 {
+			reasoning = think[1]
+			content = reThinkBlock.ReplaceAllString(content, "")
+		}
+	}
+
+	return newProcessedJob(reasoning, content)
+}
+
+func (ReasoningFieldParser) RepairPrompt(err error) string {
+	return fmt.Sprintf("Your previous answer could not be parsed (%s). Reply again with the cover letter only.", err)
+}
+
+type JSONParser struct{}
+
+type jsonResponse struct {
+	Analysis    string `json:"analysis"`
+	CoverLetter string `json:"cover_letter"`
+}
+
+func (JSONParser) Name() string { return "json" }
+
+func (JSONParser) Prepare(request *models.GroqAPIRequest) {
+	request.ResponseFormat = &models.ResponseFormat{Type: "json_object"}
+}
+
+func (JSONParser) Parse(message models.Message) (*models.ProcessedJob, error) {
+	content := strings.TrimSpace(message.Content)
+	if match := reJSONObject.FindStringSubmatch(content); len(match) == 2 {
+		content = match[1]
+	}
+
+	var resp jsonResponse
+	if err := json.Unmarshal([]byte(content), &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON response: %w", err)
+	}
+
+	analysis := resp.Analysis
+	if analysis == "" {
+		analysis = message.Reasoning
+	}
+
+	return newProcessedJob(analysis, resp.CoverLetter)
+}
+
+func (JSONParser) RepairPrompt(err error) string {
+	return fmt.Sprintf("Your previous answer could not be parsed (%s). "+
+		`Reply again with a single JSON object of the form {"analysis": "...", "cover_letter": "..."} and nothing else.`, err)
+}
+
+type PlainTextParser struct{}
+
+func (PlainTextParser) Name() string { return "plain" }
+
+func (PlainTextParser) Prepare(request *models.GroqAPIRequest) {}
+
+func (PlainTextParser) Parse(message models.Message) (*models.ProcessedJob, error) {
+	return newProcessedJob(message.Reasoning, message.Content)
+}
+
+func (PlainTextParser) RepairPrompt(err error) string {
+	return fmt.Sprintf("Your previous answer could not be used (%s). Reply again with the cover letter only.", err)
+}
+
+// AutoParser picks a strategy by looking at the response itself. It is used
+// for models that have no explicit strategy registered.
+type AutoParser struct{}
+
+func (AutoParser) Name() string { return "auto" }
+
+func (AutoParser) Prepare(request *models.GroqAPIRequest) {}
+
+func (AutoParser) Parse(message models.Message) (*models.ProcessedJob, error) {
+	content := strings.TrimSpace(message.Content)
+	switch {
+	case strings.Contains(content, "</think>"):
+		return ThinkTagParser{}.Parse(message)
+	case strings.HasPrefix(content, "{") || reJSONObject.MatchString(content):
+		return JSONParser{}.Parse(message)
+	default:
+		return PlainTextParser{}.Parse(message)
+	}
+}
+
+func (AutoParser) RepairPrompt(err error) string {
+	return PlainTextParser{}.RepairPrompt(err)
+}
+
+func newProcessedJob(thinking, coverLetter string) (*models.ProcessedJob, error) {
+	coverLetter = strings.TrimSpace(coverLetter)
+	if coverLetter == "" {
+		return nil, ErrEmptyCoverLetter
+	}
+
+	return &models.ProcessedJob{
+		Thinking:    strings.TrimSpace(thinking),
+		CoverLetter: coverLetter,
+	}, nil
+}
+
+var parsersByName = map[string]ResponseParser{
+	ThinkTagParser{}.Name():       ThinkTagParser{},
+	ReasoningFieldParser{}.Name(): ReasoningFieldParser{},
+	JSONParser{}.Name():           JSONParser{},
+	PlainTextParser{}.Name():      PlainTextParser{},
+	AutoParser{}.Name():           AutoParser{},
+}
+
+var modelParsers = map[string]ResponseParser{
+	"deepseek-r1":    ThinkTagParser{},
+	"qwen-qwq":       ThinkTagParser{},
+	"openai/gpt-oss": ReasoningFieldParser{},
+}
+
+func ParserByName(name string) (ResponseParser, error) {
+	parser, ok := parsersByName[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown response format %q", name)
+	}
+	return parser, nil
+}
+
+// RegisterParser sets the strategy used for every model whose name starts
+// with modelPrefix.
+func RegisterParser(modelPrefix string, parser ResponseParser) {
+	modelParsers[modelPrefix] = parser
+}
+
+func ParserForModel(model string) ResponseParser {
+	prefixes := make([]string, 0, len(modelParsers))
+	for prefix := range modelParsers {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(model, prefix) {
+			return modelParsers[prefix]
+		}
+	}
+
+	return AutoParser{}
+}
@@ -0,0 +1,135 @@
+package processor_test
+
+import (
+	"encoding/json"
+	"hh_bot/models"
+	"hh_bot/processor"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParsers(t *testing.T) {
+	tests := []struct {
+		name        string
+		parser      processor.ResponseParser
+		message     models.Message
+		thinking    string
+		coverLetter string
+		wantErr     bool
+	}{
+		{
+			name:        "think tags",
+			parser:      processor.ThinkTagParser{},
+			message:     models.Message{Content: "<think>\nfits well\n</think>\n\nHello!"},
+			thinking:    "fits well",
+			coverLetter: "Hello!",
+		},
+		{
+			name:    "think tags missing",
+			parser:  processor.ThinkTagParser{},
+			message: models.Message{Content: "Hello!"},
+			wantErr: true,
+		},
+		{
+			name:        "reasoning field",
+			parser:      processor.ReasoningFieldParser{},
+			message:     models.Message{Content: "Hello!", Reasoning: "fits well"},
+			thinking:    "fits well",
+			coverLetter: "Hello!",
+		},
+		{
+			name:        "json",
+			parser:      processor.JSONParser{},
+			message:     models.Message{Content: "```json\n{\"analysis\": \"fits well\", \"cover_letter\": \"Hello!\"}\n```"},
+			thinking:    "fits well",
+			coverLetter: "Hello!",
+		},
+		{
+			name:    "json without cover letter",
+			parser:  processor.JSONParser{},
+			message: models.Message{Content: `{"analysis": "fits well"}`},
+			wantErr: true,
+		},
+		{
+			name:        "plain text",
+			parser:      processor.PlainTextParser{},
+			message:     models.Message{Content: "  Hello!  "},
+			coverLetter: "Hello!",
+		},
+		{
+			name:        "auto detects think tags",
+			parser:      processor.AutoParser{},
+			message:     models.Message{Content: "<think>fits well</think>Hello!"},
+			thinking:    "fits well",
+			coverLetter: "Hello!",
+		},
+		{
+			name:        "auto detects json",
+			parser:      processor.AutoParser{},
+			message:     models.Message{Content: `{"analysis": "fits well", "cover_letter": "Hello!"}`},
+			thinking:    "fits well",
+			coverLetter: "Hello!",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.parser.Parse(tt.message)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Thinking != tt.thinking || result.CoverLetter != tt.coverLetter {
+				t.Fatalf("got (%q, %q), want (%q, %q)", result.Thinking, result.CoverLetter, tt.thinking, tt.coverLetter)
+			}
+		})
+	}
+}
+
+func TestParserForModel(t *testing.T) {
+	if got := processor.ParserForModel("deepseek-r1-distill-llama-70b").Name(); got != "think" {
+		t.Fatalf("expected think parser, got %s", got)
+	}
+	if got := processor.ParserForModel("llama-3.3-70b-versatile").Name(); got != "auto" {
+		t.Fatalf("expected auto parser, got %s", got)
+	}
+}
+
+func TestProcessJobRepairsMalformedResponse(t *testing.T) {
+	calls := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		content := "no tags here"
+		if calls > 1 {
+			content = "<think>fits well</think>Hello!"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(models.GroqAPIResponse{
+			Choices: []models.Choice{{Message: models.Message{Content: content}}},
+		})
+	}))
+	defer mockServer.Close()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	job := &models.JobAd{ID: "1", Descrtiption: "ML Engineer"}
+
+	result, err := processor.ProcessJob(job, processor.NewGroqProvider(client, "key", mockServer.URL), nil, processor.Settings{
+		Model: "deepseek-r1-distill-llama-70b",
+	})
+	if err != nil {
+		t.Fatalf("ProcessJob failed: %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected one repair call, got %d calls", calls)
+	}
+	if result.JobID != "1" || result.CoverLetter != "Hello!" {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+}
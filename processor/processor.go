@@ -3,45 +3,56 @@ package processor
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"hh_bot/htmltext"
+	"hh_bot/metrics"
 	"hh_bot/models"
+	"hh_bot/validator"
 	"io"
-
+	"log/slog"
 	"net/http"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
 )
 
-const MaxRetries = 3
+const (
+	MaxRetries       = 3
+	MaxRegenerations = 2
+)
 
-func RemoveHTMLTags(input string) string {
-	re := regexp.MustCompile(`<[^>]*>`)
-	return re.ReplaceAllString(input, "")
+type Settings struct {
+	Model         string
+	Prompt        string
+	PromptVersion string
+	Temperature   *float64
 }
 
-func reThink(input string) ([]string, error) {
-	reThink := regexp.MustCompile(`<think>(?s)(.*?)</think>`)
-	think := reThink.FindStringSubmatch(input)
+// PromptHash identifies a system prompt by the first 12 hex digits of its
+// SHA-256, so results can be traced back to the prompt that produced them.
+func PromptHash(prompt string) string {
+	sum := sha256.Sum256([]byte(prompt))
+	return hex.EncodeToString(sum[:])[:12]
+}
 
-	reAfterThink := regexp.MustCompile(`</think>(?s)(.*)`)
-	afterThink := reAfterThink.FindStringSubmatch(input)
+func ProcessJobDesctription(text string, client *http.Client, model, prompt, llmApiKey, llmApiURL string) (string, error) {
 
-	if len(think) < 1 || len(afterThink) < 1 {
-		return nil, errors.New("Failed to extract cover letter from LLM response")
+	completion, err := complete(client, newRequest(model, prompt, text, nil), llmApiKey, llmApiURL)
+	if err != nil {
+		return "", err
 	}
 
-	return []string{strings.TrimSpace(think[1]), strings.TrimSpace(afterThink[1])}, nil
+	return completion.Message.Content, nil
 }
 
-func ProcessJobDesctription(text string, client *http.Client, model, prompt, llmApiKey, llmApiURL string) (string, error) {
+func complete(client *http.Client, request models.GroqAPIRequest, llmApiKey, llmApiURL string) (*Completion, error) {
 
-	requestPayload, err := createRequestPayload(model, prompt, text)
+	requestPayload, err := json.Marshal(request)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request payload: %w", err)
+		return nil, fmt.Errorf("failed to create request payload: %w", err)
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -49,14 +60,26 @@ func ProcessJobDesctription(text string, client *http.Client, model, prompt, llm
 
 	var body []byte
 	var lastErr error
+	retryReason := "error"
 
 	for attempt := range MaxRetries {
+		if attempt > 0 {
+			metrics.LLMRetries.WithLabelValues(retryReason).Inc()
+			retryReason = "error"
+		}
 
+		start := time.Now()
 		resp, err := makeGroqApiCall(client, ctx, llmApiKey, llmApiURL, requestPayload)
+		latency := time.Since(start)
+		metrics.LLMRequestDuration.Observe(latency.Seconds())
 		if err != nil {
+			metrics.LLMRequests.WithLabelValues(metrics.Status(0, err)).Inc()
+			slog.Debug("LLM request failed", "model", request.Model, "attempt", attempt+1, "latency", latency, "err", err)
 			lastErr = fmt.Errorf("failed to make API call: %w", err)
 			continue
 		}
+		metrics.LLMRequests.WithLabelValues(metrics.Status(resp.StatusCode, nil)).Inc()
+		slog.Debug("LLM request", "model", request.Model, "attempt", attempt+1, "status", resp.StatusCode, "latency", latency)
 
 		defer resp.Body.Close()
 
@@ -66,10 +89,12 @@ func ProcessJobDesctription(text string, client *http.Client, model, prompt, llm
 				lastErr = fmt.Errorf("Failed to decode API response %w", err)
 				continue
 			}
+			metrics.LLMTokens.Add(float64(apiResp.Usage.TotalTokens))
 			return apiResp, nil
 		}
 
 		if resp.StatusCode == http.StatusTooManyRequests {
+			retryReason = "rate_limit"
 			retryTimeHeader := resp.Header.Get("retry-after")
 			retryTime, err := strconv.Atoi(retryTimeHeader)
 			if err != nil {
@@ -77,14 +102,14 @@ func ProcessJobDesctription(text string, client *http.Client, model, prompt, llm
 				continue
 			}
 
-			fmt.Printf("Rate limited - retrying request in %d seconds (attempt %d/%d)\n",
-				retryTime, attempt+1, MaxRetries)
+			slog.Warn("LLM rate limited, retrying", "retry_in", time.Duration(retryTime)*time.Second,
+				"attempt", attempt+1, "max_attempts", MaxRetries)
 
 			select {
 			case <-time.After(time.Duration(retryTime) * time.Second):
 				continue
 			case <-ctx.Done():
-				return "", ctx.Err()
+				return nil, ctx.Err()
 			}
 
 		}
@@ -95,28 +120,43 @@ func ProcessJobDesctription(text string, client *http.Client, model, prompt, llm
 			continue
 		}
 
-		lastErr = fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, body)
+		lastErr = fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, summarizeBody(body))
+	}
+
+	return nil, fmt.Errorf("max retries (%d) exceeded, last error: %w", MaxRetries, lastErr)
+}
+
+// summarizeBody reduces an error response to the message of an
+// OpenAI-style error, or else to an excerpt.
+func summarizeBody(body []byte) string {
+	var response struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &response) == nil && response.Error.Message != "" {
+		return response.Error.Message
 	}
 
-	return "", fmt.Errorf("max retries (%d) exceeded, last error: %w", MaxRetries, lastErr)
+	return htmltext.Excerpt(string(body), 200)
 }
 
-func decodeApiResponse(response *http.Response) (string, error) {
+func decodeApiResponse(response *http.Response) (*Completion, error) {
 	var apiResponse models.GroqAPIResponse
 	err := json.NewDecoder(response.Body).Decode(&apiResponse)
 	if err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
+		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
 	if len(apiResponse.Choices) == 0 {
-		return "", fmt.Errorf("no choices found in response")
+		return nil, fmt.Errorf("no choices found in response")
 	}
 
-	return apiResponse.Choices[0].Message.Content, nil
+	return &Completion{Message: apiResponse.Choices[0].Message, Usage: apiResponse.Usage}, nil
 }
 
-func createRequestPayload(model, system, text string) ([]byte, error) {
-	requestPayload := models.GroqAPIRequest{
+func newRequest(model, system, text string, temperature *float64) models.GroqAPIRequest {
+	return models.GroqAPIRequest{
 		Messages: []models.Message{
 			{
 				Role:    "system",
@@ -127,16 +167,35 @@ func createRequestPayload(model, system, text string) ([]byte, error) {
 				Content: text,
 			},
 		},
-		Model: model,
+		Model:       model,
+		Temperature: temperature,
 	}
+}
 
-	payloadBytes, err := json.Marshal(requestPayload)
-
-	if err != nil {
-		return nil, err
+// JobText is the user message for a job ad: its title, employer and key
+// skills followed by the description. Fields that were not loaded are left
+// out, so a bare description is sent as is.
+func JobText(job *models.JobAd) string {
+	var b strings.Builder
+	if job.Name != "" {
+		fmt.Fprintf(&b, "Vacancy: %s\n", job.Name)
 	}
-
-	return payloadBytes, nil
+	if job.Employer.Name != "" {
+		fmt.Fprintf(&b, "Employer: %s\n", job.Employer.Name)
+	}
+	if len(job.KeySkills) > 0 {
+		names := make([]string, len(job.KeySkills))
+		for i, skill := range job.KeySkills {
+			names[i] = skill.Name
+		}
+		fmt.Fprintf(&b, "Key skills: %s\n", strings.Join(names, ", "))
+	}
+	if b.Len() == 0 {
+		return job.Descrtiption
+	}
+	b.WriteString("\n")
+	b.WriteString(job.Descrtiption)
+	return b.String()
 }
 
 func makeGroqApiCall(client *http.Client, ctx context.Context, apiKey, apiURL string, requestPayload []byte) (*http.Response, error) {
@@ -157,18 +216,144 @@ func makeGroqApiCall(client *http.Client, ctx context.Context, apiKey, apiURL st
 	return resp, nil
 }
 
-func ProcessJob(job *models.JobAd, client *http.Client, model, prompt, llmApiKey, llmApiURL string) ([]string, error) {
+func ProcessJob(job *models.JobAd, provider Provider, letterValidator *validator.Validator, settings Settings) (*models.ProcessedJob, error) {
 
-	processedText, err := ProcessJobDesctription(job.Descrtiption, client, model, prompt, llmApiKey, llmApiURL)
+	draft, err := NewDraft(job, provider, settings)
 	if err != nil {
 		return nil, err
 	}
 
-	text, err := reThink(processedText)
+	return draft.Finish(provider, letterValidator), nil
+}
+
+// Draft is the first letter generated for a vacancy, kept with the
+// conversation that produced it so it can still be regenerated.
+type Draft struct {
+	Letter *models.ProcessedJob
+
+	job      *models.JobAd
+	parser   ResponseParser
+	request  models.GroqAPIRequest
+	settings Settings
+}
+
+// NewDraft generates the first letter for job without validating it.
+func NewDraft(job *models.JobAd, provider Provider, settings Settings) (*Draft, error) {
+
+	parser := ParserForModel(settings.Model)
+	request := newRequest(settings.Model, settings.Prompt, JobText(job), settings.Temperature)
+	parser.Prepare(&request)
+
+	letter, err := generate(provider, parser, &request, job.ID)
 	if err != nil {
-		fmt.Printf("Failed extact data from LLM: %s\n", err)
 		return nil, err
 	}
 
-	return text, nil
+	return &Draft{Letter: letter, job: job, parser: parser, request: request, settings: settings}, nil
+}
+
+// Finish validates the draft letter, regenerating it on violations when a
+// validator is given, and stamps the result with the prompt and model.
+func (d *Draft) Finish(provider Provider, letterValidator *validator.Validator) *models.ProcessedJob {
+
+	params := map[string]any{"response_format": d.parser.Name()}
+	if d.settings.Temperature != nil {
+		params["temperature"] = *d.settings.Temperature
+	}
+
+	result := d.Letter
+	if letterValidator != nil {
+		params["max_regenerations"] = MaxRegenerations
+		result = regenerateUntilValid(provider, d.parser, &d.request, d.job, result, letterValidator)
+	}
+
+	result.JobID = d.job.ID
+	result.PromptHash = PromptHash(d.settings.Prompt)
+	result.PromptVersion = d.settings.PromptVersion
+	result.Model = d.settings.Model
+	result.Params = params
+	result.ProcessedAt = time.Now()
+
+	return result
+}
+
+// regenerateUntilValid feeds validation failures back to the model and keeps
+// the best scoring letter. A regeneration that scores worse is dropped from
+// the conversation, so the next feedback follows the letter it describes.
+func regenerateUntilValid(provider Provider, parser ResponseParser, request *models.GroqAPIRequest, job *models.JobAd, result *models.ProcessedJob, letterValidator *validator.Validator) *models.ProcessedJob {
+
+	result.Validation = letterValidator.Validate(result.CoverLetter, job)
+	result.Validation.Attempts = 1
+
+	for attempt := 1; attempt <= MaxRegenerations && !result.Validation.Valid; attempt++ {
+		slog.Info("cover letter failed validation, regenerating", "vacancy_id", job.ID,
+			"violations", len(result.Validation.Violations), "attempt", attempt, "max_attempts", MaxRegenerations)
+
+		metrics.LLMRetries.WithLabelValues("validation").Inc()
+		history := len(request.Messages)
+		request.Messages = append(request.Messages, models.Message{Role: "user", Content: validationFeedback(result.Validation)})
+
+		regenerated, err := generate(provider, parser, request, job.ID)
+		if err != nil {
+			slog.Warn("failed to regenerate cover letter", "vacancy_id", job.ID, "attempt", attempt, "err", err)
+			break
+		}
+
+		validation := letterValidator.Validate(regenerated.CoverLetter, job)
+		validation.Attempts = result.Validation.Attempts + 1
+		if validation.Score >= result.Validation.Score {
+			regenerated.Validation = validation
+			result = regenerated
+		} else {
+			result.Validation.Attempts = validation.Attempts
+			request.Messages = request.Messages[:history]
+		}
+	}
+
+	return result
+}
+
+// generate sends the request and parses the answer, giving the model one
+// chance to repair a malformed response. The assistant reply is appended to
+// the request so follow-up prompts keep the conversation.
+func generate(provider Provider, parser ResponseParser, request *models.GroqAPIRequest, jobID string) (*models.ProcessedJob, error) {
+
+	completion, err := provider.Complete(*request)
+	if err != nil {
+		return nil, err
+	}
+	request.Messages = append(request.Messages, models.Message{Role: "assistant", Content: completion.Message.Content})
+
+	result, err := parser.Parse(completion.Message)
+	if err == nil {
+		return result, nil
+	}
+
+	slog.Warn("failed to parse response, asking the model to repair it", "vacancy_id", jobID, "format", parser.Name(), "err", err)
+
+	metrics.LLMRetries.WithLabelValues("repair").Inc()
+	request.Messages = append(request.Messages, models.Message{Role: "user", Content: parser.RepairPrompt(err)})
+
+	completion, err = provider.Complete(*request)
+	if err != nil {
+		return nil, err
+	}
+	request.Messages = append(request.Messages, models.Message{Role: "assistant", Content: completion.Message.Content})
+
+	result, err = parser.Parse(completion.Message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse repaired %s response: %w", parser.Name(), err)
+	}
+
+	return result, nil
+}
+
+func validationFeedback(validation *models.LetterValidation) string {
+	var b strings.Builder
+	b.WriteString("The cover letter failed these checks:\n")
+	for _, violation := range validation.Violations {
+		fmt.Fprintf(&b, "- %s: %s\n", violation.Rule, violation.Message)
+	}
+	b.WriteString("Rewrite the cover letter fixing only these problems and answer in the same format as before.")
+	return b.String()
 }
@@ -5,8 +5,10 @@ import (
 	"hh_bot/config"
 	"hh_bot/models"
 	"hh_bot/processor"
+	"hh_bot/validator"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -44,9 +46,9 @@ func TestMakeGroqApiCall(t *testing.T) {
 		json.NewEncoder(w).Encode(resp)
 	}))
 	defer mockServer.Close()
-
+
 	client := &http.Client{Timeout: 5 * time.Second}
-
+
 	// Test case: Successful API call
 	t.Run("Successful API call", func(t *testing.T) {
 		conf := &config.Config{LLMAPIURL: mockServer.URL + "/mock-endpoint", LLMAPIKey: "valid_key"}
@@ -68,4 +70,75 @@ func TestMakeGroqApiCall(t *testing.T) {
 			t.Fatalf("API call failed: %v", err)
 		}
 	})
-}
+}
+
+func TestJobText(t *testing.T) {
+	job := &models.JobAd{Descrtiption: "Ищем ML-инженера."}
+	if got := processor.JobText(job); got != job.Descrtiption {
+		t.Fatalf("JobText without context = %q", got)
+	}
+
+	job.Name = "ML-инженер"
+	job.Employer.Name = "Яндекс"
+	job.KeySkills = []models.KeySkill{{Name: "Python"}, {Name: "PyTorch"}}
+	want := "Vacancy: ML-инженер\nEmployer: Яндекс\nKey skills: Python, PyTorch\n\nИщем ML-инженера."
+	if got := processor.JobText(job); got != want {
+		t.Fatalf("JobText = %q, want %q", got, want)
+	}
+}
+
+func TestPromptHash(t *testing.T) {
+	tests := []struct {
+		prompt, want string
+	}{
+		{"", "e3b0c44298fc"},
+		{"You write cover letters.", "88282e5c2b6b"},
+		// Any change to the prompt, even whitespace, is a new version.
+		{"You write cover letters. ", "e98fd66cc278"},
+	}
+	for _, tt := range tests {
+		if got := processor.PromptHash(tt.prompt); got != tt.want {
+			t.Errorf("PromptHash(%q) = %s, want %s", tt.prompt, got, tt.want)
+		}
+	}
+}
+
+// scriptedProvider answers with its replies in turn and keeps the messages
+// of every request.
+type scriptedProvider struct {
+	replies  []string
+	requests [][]models.Message
+}
+
+func (p *scriptedProvider) Complete(request models.GroqAPIRequest) (*processor.Completion, error) {
+	p.requests = append(p.requests, append([]models.Message(nil), request.Messages...))
+	reply := p.replies[len(p.requests)-1]
+	return &processor.Completion{Message: models.Message{Role: "assistant", Content: reply}}, nil
+}
+
+func TestProcessJobDropsWorseRegeneration(t *testing.T) {
+	provider := &scriptedProvider{replies: []string{"Bad letter about Go.", "Bad letter.", "Fine letter about Go."}}
+	letterValidator := validator.New(
+		validator.BannedPhrasesRule{Phrases: []string{"bad"}},
+		validator.RequiredMentionsRule{Mentions: []string{"Go"}},
+	)
+	job := &models.JobAd{ID: "1", Descrtiption: "Go developer"}
+
+	result, err := processor.ProcessJob(job, provider, letterValidator, processor.Settings{Model: "llama-3.3-70b-versatile"})
+	if err != nil {
+		t.Fatalf("ProcessJob failed: %v", err)
+	}
+	if result.CoverLetter != "Fine letter about Go." || !result.Validation.Valid || result.Validation.Attempts != 3 {
+		t.Fatalf("unexpected result: %q %+v", result.CoverLetter, result.Validation)
+	}
+
+	// The second letter scored worse, so the last request follows the first
+	// letter with its own feedback.
+	last := provider.requests[2]
+	if len(last) != 4 {
+		t.Fatalf("last request has %d messages, want 4: %+v", len(last), last)
+	}
+	if last[2].Content != "Bad letter about Go." || strings.Contains(last[3].Content, "does not mention") {
+		t.Errorf("feedback does not follow the letter it describes: %+v", last[2:])
+	}
+}
@@ -0,0 +1,70 @@
+package processor
+
+import (
+	"hh_bot/models"
+	"net/http"
+	"sync"
+)
+
+// Provider sends a chat completion request to an LLM backend.
+type Provider interface {
+	Complete(request models.GroqAPIRequest) (*Completion, error)
+}
+
+type Completion struct {
+	Message models.Message
+	Usage   models.Usage
+}
+
+// GroqProvider talks to an OpenAI-compatible chat completions endpoint such
+// as the one exposed by groq.com.
+type GroqProvider struct {
+	client *http.Client
+	apiKey string
+	apiURL string
+}
+
+func NewGroqProvider(client *http.Client, apiKey, apiURL string) *GroqProvider {
+	return &GroqProvider{client: client, apiKey: apiKey, apiURL: apiURL}
+}
+
+func (p *GroqProvider) Complete(request models.GroqAPIRequest) (*Completion, error) {
+	return complete(p.client, request, p.apiKey, p.apiURL)
+}
+
+// MeteredProvider counts the calls made to a provider and the tokens they
+// used. It is safe for concurrent use.
+type MeteredProvider struct {
+	Provider
+	mu sync.Mutex
+	// calls includes the failed calls, which use no tokens; usage only
+	// sums the tokens the provider reported for answered calls.
+	calls int
+	usage models.Usage
+}
+
+func NewMeteredProvider(provider Provider) *MeteredProvider {
+	return &MeteredProvider{Provider: provider}
+}
+
+func (p *MeteredProvider) Complete(request models.GroqAPIRequest) (*Completion, error) {
+	completion, err := p.Provider.Complete(request)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	if err == nil {
+		p.usage.PromptTokens += completion.Usage.PromptTokens
+		p.usage.CompletionTokens += completion.Usage.CompletionTokens
+		p.usage.TotalTokens += completion.Usage.TotalTokens
+	}
+	return completion, err
+}
+
+// Usage returns the calls made so far, failed ones included, and the sum of
+// the token counts of the answered ones.
+func (p *MeteredProvider) Usage() (int, models.Usage) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls, p.usage
+}
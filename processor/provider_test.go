@@ -0,0 +1,50 @@
+package processor_test
+
+import (
+	"errors"
+	"hh_bot/models"
+	"hh_bot/processor"
+	"sync"
+	"testing"
+)
+
+type stubProvider struct {
+	usage models.Usage
+	err   error
+}
+
+func (p stubProvider) Complete(request models.GroqAPIRequest) (*processor.Completion, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &processor.Completion{Usage: p.usage}, nil
+}
+
+func TestMeteredProvider(t *testing.T) {
+	answered := processor.NewMeteredProvider(stubProvider{usage: models.Usage{PromptTokens: 70, CompletionTokens: 30, TotalTokens: 100}})
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := answered.Complete(models.GroqAPIRequest{}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	calls, usage := answered.Usage()
+	want := models.Usage{PromptTokens: 700, CompletionTokens: 300, TotalTokens: 1000}
+	if calls != 10 || usage != want {
+		t.Errorf("Usage() = %d, %+v; want 10, %+v", calls, usage, want)
+	}
+
+	failing := processor.NewMeteredProvider(stubProvider{err: errors.New("rate limited")})
+	if _, err := failing.Complete(models.GroqAPIRequest{}); err == nil {
+		t.Fatal("expected the provider error")
+	}
+	if calls, usage := failing.Usage(); calls != 1 || usage != (models.Usage{}) {
+		t.Errorf("a failed call must count without tokens, got %d, %+v", calls, usage)
+	}
+}
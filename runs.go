@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hh_bot/storage"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// runStats collects the counts of a run and holds its logger. It is safe
+// for concurrent use, and a nil *runStats drops the counts.
+type runStats struct {
+	mu  sync.Mutex
+	run storage.Run
+	log *slog.Logger
+}
+
+// logger returns the logger of the run, which adds the run_id of a stored
+// run to every record, or the default logger outside of a run.
+func (s *runStats) logger() *slog.Logger {
+	if s == nil || s.log == nil {
+		return slog.Default()
+	}
+	return s.log
+}
+
+func (s *runStats) add(f func(run *storage.Run)) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f(&s.run)
+}
+
+// record runs f as a run of mode. On Postgres the run is stored in the
+// runs table when it starts and updated with its counts and outcome when f
+// returns; failing to store it does not stop f. f logs through
+// stats.logger(), so its records carry the run_id of a stored run.
+func (a *app) record(mode string, f func(stats *runStats) error) error {
+	stats := &runStats{run: storage.Run{Mode: mode, Status: storage.RunRunning}, log: slog.Default()}
+	if a.dbpool != nil {
+		id, startedAt, err := storage.StartRun(a.dbpool, mode)
+		if err != nil {
+			slog.Warn("the run will not be recorded", "mode", mode, "err", err)
+		}
+		stats.run.ID, stats.run.StartedAt = id, startedAt
+	}
+	if stats.run.ID != 0 {
+		stats.log = stats.log.With("run_id", stats.run.ID)
+	}
+
+	start := time.Now()
+	stats.log.Info("run started", "mode", mode)
+	err := f(stats)
+
+	status := runStatus(err)
+	attrs := []any{"mode", mode, "status", status, "latency", time.Since(start)}
+	switch status {
+	case storage.RunOK:
+		stats.log.Info("run finished", attrs...)
+	case storage.RunPartial:
+		stats.log.Warn("run finished", append(attrs, "err", err)...)
+	default:
+		stats.log.Error("run finished", append(attrs, "err", err)...)
+	}
+
+	if stats.run.ID != 0 {
+		stats.add(func(run *storage.Run) {
+			run.Status = status
+			if err != nil {
+				run.Error = err.Error()
+			}
+		})
+		if err := storage.FinishRun(a.dbpool, stats.run); err != nil {
+			stats.log.Warn("failed to record the end of the run", "err", err)
+		}
+	}
+	return err
+}
+
+// runStatus is partial when every error is a partialError.
+func runStatus(err error) string {
+	switch {
+	case err == nil:
+		return storage.RunOK
+	case onlyPartial(err):
+		return storage.RunPartial
+	default:
+		return storage.RunFailed
+	}
+}
+
+func onlyPartial(err error) bool {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			if !onlyPartial(err) {
+				return false
+			}
+		}
+		return true
+	}
+	var partialErr partialError
+	return errors.As(err, &partialErr)
+}
+
+// showRuns lists the latest runs, or prints the summary of one.
+func showRuns(dbpool *pgxpool.Pool, id int64, limit int, problemsOnly bool) error {
+	if id != 0 {
+		run, err := storage.LoadRun(dbpool, id)
+		if err != nil {
+			return err
+		}
+		printRun(run)
+		return nil
+	}
+
+	runs, err := storage.LoadRuns(dbpool, limit, problemsOnly)
+	if err != nil {
+		return err
+	}
+	for _, run := range runs {
+		fmt.Printf("%d\t%s\t%s\t%s\t%s\t%s\n", run.ID, run.StartedAt.Local().Format(time.DateTime),
+			runDuration(run), run.Mode, runState(run), runCounts(run))
+	}
+	fmt.Printf("%d runs.\n", len(runs))
+	return nil
+}
+
+func printRun(run storage.Run) {
+	fmt.Printf("Run %d: %s, %s\n", run.ID, run.Mode, runState(run))
+	if run.Error != "" {
+		fmt.Printf("Error: %s\n", run.Error)
+	}
+	fmt.Printf("Started %s, took %s\n", run.StartedAt.Local().Format(time.DateTime), runDuration(run))
+	fmt.Printf("%s\n", runCounts(run))
+	if len(run.Queries) > 0 {
+		fmt.Printf("Searches:\n")
+		for _, q := range run.Queries {
+			fmt.Printf("  %s\tfound %d, %d new, %d already stored, %d failed requests\n",
+				q.Query, q.Found, q.New, q.Duplicates, q.Failed)
+		}
+	}
+}
+
+// runState marks runs that need a look in capitals.
+func runState(run storage.Run) string {
+	switch run.Status {
+	case storage.RunOK:
+		return "ok"
+	case storage.RunRunning:
+		return "RUNNING OR KILLED"
+	default:
+		return strings.ToUpper(run.Status)
+	}
+}
+
+func runDuration(run storage.Run) string {
+	if run.FinishedAt == nil {
+		return "-"
+	}
+	return run.FinishedAt.Sub(run.StartedAt).Round(time.Second).String()
+}
+
+func runCounts(run storage.Run) string {
+	counts := []struct {
+		name  string
+		value int
+	}{
+		{"pages", run.Pages},
+		{"details", run.Details},
+		{"saved", run.Saved},
+		{"skipped", run.Skipped},
+		{"failed", run.Failed},
+		{"LLM calls", run.LLMCalls},
+		{"tokens", run.LLMTokens},
+	}
+	var parts []string
+	for _, c := range counts {
+		if c.value != 0 || c.name == "saved" || c.name == "failed" {
+			parts = append(parts, fmt.Sprintf("%s %d", c.name, c.value))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,265 @@
+package scheduler
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Kinds of tasks the daemon runs.
+const (
+	KindFetch   = "fetch"
+	KindProcess = "process"
+	KindSync    = "sync"
+	KindRecheck = "recheck"
+)
+
+//go:embed schedule.json
+var scheduleJSON []byte
+
+// Task is a scheduled run of one of the kinds above. Cron is a standard
+// five-field expression such as "*/15 * * * *", or a descriptor such as
+// "@hourly".
+type Task struct {
+	Name string `json:"name"`
+	Kind string `json:"task"`
+	Cron string `json:"cron"`
+	// Queries or Search, the name of a saved search, select what a fetch
+	// task fetches; the enabled saved searches without a schedule of their
+	// own are fetched when both are empty.
+	Queries []string `json:"queries,omitempty"`
+	Search  string   `json:"search,omitempty"`
+}
+
+// LoadTasks returns the built-in schedule, or the one in path if it is not
+// empty. A schedule file replaces the built-in one as a whole.
+func LoadTasks(path string) ([]Task, error) {
+	data := scheduleJSON
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("failed to read schedule: %w", err)
+		}
+	}
+
+	var tasks []Task
+	if err := json.Unmarshal(data, &tasks); err != nil {
+		return nil, fmt.Errorf("invalid schedule: %w", err)
+	}
+	if _, err := parse(tasks); err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+func parse(tasks []Task) ([]cron.Schedule, error) {
+	if len(tasks) == 0 {
+		return nil, fmt.Errorf("the schedule has no tasks")
+	}
+
+	schedules := make([]cron.Schedule, len(tasks))
+	names := make(map[string]bool)
+	for i, task := range tasks {
+		if task.Name == "" {
+			return nil, fmt.Errorf("task %d has no name", i+1)
+		}
+		if names[task.Name] {
+			return nil, fmt.Errorf("task %s is listed twice", task.Name)
+		}
+		names[task.Name] = true
+
+		switch task.Kind {
+		case KindFetch, KindProcess, KindSync, KindRecheck:
+		default:
+			return nil, fmt.Errorf("task %s: unknown task %q, want %s, %s, %s or %s",
+				task.Name, task.Kind, KindFetch, KindProcess, KindSync, KindRecheck)
+		}
+		if (len(task.Queries) > 0 || task.Search != "") && task.Kind != KindFetch {
+			return nil, fmt.Errorf("task %s: only fetch tasks take queries or a search", task.Name)
+		}
+		if len(task.Queries) > 0 && task.Search != "" {
+			return nil, fmt.Errorf("task %s: queries and search exclude each other", task.Name)
+		}
+
+		schedule, err := cron.ParseStandard(task.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("task %s: %w", task.Name, cronError(task.Cron, err))
+		}
+		schedules[i] = schedule
+	}
+	return schedules, nil
+}
+
+// CheckCron reports whether expr is a valid schedule.
+func CheckCron(expr string) error {
+	if _, err := cron.ParseStandard(expr); err != nil {
+		return cronError(expr, err)
+	}
+	return nil
+}
+
+func cronError(expr string, err error) error {
+	return fmt.Errorf("invalid cron %q: %w", expr, err)
+}
+
+// TaskStatus is what the daemon reports about a task.
+type TaskStatus struct {
+	Task
+	Running   bool       `json:"running"`
+	Runs      int        `json:"runs"`
+	Failures  int        `json:"failures"`
+	LastStart *time.Time `json:"last_start,omitempty"`
+	LastEnd   *time.Time `json:"last_end,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+	NextRun   time.Time  `json:"next_run"`
+}
+
+type Status struct {
+	StartedAt time.Time    `json:"started_at"`
+	Tasks     []TaskStatus `json:"tasks"`
+}
+
+// Scheduler runs tasks one at a time, so a fetch never overlaps with a
+// process run of the same daemon. Every run starts up to Jitter after its
+// cron time, which spreads the requests of several deployments. A task that
+// is due while another one runs starts right after it; missed runs are not
+// made up.
+type Scheduler struct {
+	// Now and After are the clock; tests replace them.
+	Now   func() time.Time
+	After func(time.Duration) <-chan time.Time
+	// OnChange is called with the new status whenever a task starts or
+	// ends, for example to write a status file.
+	OnChange func(Status)
+
+	jitter    time.Duration
+	schedules []cron.Schedule
+
+	mu     sync.Mutex
+	status Status
+}
+
+func New(tasks []Task, jitter time.Duration) (*Scheduler, error) {
+	schedules, err := parse(tasks)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Scheduler{
+		Now:       time.Now,
+		After:     time.After,
+		jitter:    jitter,
+		schedules: schedules,
+	}
+	for _, task := range tasks {
+		s.status.Tasks = append(s.status.Tasks, TaskStatus{Task: task})
+	}
+	return s, nil
+}
+
+func (s *Scheduler) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.status
+	status.Tasks = append([]TaskStatus(nil), s.status.Tasks...)
+	return status
+}
+
+func (s *Scheduler) next(i int, after time.Time) time.Time {
+	next := s.schedules[i].Next(after)
+	if s.jitter > 0 {
+		next = next.Add(rand.N(s.jitter))
+	}
+	return next
+}
+
+// update changes the status under the lock and reports it.
+func (s *Scheduler) update(change func(status *Status)) {
+	s.mu.Lock()
+	change(&s.status)
+	status := s.status
+	status.Tasks = append([]TaskStatus(nil), s.status.Tasks...)
+	s.mu.Unlock()
+
+	if s.OnChange != nil {
+		s.OnChange(status)
+	}
+}
+
+// Run runs due tasks until ctx is done. Errors of run are recorded in the
+// status and do not stop the scheduler. A task that is running when ctx is
+// done is waited for.
+func (s *Scheduler) Run(ctx context.Context, run func(ctx context.Context, task Task) error) {
+	s.update(func(status *Status) {
+		status.StartedAt = s.Now()
+		for i := range status.Tasks {
+			status.Tasks[i].NextRun = s.next(i, status.StartedAt)
+		}
+	})
+
+	for {
+		due := 0
+		s.mu.Lock()
+		for i, task := range s.status.Tasks {
+			if task.NextRun.Before(s.status.Tasks[due].NextRun) {
+				due = i
+			}
+		}
+		task := s.status.Tasks[due]
+		s.mu.Unlock()
+
+		if wait := task.NextRun.Sub(s.Now()); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.After(wait):
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		start := s.Now()
+		s.update(func(status *Status) {
+			status.Tasks[due].Running = true
+			status.Tasks[due].LastStart = &start
+		})
+
+		err := run(ctx, task.Task)
+
+		end := s.Now()
+		s.update(func(status *Status) {
+			t := &status.Tasks[due]
+			t.Running = false
+			t.Runs++
+			t.LastEnd = &end
+			t.LastError = ""
+			if err != nil {
+				t.Failures++
+				t.LastError = err.Error()
+			}
+			t.NextRun = s.next(due, end)
+		})
+	}
+}
+
+// WriteStatusFile replaces path with the status as JSON. The file is
+// written next to path and renamed, so readers never see half of it.
+func WriteStatusFile(path string, status Status) error {
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
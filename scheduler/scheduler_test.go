@@ -0,0 +1,145 @@
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"hh_bot/scheduler"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadTasks(t *testing.T) {
+	tasks, err := scheduler.LoadTasks("")
+	if err != nil {
+		t.Fatalf("built-in schedule: %v", err)
+	}
+	if len(tasks) == 0 {
+		t.Fatalf("built-in schedule is empty")
+	}
+
+	invalid := map[string]string{
+		`[]`: "no tasks",
+		`[{"name": "a", "task": "fetch", "cron": "every minute"}]`:                                             "invalid cron",
+		`[{"name": "a", "task": "apply", "cron": "@hourly"}]`:                                                  "unknown task",
+		`[{"name": "a", "task": "fetch", "cron": "@hourly"}, {"name": "a", "task": "sync", "cron": "@daily"}]`: "listed twice",
+		`[{"name": "a", "task": "fetch", "cron": "@hourly", "queries": ["ML"], "search": "ML"}]`:               "exclude each other",
+		`[{"name": "a", "task": "process", "cron": "@hourly", "queries": ["ML"]}]`:                             "only fetch tasks",
+	}
+	for data, want := range invalid {
+		path := filepath.Join(t.TempDir(), "schedule.json")
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := scheduler.LoadTasks(path); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("LoadTasks(%s) = %v, want an error about %q", data, err, want)
+		}
+	}
+}
+
+// fakeClock jumps forward instead of waiting.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func TestRun(t *testing.T) {
+	tasks := []scheduler.Task{
+		{Name: "often", Kind: scheduler.KindProcess, Cron: "* * * * *"},
+		{Name: "rarely", Kind: scheduler.KindFetch, Cron: "*/2 * * * *", Queries: []string{"ML"}},
+	}
+	s, err := scheduler.New(tasks, 0)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 30, 0, time.UTC)}
+	s.Now, s.After = clock.Now, clock.After
+
+	changes := 0
+	s.OnChange = func(scheduler.Status) { changes++ }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var ran []string
+	var times []string
+	s.Run(ctx, func(ctx context.Context, task scheduler.Task) error {
+		ran = append(ran, task.Name)
+		times = append(times, clock.now.Format("15:04"))
+		// Every run takes 10 seconds.
+		clock.now = clock.now.Add(10 * time.Second)
+		if len(ran) == 5 {
+			cancel()
+		}
+		if task.Name == "rarely" {
+			return errors.New("HH is down")
+		}
+		return nil
+	})
+
+	if want := []string{"often", "often", "rarely", "often", "often"}; !reflect.DeepEqual(ran, want) {
+		t.Fatalf("ran %v, want %v", ran, want)
+	}
+	if want := []string{"12:01", "12:02", "12:02", "12:03", "12:04"}; !reflect.DeepEqual(times, want) {
+		t.Fatalf("ran at %v, want %v", times, want)
+	}
+	if changes != 11 {
+		t.Errorf("OnChange called %d times, want 11", changes)
+	}
+
+	status := s.Status()
+	often, rarely := status.Tasks[0], status.Tasks[1]
+	if often.Runs != 4 || often.Failures != 0 || often.LastError != "" || often.Running {
+		t.Errorf("unexpected status of often: %+v", often)
+	}
+	if rarely.Runs != 1 || rarely.Failures != 1 || rarely.LastError != "HH is down" {
+		t.Errorf("unexpected status of rarely: %+v", rarely)
+	}
+	if want := time.Date(2024, 5, 1, 12, 4, 0, 0, time.UTC); !rarely.NextRun.Equal(want) {
+		t.Errorf("next run of rarely = %v, want %v", rarely.NextRun, want)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	tasks := []scheduler.Task{{Name: "hourly", Kind: scheduler.KindSync, Cron: "@hourly"}}
+	s, err := scheduler.New(tasks, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	start := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	s.Now = func() time.Time { return start }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.After = func(time.Duration) <-chan time.Time {
+		cancel()
+		return nil
+	}
+	s.Run(ctx, func(context.Context, scheduler.Task) error { return nil })
+
+	next := s.Status().Tasks[0].NextRun
+	earliest := time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC)
+	if next.Before(earliest) || !next.Before(earliest.Add(5*time.Minute)) {
+		t.Fatalf("next run %v is not within 5 minutes after %v", next, earliest)
+	}
+}
+
+func TestWriteStatusFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.json")
+	status := scheduler.Status{Tasks: []scheduler.TaskStatus{{Task: scheduler.Task{Name: "fetch"}, Runs: 2}}}
+	if err := scheduler.WriteStatusFile(path, status); err != nil {
+		t.Fatalf("WriteStatusFile: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || !strings.Contains(string(data), `"runs": 2`) {
+		t.Fatalf("status file = %s, %v", data, err)
+	}
+}
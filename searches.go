@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"hh_bot/storage"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// searchFilters adds the HH parameters of search add flags to filters.
+// area and experience are comma-separated; extra are KEY=VALUE pairs.
+func searchFilters(filters url.Values, area, experience string, salary int, extra []string) error {
+	for _, id := range strings.Split(area, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			filters.Add("area", id)
+		}
+	}
+	for _, id := range strings.Split(experience, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			filters.Add("experience", id)
+		}
+	}
+	if salary < 0 {
+		return fmt.Errorf("-salary must not be negative")
+	}
+	if salary > 0 {
+		filters.Set("salary", strconv.Itoa(salary))
+		filters.Set("only_with_salary", "true")
+	}
+	for _, pair := range extra {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("invalid -filter %q, expected KEY=VALUE", pair)
+		}
+		if key == "text" || key == "page" || key == "per_page" {
+			return fmt.Errorf("-filter cannot set %s", key)
+		}
+		filters.Add(key, value)
+	}
+	return nil
+}
+
+func listSavedSearches(dbpool *pgxpool.Pool, owner string) error {
+	searches, err := storage.LoadSavedSearches(dbpool, storage.SavedSearchFilter{Owner: owner})
+	if err != nil {
+		return err
+	}
+
+	for _, s := range searches {
+		state := "enabled"
+		if !s.Enabled {
+			state = "disabled"
+		}
+		schedule := s.Schedule
+		if schedule == "" {
+			schedule = "every fetch"
+		}
+		fmt.Printf("%d\t%s\t%s\t%s", s.ID, s.Name, state, schedule)
+		if s.Owner != "" {
+			fmt.Printf("\t%s", s.Owner)
+		}
+		fmt.Printf("\n\t%s\n", describeSearch(s))
+		if r := s.LastRun; r != nil {
+			fmt.Printf("\tlast run %s: found %d, %d new, %d already stored, %d failed requests\n",
+				r.StartedAt.Local().Format(time.DateTime), r.Found, r.New, r.Duplicates, r.Failed)
+		} else {
+			fmt.Printf("\tnot run yet\n")
+		}
+	}
+	fmt.Printf("%d saved searches.\n", len(searches))
+	return nil
+}
+
+// describeSearch shows the query and filters of a search, filters sorted by
+// key.
+func describeSearch(s storage.SavedSearch) string {
+	parts := []string{fmt.Sprintf("%q", s.Query)}
+	keys := make([]string, 0, len(s.Filters))
+	for key := range s.Filters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		parts = append(parts, key+"="+strings.Join(s.Filters[key], ","))
+	}
+	return strings.Join(parts, " ")
+}
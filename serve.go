@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hh_bot/metrics"
+	"hh_bot/storage"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// newServeMux serves /healthz, which checks that storage answers, /stats
+// with the queue counts as JSON, and /metrics for Prometheus.
+func newServeMux(a *app) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := a.repo.QueueStats(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		stats, err := a.repo.QueueStats()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"queue": stats})
+	})
+
+	// Every state is reported, so empty ones show up as zero rather than
+	// vanish from graphs.
+	mux.Handle("GET /metrics", metrics.Handler(func() (map[string]int, error) {
+		stats, err := a.repo.QueueStats()
+		if err != nil {
+			return nil, err
+		}
+		for _, state := range []string{storage.StatusPending, storage.StatusInProgress, storage.StatusDone, storage.StatusFailed, storage.StatusDead, storage.StatusDuplicate} {
+			stats[state] += 0
+		}
+		return stats, nil
+	}))
+
+	return mux
+}
+
+// serve runs the HTTP server until SIGINT or SIGTERM.
+func serve(a *app, addr string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return listen(ctx, addr, newServeMux(a))
+}
+
+// listen serves handler on addr until ctx is done and then shuts down
+// gracefully.
+func listen(ctx context.Context, addr string, handler http.Handler) error {
+	server := &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+
+	errs := make(chan error, 1)
+	go func() {
+		slog.Info("serving", "addr", addr)
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
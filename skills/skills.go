@@ -0,0 +1,200 @@
+package skills
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"hh_bot/models"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	SourceKeySkills   = "key_skills"
+	SourceDescription = "description"
+	CategoryOther     = "other"
+)
+
+//go:embed taxonomy.json
+var taxonomyJSON []byte
+
+type Skill struct {
+	Name     string   `json:"name"`
+	Category string   `json:"category"`
+	Aliases  []string `json:"aliases"`
+	// Ambiguous aliases such as "ml" or "go" only match a whole key skill and
+	// are never searched for in descriptions.
+	Ambiguous []string `json:"ambiguous"`
+}
+
+type pattern struct {
+	re    *regexp.Regexp
+	skill *Skill
+}
+
+type Taxonomy struct {
+	skills   []Skill
+	byAlias  map[string]*Skill
+	patterns []pattern
+}
+
+var reVersion = regexp.MustCompile(`\s+v?\d+(\.(\d+|x))*$`)
+
+var (
+	defaultOnce     sync.Once
+	defaultTaxonomy *Taxonomy
+)
+
+// Default returns the taxonomy shipped with the binary.
+func Default() *Taxonomy {
+	defaultOnce.Do(func() {
+		t, err := Load(taxonomyJSON)
+		if err != nil {
+			panic(fmt.Sprintf("invalid embedded skill taxonomy: %s", err))
+		}
+		defaultTaxonomy = t
+	})
+	return defaultTaxonomy
+}
+
+func Load(data []byte) (*Taxonomy, error) {
+	t := &Taxonomy{byAlias: make(map[string]*Skill)}
+	if err := json.Unmarshal(data, &t.skills); err != nil {
+		return nil, fmt.Errorf("failed to decode taxonomy: %w", err)
+	}
+
+	for i := range t.skills {
+		skill := &t.skills[i]
+		aliases := append([]string{skill.Name}, skill.Aliases...)
+
+		for _, alias := range append(aliases, skill.Ambiguous...) {
+			key := normalizeKey(alias)
+			if other, ok := t.byAlias[key]; ok && other != skill {
+				return nil, fmt.Errorf("alias %q is used by both %s and %s", alias, other.Name, skill.Name)
+			}
+			t.byAlias[key] = skill
+		}
+
+		ambiguous := make(map[string]bool)
+		for _, alias := range skill.Ambiguous {
+			ambiguous[normalizeKey(alias)] = true
+		}
+
+		for _, alias := range aliases {
+			if ambiguous[normalizeKey(alias)] {
+				continue
+			}
+			re, err := regexp.Compile(`(?:^|[^\p{L}\p{N}])` + regexp.QuoteMeta(normalizeKey(alias)) + `(?:$|[^\p{L}\p{N}+#])`)
+			if err != nil {
+				return nil, fmt.Errorf("failed to compile alias %q: %w", alias, err)
+			}
+			t.patterns = append(t.patterns, pattern{re: re, skill: skill})
+		}
+	}
+
+	return t, nil
+}
+
+func normalizeKey(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.ReplaceAll(s, "ё", "е")
+	s = strings.Join(strings.Fields(s), " ")
+	return s
+}
+
+// Normalize maps a free-text skill such as "Pytorch 2" to its canonical
+// entry.
+func (t *Taxonomy) Normalize(raw string) (Skill, bool) {
+	key := normalizeKey(raw)
+	if skill, ok := t.byAlias[key]; ok {
+		return *skill, true
+	}
+	if skill, ok := t.byAlias[reVersion.ReplaceAllString(key, "")]; ok {
+		return *skill, true
+	}
+	return Skill{}, false
+}
+
+// Extract finds skills mentioned in free text.
+func (t *Taxonomy) Extract(text string) []Skill {
+	text = normalizeKey(text)
+
+	var found []Skill
+	seen := make(map[string]bool)
+	for _, p := range t.patterns {
+		if seen[p.skill.Name] || !p.re.MatchString(text) {
+			continue
+		}
+		seen[p.skill.Name] = true
+		found = append(found, *p.skill)
+	}
+	return found
+}
+
+// ForJob normalizes the key skills of a vacancy and adds skills found in its
+// description. Key skills missing from the taxonomy are kept under the
+// "other" category so they still show up in statistics.
+func (t *Taxonomy) ForJob(job *models.JobAd) []models.JobSkill {
+	var result []models.JobSkill
+	seen := make(map[string]bool)
+
+	add := func(name, category, source string) {
+		if name == "" || seen[strings.ToLower(name)] {
+			return
+		}
+		seen[strings.ToLower(name)] = true
+		result = append(result, models.JobSkill{JobID: job.ID, Skill: name, Category: category, Source: source})
+	}
+
+	for _, keySkill := range job.KeySkills {
+		if skill, ok := t.Normalize(keySkill.Name); ok {
+			add(skill.Name, skill.Category, SourceKeySkills)
+		} else {
+			add(strings.Join(strings.Fields(keySkill.Name), " "), CategoryOther, SourceKeySkills)
+		}
+	}
+
+	for _, skill := range t.Extract(job.Name + "\n" + job.Descrtiption) {
+		add(skill.Name, skill.Category, SourceDescription)
+	}
+
+	return result
+}
+
+// ProfileKeys maps the skills of a candidate profile to the lowercased names
+// ForJob stores, so they can be compared with job_skills.
+func (t *Taxonomy) ProfileKeys(profile []string) []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, raw := range profile {
+		name := strings.Join(strings.Fields(raw), " ")
+		if skill, ok := t.Normalize(raw); ok {
+			name = skill.Name
+		}
+		key := strings.ToLower(name)
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Fit counts the profile skills a vacancy asks for, either as key skills or
+// in its description.
+func (t *Taxonomy) Fit(profile []string, job *models.JobAd) int {
+	wanted := make(map[string]bool)
+	for _, key := range t.ProfileKeys(profile) {
+		wanted[key] = true
+	}
+
+	fit := 0
+	for _, skill := range t.ForJob(job) {
+		if wanted[strings.ToLower(skill.Skill)] {
+			fit++
+		}
+	}
+	return fit
+}
@@ -0,0 +1,81 @@
+package skills_test
+
+import (
+	"hh_bot/models"
+	"hh_bot/skills"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	taxonomy := skills.Default()
+
+	for _, raw := range []string{"PyTorch", "pytorch", "Pytorch 2", " torch "} {
+		skill, ok := taxonomy.Normalize(raw)
+		if !ok || skill.Name != "PyTorch" || skill.Category != "framework" {
+			t.Fatalf("Normalize(%q) = %+v, %v", raw, skill, ok)
+		}
+	}
+
+	if skill, ok := taxonomy.Normalize("ML"); !ok || skill.Name != "Machine Learning" {
+		t.Fatalf("expected ML to normalize to Machine Learning, got %+v", skill)
+	}
+	if _, ok := taxonomy.Normalize("Стрессоустойчивость"); ok {
+		t.Fatalf("unexpected match for a soft skill")
+	}
+}
+
+func TestForJob(t *testing.T) {
+	job := &models.JobAd{
+		ID:           "1",
+		Name:         "ML Engineer",
+		Descrtiption: "Стек: Python, Docker, Kubernetes. Опыт с MLflow будет плюсом. Go не нужен.",
+		KeySkills:    []models.KeySkill{{Name: "pytorch"}, {Name: "Python"}, {Name: "Стрессоустойчивость"}},
+	}
+
+	got := make(map[string]models.JobSkill)
+	for _, skill := range skills.Default().ForJob(job) {
+		got[skill.Skill] = skill
+	}
+
+	want := map[string]string{
+		"PyTorch":             skills.SourceKeySkills,
+		"Python":              skills.SourceKeySkills,
+		"Стрессоустойчивость": skills.SourceKeySkills,
+		"Docker":              skills.SourceDescription,
+		"Kubernetes":          skills.SourceDescription,
+		"MLflow":              skills.SourceDescription,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got skills %+v, want %v", got, want)
+	}
+	for name, source := range want {
+		if got[name].Source != source {
+			t.Fatalf("skill %s: got %+v, want source %s", name, got[name], source)
+		}
+	}
+	if got["Стрессоустойчивость"].Category != skills.CategoryOther {
+		t.Fatalf("unknown key skills must be categorized as other")
+	}
+}
+
+func TestFit(t *testing.T) {
+	taxonomy := skills.Default()
+	job := &models.JobAd{
+		ID:           "1",
+		Name:         "ML Engineer",
+		Descrtiption: "Python, Docker и Kubernetes.",
+		KeySkills:    []models.KeySkill{{Name: "pytorch"}, {Name: "Стрессоустойчивость"}},
+	}
+
+	keys := taxonomy.ProfileKeys([]string{"torch", "PyTorch", " стрессоустойчивость ", "Go"})
+	if len(keys) != 3 || keys[0] != "pytorch" || keys[1] != "стрессоустойчивость" {
+		t.Fatalf("ProfileKeys = %q", keys)
+	}
+
+	if fit := taxonomy.Fit([]string{"torch", "python", "Rust", "Стрессоустойчивость"}, job); fit != 3 {
+		t.Fatalf("Fit = %d, want 3", fit)
+	}
+	if fit := taxonomy.Fit(nil, job); fit != 0 {
+		t.Fatalf("Fit without a profile = %d, want 0", fit)
+	}
+}
@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Column types of ExportColumn.
+const (
+	TypeString = "string"
+	TypeInt    = "int"
+	TypeFloat  = "float"
+	TypeBool   = "bool"
+	TypeTime   = "time"
+)
+
+// ExportColumn is a column that can be exported, with the SQL expression
+// that produces it from job_ads j joined with processed_job_ads p.
+type ExportColumn struct {
+	Name string
+	Expr string
+	Type string
+}
+
+var ExportColumns = []ExportColumn{
+	{"id", "j.id", TypeString},
+	{"name", "j.name", TypeString},
+	{"employer", "j.employer_name", TypeString},
+	{"area", "j.area_name", TypeString},
+	{"experience", "j.experience_name", TypeString},
+	{"salary_from", "j.salary_from", TypeInt},
+	{"salary_to", "j.salary_to", TypeInt},
+	{"salary_currency", "j.salary_currency", TypeString},
+	{"salary_gross", "j.salary_gross", TypeBool},
+	{"published_at", "j.published_at", TypeTime},
+	{"url", "j.alternate_url", TypeString},
+	{"key_skills", "(SELECT string_agg(s->>'name', '; ') FROM jsonb_array_elements(json_array_or_empty(j.key_skills)) s)", TypeString},
+	{"description", "j.description", TypeString},
+	{"status", "p.status", TypeString},
+	{"attempts", "p.attempts", TypeInt},
+	{"last_error", "p.last_error", TypeString},
+	{"cover_letter", "p.cover_letter", TypeString},
+	{"valid", "p.valid", TypeBool},
+	{"score", "(p.validation->>'score')::double precision", TypeFloat},
+	{"prompt_version", "p.prompt_version", TypeString},
+	{"model", "p.model", TypeString},
+	{"processed_at", "p.processed_at", TypeTime},
+}
+
+var DefaultExportColumns = []string{
+	"id", "name", "employer", "area", "salary_from", "salary_to", "salary_currency",
+	"published_at", "url", "status", "valid", "score", "cover_letter",
+}
+
+// LookupExportColumns resolves column names, keeping their order.
+func LookupExportColumns(names []string) ([]ExportColumn, error) {
+	byName := make(map[string]ExportColumn, len(ExportColumns))
+	for _, c := range ExportColumns {
+		byName[c.Name] = c
+	}
+
+	columns := make([]ExportColumn, 0, len(names))
+	for _, name := range names {
+		c, ok := byName[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		columns = append(columns, c)
+	}
+	return columns, nil
+}
+
+type ExportFilter struct {
+	// DateField is "published" (default) or "processed"; Since and Until
+	// apply to it.
+	DateField string
+	Since     time.Time
+	Until     time.Time
+	Status    string
+	// Query is a full-text query in web search syntax, see SearchQuery.
+	Query    string
+	MinScore *float64
+}
+
+// statusCondition translates a processing status filter shared by search and
+// export: a queue state, or "valid"/"invalid" for the letter check.
+func statusCondition(status string, addCondition func(string, any), conditions *[]string) error {
+	switch status {
+	case "":
+	case StatusPending, StatusInProgress, StatusDone, StatusFailed, StatusDead:
+		addCondition("p.status = $%d", status)
+	case "valid":
+		*conditions = append(*conditions, "p.status = 'done' AND p.valid = true")
+	case "invalid":
+		*conditions = append(*conditions, "p.status = 'done' AND p.valid = false")
+	default:
+		return fmt.Errorf("unknown status %q", status)
+	}
+	return nil
+}
+
+// StreamExport runs the export query and calls fn for every row, in the order
+// of columns. Rows are read one at a time, so exports of any size use little
+// memory.
+func StreamExport(dbpool *pgxpool.Pool, columns []ExportColumn, filter ExportFilter, fn func(values []any) error) error {
+	var conditions []string
+	var args []any
+
+	addCondition := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	dateColumn := "j.published_at"
+	switch filter.DateField {
+	case "", "published":
+	case "processed":
+		dateColumn = "p.processed_at"
+	default:
+		return fmt.Errorf("unknown date field %q", filter.DateField)
+	}
+	if !filter.Since.IsZero() {
+		addCondition(dateColumn+" >= $%d", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		addCondition(dateColumn+" < $%d", filter.Until)
+	}
+	if err := statusCondition(filter.Status, addCondition, &conditions); err != nil {
+		return err
+	}
+	if filter.Query != "" {
+		addCondition("j.search_vector @@ websearch_to_tsquery('ru_en', $%d)", filter.Query)
+	}
+	if filter.MinScore != nil {
+		addCondition("(p.validation->>'score')::double precision >= $%d", *filter.MinScore)
+	}
+
+	exprs := make([]string, len(columns))
+	for i, c := range columns {
+		exprs[i] = c.Expr
+	}
+
+	query := `
+	SELECT ` + strings.Join(exprs, ", ") + `
+	FROM job_ads j LEFT JOIN processed_job_ads p ON p.job_id = j.id
+	`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY " + dateColumn + " DESC NULLS LAST, j.id"
+
+	rows, err := dbpool.Query(context.Background(), query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to export jobs: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			return fmt.Errorf("failed to read export row: %w", err)
+		}
+		if err := fn(values); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
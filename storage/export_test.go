@@ -0,0 +1,11 @@
+package storage
+
+// ReprocessQuery exposes the query builder of LoadJobIDsForReprocessing to
+// the tests.
+var ReprocessQuery = reprocessQuery
+
+// SearchJobsQuery exposes the query builder of SearchJobs to the tests.
+var SearchJobsQuery = searchQuery
+
+// SQLiteVersion is the user_version of an up-to-date SQLite file.
+var SQLiteVersion = len(sqliteUpgrades)
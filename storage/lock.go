@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TryLock takes a session advisory lock on its own connection and reports
+// whether it got it. The lock is held until release is called or the
+// process exits.
+func TryLock(ctx context.Context, dbpool *pgxpool.Pool, key int64) (release func(), ok bool, err error) {
+	conn, err := dbpool.Acquire(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+
+	if err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, key).Scan(&ok); err != nil {
+		conn.Release()
+		return nil, false, fmt.Errorf("failed to take advisory lock: %w", err)
+	}
+	if !ok {
+		conn.Release()
+		return nil, false, nil
+	}
+
+	release = func() {
+		if _, err := conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, key); err != nil {
+			slog.Warn("failed to release advisory lock, closing its connection", "key", key, "err", err)
+			// Closing the connection ends the session and drops the lock.
+			conn.Conn().Close(context.Background())
+		}
+		conn.Release()
+	}
+	return release, true, nil
+}
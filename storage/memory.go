@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"fmt"
+	"hh_bot/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory keeps everything in process memory. It is meant for tests and dry
+// runs; nothing survives a restart.
+type Memory struct {
+	mu      sync.Mutex
+	jobs    map[string]models.JobAd
+	skills  map[string][]string // skillKeys of every job, kept for the fit order
+	queue   map[string]*memoryItem
+	letters []models.ProcessedJob
+}
+
+type memoryItem struct {
+	entry       QueueEntry
+	leasedUntil time.Time
+	result      *models.ProcessedJob
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		jobs:   make(map[string]models.JobAd),
+		skills: make(map[string][]string),
+		queue:  make(map[string]*memoryItem),
+	}
+}
+
+func (m *Memory) ExistingJobIDs(ids []string) (map[string]bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	existing := make(map[string]bool)
+	for _, id := range ids {
+		_, stored := m.jobs[id]
+		_, queued := m.queue[id]
+		if stored && queued {
+			existing[id] = true
+		}
+	}
+	return existing, nil
+}
+
+func (m *Memory) SaveJobs(jobs []models.JobAd) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var inserted []string
+	for _, job := range jobs {
+		if _, ok := m.jobs[job.ID]; !ok {
+			m.jobs[job.ID] = job
+			m.skills[job.ID] = skillKeys(&job)
+			inserted = append(inserted, job.ID)
+		}
+		m.enqueue(job.ID)
+	}
+	return inserted, nil
+}
+
+func (m *Memory) enqueue(id string) bool {
+	if _, ok := m.queue[id]; ok {
+		return false
+	}
+	now := time.Now()
+	m.queue[id] = &memoryItem{entry: QueueEntry{JobID: id, Status: StatusPending, NextAttemptAt: now, UpdatedAt: now}}
+	return true
+}
+
+func (m *Memory) RepairQueue() (RepairReport, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var report RepairReport
+	for id := range m.jobs {
+		if m.enqueue(id) {
+			report.Queued++
+		}
+	}
+	for id := range m.queue {
+		if _, ok := m.jobs[id]; !ok {
+			delete(m.queue, id)
+			report.Removed++
+		}
+	}
+	return report, nil
+}
+
+func (m *Memory) UpdateProcessedJob(result *models.ProcessedJob) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.saveResult(result)
+	return nil
+}
+
+func (m *Memory) CompleteJob(lease Lease, result *models.ProcessedJob) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.holds(lease) {
+		return fmt.Errorf("failed to update processed job %s: %w", result.JobID, ErrLeaseLost)
+	}
+	m.saveResult(result)
+	return nil
+}
+
+// holds reports whether the item of lease is still in progress under it.
+func (m *Memory) holds(lease Lease) bool {
+	item, ok := m.queue[lease.Job.ID]
+	return ok && item.entry.Status == StatusInProgress && item.entry.Attempts == lease.Attempts
+}
+
+func (m *Memory) saveResult(result *models.ProcessedJob) {
+	if item, ok := m.queue[result.JobID]; ok {
+		stored := *result
+		item.result = &stored
+		item.entry.Status = StatusDone
+		item.entry.LastError = ""
+		item.entry.UpdatedAt = time.Now()
+		item.leasedUntil = time.Time{}
+	}
+	m.letters = append(m.letters, *result)
+}
+
+func (m *Memory) LeaseJobs(opts LeaseOptions, policy RetryPolicy) ([]Lease, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var ready []candidate
+	for _, item := range m.queue {
+		entry := &item.entry
+		expired := entry.Status == StatusInProgress && item.leasedUntil.Before(now)
+		if expired && entry.Attempts >= policy.MaxAttempts {
+			entry.Status = StatusDead
+			entry.UpdatedAt = now
+			continue
+		}
+		waiting := (entry.Status == StatusPending || entry.Status == StatusFailed) && !entry.NextAttemptAt.After(now)
+		if waiting || expired {
+			ready = append(ready, candidate{job: m.jobs[entry.JobID], skills: m.skills[entry.JobID], nextAttemptAt: entry.NextAttemptAt})
+		}
+	}
+	if err := sortCandidates(ready, opts); err != nil {
+		return nil, err
+	}
+	if opts.Limit > 0 && len(ready) > opts.Limit {
+		ready = ready[:opts.Limit]
+	}
+
+	leases := make([]Lease, 0, len(ready))
+	for _, c := range ready {
+		item := m.queue[c.job.ID]
+		item.entry.Status = StatusInProgress
+		item.entry.Attempts++
+		item.entry.UpdatedAt = now
+		item.leasedUntil = now.Add(policy.LeaseTimeout)
+		leases = append(leases, Lease{Job: c.job, Attempts: item.entry.Attempts})
+	}
+
+	return leases, nil
+}
+
+func (m *Memory) FailJob(lease Lease, cause error, policy RetryPolicy) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.holds(lease) {
+		return "", fmt.Errorf("failed to record failure of job %s: %w", lease.Job.ID, ErrLeaseLost)
+	}
+	now := time.Now()
+	status, next := policy.failure(lease.Attempts, now)
+	item := m.queue[lease.Job.ID]
+	item.entry.Status = status
+	item.entry.LastError = cause.Error()
+	item.entry.NextAttemptAt = next
+	item.entry.UpdatedAt = now
+	item.leasedUntil = time.Time{}
+	return status, nil
+}
+
+func (m *Memory) DeadJobs() ([]QueueEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var entries []QueueEntry
+	for id, item := range m.queue {
+		if item.entry.Status != StatusDead {
+			continue
+		}
+		entry := item.entry
+		entry.Name = m.jobs[id].Name
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].JobID < entries[j].JobID })
+	return entries, nil
+}
+
+func (m *Memory) RequeueJobs(ids []string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	selected := make(map[string]bool)
+	for _, id := range ids {
+		selected[id] = true
+	}
+
+	now := time.Now()
+	requeued := 0
+	for id, item := range m.queue {
+		if item.entry.Status != StatusDead || (len(ids) > 0 && !selected[id]) {
+			continue
+		}
+		item.entry.Status = StatusPending
+		item.entry.Attempts = 0
+		item.entry.NextAttemptAt = now
+		item.entry.UpdatedAt = now
+		requeued++
+	}
+	return requeued, nil
+}
+
+func (m *Memory) QueueStats() (map[string]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := make(map[string]int)
+	for _, item := range m.queue {
+		stats[item.entry.Status]++
+	}
+	return stats, nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Local negotiation states; the others come from HH on sync. An
+// application is recorded as sending before it is posted, so that it is
+// never posted twice even if recording the outcome fails.
+const (
+	NegotiationSending  = "sending"
+	NegotiationSent     = "sent"
+	NegotiationRejected = "rejected"
+)
+
+type Negotiation struct {
+	JobID         string
+	NegotiationID string
+	ResumeID      string
+	State         string
+	Error         string
+	UpdatedAt     time.Time
+}
+
+// Application is a finished cover letter that has not been sent yet.
+type Application struct {
+	JobID       string
+	Name        string
+	Employer    string
+	CoverLetter string
+	Score       float64
+}
+
+// LoadApplications returns valid letters scoring at least minScore for
+// vacancies that were neither applied to nor archived, best first.
+func LoadApplications(dbpool *pgxpool.Pool, minScore float64, limit int) ([]Application, error) {
+	query := `
+	SELECT j.id, j.name, coalesce(j.employer_name, ''), p.cover_letter, coalesce((p.validation->>'score')::float8, 0) AS score
+	FROM processed_job_ads p
+	JOIN job_ads j ON j.id = p.job_id
+	LEFT JOIN negotiations n ON n.job_id = p.job_id
+	WHERE p.status = 'done' AND p.valid AND p.cover_letter <> '' AND NOT j.archived AND n.job_id IS NULL
+		AND coalesce((p.validation->>'score')::float8, 0) >= $1
+	ORDER BY score DESC, j.published_at DESC NULLS LAST, j.id
+	LIMIT $2
+	`
+	rows, err := dbpool.Query(context.Background(), query, minScore, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load applications: %w", err)
+	}
+	defer rows.Close()
+
+	var applications []Application
+	for rows.Next() {
+		var a Application
+		if err := rows.Scan(&a.JobID, &a.Name, &a.Employer, &a.CoverLetter, &a.Score); err != nil {
+			return nil, fmt.Errorf("failed to scan application: %w", err)
+		}
+		applications = append(applications, a)
+	}
+
+	return applications, rows.Err()
+}
+
+// SaveNegotiations inserts or updates negotiations. Negotiations with
+// vacancies that are not stored, such as ones started on the site, are
+// skipped. It returns how many rows were new or changed state.
+func SaveNegotiations(dbpool *pgxpool.Pool, negotiations []Negotiation) (int, error) {
+	query := `
+	INSERT INTO negotiations (job_id, negotiation_id, resume_id, state, error, updated_at)
+	SELECT $1, nullif($2, ''), $3, $4, nullif($5, ''), $6
+	WHERE EXISTS (SELECT 1 FROM job_ads WHERE id = $1)
+	ON CONFLICT (job_id) DO UPDATE SET
+		negotiation_id = coalesce(EXCLUDED.negotiation_id, negotiations.negotiation_id),
+		state = EXCLUDED.state,
+		error = EXCLUDED.error,
+		updated_at = EXCLUDED.updated_at
+	WHERE negotiations.state IS DISTINCT FROM EXCLUDED.state
+	`
+	changed := 0
+	for _, n := range negotiations {
+		updatedAt := n.UpdatedAt
+		if updatedAt.IsZero() {
+			updatedAt = time.Now()
+		}
+		tag, err := dbpool.Exec(context.Background(), query, n.JobID, n.NegotiationID, n.ResumeID, n.State, n.Error, updatedAt)
+		if err != nil {
+			return changed, fmt.Errorf("failed to save negotiation for job %s: %w", n.JobID, err)
+		}
+		changed += int(tag.RowsAffected())
+	}
+
+	return changed, nil
+}
+
+// DeleteSendingNegotiation forgets an application that failed to post, so
+// that the next run sends it again.
+func DeleteSendingNegotiation(dbpool *pgxpool.Pool, jobID string) error {
+	_, err := dbpool.Exec(context.Background(), `DELETE FROM negotiations WHERE job_id = $1 AND state = 'sending'`, jobID)
+	if err != nil {
+		return fmt.Errorf("failed to delete negotiation for job %s: %w", jobID, err)
+	}
+	return nil
+}
+
+func NegotiationStats(dbpool *pgxpool.Pool) (map[string]int, error) {
+	rows, err := dbpool.Query(context.Background(), `SELECT state, count(*) FROM negotiations GROUP BY state`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count negotiations: %w", err)
+	}
+	defer rows.Close()
+
+	stats := make(map[string]int)
+	for rows.Next() {
+		var state string
+		var count int
+		if err := rows.Scan(&state, &count); err != nil {
+			return nil, err
+		}
+		stats[state] = count
+	}
+
+	return stats, rows.Err()
+}
+
+// ArchiveJobs marks vacancies that were closed on HH as archived, so they are
+// no longer applied to.
+func ArchiveJobs(dbpool *pgxpool.Pool, ids []string) (int, error) {
+	tag, err := dbpool.Exec(context.Background(), `UPDATE job_ads SET archived = true WHERE id = ANY($1) AND NOT archived`, ids)
+	if err != nil {
+		return 0, fmt.Errorf("failed to archive jobs: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
@@ -0,0 +1,212 @@
+package storage_test
+
+import (
+	"context"
+	"hh_bot/internal/pgtest"
+	"hh_bot/migrations"
+	"hh_bot/models"
+	"hh_bot/storage"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// openPostgres returns a migrated database, or skips the test without one.
+func openPostgres(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	dbpool := pgtest.Open(t)
+	if _, err := migrations.Up(dbpool); err != nil {
+		t.Fatalf("migrations.Up: %v", err)
+	}
+	return dbpool
+}
+
+func TestLoadJobsMissingExtraction(t *testing.T) {
+	dbpool := openPostgres(t)
+
+	_, err := storage.NewPostgres(dbpool).SaveJobs([]models.JobAd{
+		{ID: "1", Name: "Go developer"},
+		{ID: "2", Name: "Python developer"},
+		{ID: "3", Name: "Data scientist"},
+	})
+	if err != nil {
+		t.Fatalf("SaveJobs: %v", err)
+	}
+	err = storage.SaveJobSkills(dbpool, "1", []models.JobSkill{{Skill: "go", Category: "language", Source: "key_skills"}})
+	if err != nil {
+		t.Fatalf("SaveJobSkills: %v", err)
+	}
+	if err := storage.SaveFingerprint(dbpool, models.Fingerprint{JobID: "2", ClusterID: "2", Canonical: true}); err != nil {
+		t.Fatalf("SaveFingerprint: %v", err)
+	}
+
+	noSkills, noFingerprint, err := storage.LoadJobsMissingExtraction(dbpool, []string{"1", "2", "4"})
+	if err != nil {
+		t.Fatalf("LoadJobsMissingExtraction: %v", err)
+	}
+	if want := map[string]bool{"2": true}; !reflect.DeepEqual(noSkills, want) {
+		t.Errorf("noSkills = %v, want %v", noSkills, want)
+	}
+	if want := map[string]bool{"1": true}; !reflect.DeepEqual(noFingerprint, want) {
+		t.Errorf("noFingerprint = %v, want %v", noFingerprint, want)
+	}
+
+	jobs, err := storage.LoadJobsWithoutSkills(dbpool)
+	if err != nil {
+		t.Fatalf("LoadJobsWithoutSkills: %v", err)
+	}
+	if len(jobs) != 2 || jobs[0].ID == "1" || jobs[1].ID == "1" {
+		t.Errorf("LoadJobsWithoutSkills = %+v, want jobs 2 and 3", jobs)
+	}
+}
+
+func TestDuplicatesLeaveTheQueue(t *testing.T) {
+	dbpool := openPostgres(t)
+	repo := storage.NewPostgres(dbpool)
+
+	if _, err := repo.SaveJobs([]models.JobAd{{ID: "1", Name: "Go developer"}, {ID: "2", Name: "Go developer"}}); err != nil {
+		t.Fatalf("SaveJobs: %v", err)
+	}
+	for _, fp := range []models.Fingerprint{{JobID: "1", ClusterID: "1", Canonical: true}, {JobID: "2", ClusterID: "1"}} {
+		if err := storage.SaveFingerprint(dbpool, fp); err != nil {
+			t.Fatalf("SaveFingerprint: %v", err)
+		}
+	}
+
+	stats, err := repo.QueueStats()
+	if err != nil {
+		t.Fatalf("QueueStats: %v", err)
+	}
+	if want := map[string]int{storage.StatusPending: 1, storage.StatusDuplicate: 1}; !reflect.DeepEqual(stats, want) {
+		t.Errorf("QueueStats = %v, want %v", stats, want)
+	}
+
+	ids, err := storage.LoadJobIDsForReprocessing(dbpool, storage.ReprocessFilter{}, storage.LeaseOptions{})
+	if err != nil {
+		t.Fatalf("LoadJobIDsForReprocessing: %v", err)
+	}
+	if !reflect.DeepEqual(ids, []string{"1"}) {
+		t.Errorf("LoadJobIDsForReprocessing = %v, want [1]", ids)
+	}
+}
+
+func TestPostgresScansSparseJobs(t *testing.T) {
+	dbpool := openPostgres(t)
+	ctx := context.Background()
+
+	// Rows written by hand or by older versions leave most columns NULL.
+	if _, err := dbpool.Exec(ctx, `INSERT INTO job_ads (id, name) VALUES ('1', 'Sparse')`); err != nil {
+		t.Fatal(err)
+	}
+	code := "A-1"
+	_, err := storage.NewPostgres(dbpool).SaveJobs([]models.JobAd{
+		{ID: "2", Name: "Go developer", Code: &code, Descrtiption: "Go", KeySkills: []models.KeySkill{{Name: "Go"}}},
+	})
+	if err != nil {
+		t.Fatalf("SaveJobs: %v", err)
+	}
+
+	jobs, err := storage.LoadJobsByIDs(dbpool, []string{"2", "1", "3"})
+	if err != nil {
+		t.Fatalf("LoadJobsByIDs: %v", err)
+	}
+	if len(jobs) != 2 || jobs[0].ID != "2" || jobs[1].ID != "1" {
+		t.Fatalf("LoadJobsByIDs = %+v, want jobs 2 and 1 in that order", jobs)
+	}
+	if jobs[0].Code == nil || *jobs[0].Code != code || len(jobs[0].KeySkills) != 1 {
+		t.Errorf("saved job was not loaded back: %+v", jobs[0])
+	}
+	if jobs[1].Name != "Sparse" || jobs[1].Descrtiption != "" {
+		t.Errorf("sparse job = %+v", jobs[1])
+	}
+
+	jobs, err = storage.LoadJobsWithoutEmbedding(dbpool, "test-model")
+	if err != nil {
+		t.Fatalf("LoadJobsWithoutEmbedding: %v", err)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("LoadJobsWithoutEmbedding = %+v, want both jobs", jobs)
+	}
+	if err := storage.SaveEmbedding(dbpool, "2", "test-model", []float32{1, 0}, false); err != nil {
+		t.Fatalf("SaveEmbedding: %v", err)
+	}
+	if err := storage.SaveEmbedding(dbpool, "2", "other-model", []float32{0, 1}, false); err != nil {
+		t.Fatalf("SaveEmbedding: %v", err)
+	}
+	jobs, err = storage.LoadJobsWithoutEmbedding(dbpool, "test-model")
+	if err != nil {
+		t.Fatalf("LoadJobsWithoutEmbedding: %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].ID != "1" {
+		t.Errorf("LoadJobsWithoutEmbedding = %+v, want job 1", jobs)
+	}
+	vector, err := storage.LoadEmbedding(dbpool, "2", "test-model")
+	if err != nil || !reflect.DeepEqual(vector, []float32{1, 0}) {
+		t.Errorf("LoadEmbedding = %v, %v; another model must not overwrite it", vector, err)
+	}
+
+	ids, err := storage.LoadJobIDsForReprocessing(dbpool, storage.ReprocessFilter{}, storage.LeaseOptions{})
+	if err != nil {
+		t.Fatalf("LoadJobIDsForReprocessing: %v", err)
+	}
+	if !reflect.DeepEqual(ids, []string{"2"}) {
+		t.Errorf("LoadJobIDsForReprocessing = %v, want the queued job only", ids)
+	}
+}
+
+func TestSendingNegotiation(t *testing.T) {
+	dbpool := openPostgres(t)
+	repo := storage.NewPostgres(dbpool)
+
+	if _, err := repo.SaveJobs([]models.JobAd{{ID: "1", Name: "Go developer"}}); err != nil {
+		t.Fatalf("SaveJobs: %v", err)
+	}
+	err := repo.UpdateProcessedJob(&models.ProcessedJob{
+		JobID:       "1",
+		CoverLetter: "Hello",
+		Validation:  &models.LetterValidation{Valid: true, Score: 0.9, Attempts: 1},
+		ProcessedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("UpdateProcessedJob: %v", err)
+	}
+
+	pending := func() int {
+		t.Helper()
+		applications, err := storage.LoadApplications(dbpool, 0, 10)
+		if err != nil {
+			t.Fatalf("LoadApplications: %v", err)
+		}
+		return len(applications)
+	}
+	if n := pending(); n != 1 {
+		t.Fatalf("%d applications before sending, want 1", n)
+	}
+
+	sending := storage.Negotiation{JobID: "1", ResumeID: "r1", State: storage.NegotiationSending}
+	if _, err := storage.SaveNegotiations(dbpool, []storage.Negotiation{sending}); err != nil {
+		t.Fatalf("SaveNegotiations: %v", err)
+	}
+	if n := pending(); n != 0 {
+		t.Errorf("an application being sent must not be sent again, got %d", n)
+	}
+	if err := storage.DeleteSendingNegotiation(dbpool, "1"); err != nil {
+		t.Fatalf("DeleteSendingNegotiation: %v", err)
+	}
+	if n := pending(); n != 1 {
+		t.Errorf("a failed application must be sent again, got %d", n)
+	}
+
+	sent := storage.Negotiation{JobID: "1", ResumeID: "r1", NegotiationID: "n1", State: storage.NegotiationSent}
+	if _, err := storage.SaveNegotiations(dbpool, []storage.Negotiation{sending, sent}); err != nil {
+		t.Fatalf("SaveNegotiations: %v", err)
+	}
+	if err := storage.DeleteSendingNegotiation(dbpool, "1"); err != nil {
+		t.Fatalf("DeleteSendingNegotiation: %v", err)
+	}
+	if n := pending(); n != 0 {
+		t.Errorf("a sent application must stay recorded, got %d", n)
+	}
+}
@@ -0,0 +1,358 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hh_bot/models"
+	"hh_bot/skills"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Queue states of processed_job_ads.status.
+const (
+	StatusPending    = "pending"
+	StatusInProgress = "in_progress"
+	StatusDone       = "done"
+	StatusFailed     = "failed"
+	StatusDead       = "dead"
+
+	// StatusDuplicate is never stored. QueueStats reports it for pending
+	// and failed items of near-duplicate vacancies, which are not leased.
+	StatusDuplicate = "duplicate"
+)
+
+// notDuplicate excludes the near-duplicates of canonical vacancies from a
+// query on processed_job_ads p.
+const notDuplicate = "p.job_id NOT IN (SELECT job_id FROM job_fingerprints WHERE canonical = false)"
+
+// Lease is a queue item handed to one processor until it succeeds, fails or
+// the lease times out. Attempts includes the current one.
+type Lease struct {
+	Job      models.JobAd
+	Attempts int
+}
+
+// ErrLeaseLost is returned when a processor finishes a lease that timed out
+// and was handed to another processor or moved to the dead-letter state.
+var ErrLeaseLost = errors.New("lease lost to another processor")
+
+// QueueEntry describes a queue item for inspection.
+type QueueEntry struct {
+	JobID         string
+	Name          string
+	Status        string
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+	UpdatedAt     time.Time
+}
+
+// RetryPolicy controls leasing and backoff. Failed items wait BaseDelay,
+// doubled on every further attempt up to MaxDelay, and become dead after
+// MaxAttempts. A lease that is not finished within LeaseTimeout is handed
+// out again, as its processor most likely crashed.
+type RetryPolicy struct {
+	MaxAttempts  int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	LeaseTimeout time.Duration
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:  5,
+		BaseDelay:    time.Minute,
+		MaxDelay:     6 * time.Hour,
+		LeaseTimeout: 10 * time.Minute,
+	}
+}
+
+// Delay returns how long to wait before the attempt after the given one.
+func (p RetryPolicy) Delay(attempts int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempts && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// failure decides the next state of a failed lease.
+func (p RetryPolicy) failure(attempts int, now time.Time) (string, time.Time) {
+	if attempts >= p.MaxAttempts {
+		return StatusDead, now
+	}
+	return StatusFailed, now.Add(p.Delay(attempts))
+}
+
+// Orders in which ready queue items are leased.
+const (
+	OrderQueue  = "queue"  // oldest next attempt first
+	OrderNewest = "newest" // most recently published first
+	OrderFit    = "fit"    // most profile skills matched first, then newest
+)
+
+// LeaseOptions selects which ready items a processor leases. ProfileSkills
+// is only used by OrderFit.
+type LeaseOptions struct {
+	Limit         int
+	Order         string
+	ProfileSkills []string
+}
+
+func CheckOrder(order string) error {
+	switch order {
+	case "", OrderQueue, OrderNewest, OrderFit:
+		return nil
+	}
+	return fmt.Errorf("unknown order %q, want %s, %s or %s", order, OrderQueue, OrderNewest, OrderFit)
+}
+
+// orderClause returns the ORDER BY of job_ads j and processed_job_ads p for
+// an order. The fit order compares job_skills with the profile passed as
+// the placeholder profileArg.
+func orderClause(order, profileArg string) (string, error) {
+	switch order {
+	case "", OrderQueue:
+		return "p.next_attempt_at, p.job_id", nil
+	case OrderNewest:
+		return "j.published_at DESC NULLS LAST, p.job_id", nil
+	case OrderFit:
+		fit := "(SELECT count(*) FROM job_skills s WHERE s.job_id = p.job_id AND lower(s.skill) = ANY(" + profileArg + "))"
+		return fit + " DESC, j.published_at DESC NULLS LAST, p.job_id", nil
+	}
+	return "", CheckOrder(order)
+}
+
+// skillKeys returns the lowercased names of the skills ForJob finds in a
+// job, which the fit order compares with the ProfileKeys of a profile.
+// Backends without job_skills store them next to the vacancy.
+func skillKeys(job *models.JobAd) []string {
+	jobSkills := skills.Default().ForJob(job)
+	keys := make([]string, len(jobSkills))
+	for i, skill := range jobSkills {
+		keys[i] = strings.ToLower(skill.Skill)
+	}
+	return keys
+}
+
+// candidate is a ready queue item of the memory backend, which orders in Go.
+type candidate struct {
+	job           models.JobAd
+	skills        []string
+	nextAttemptAt time.Time
+	fit           int
+}
+
+// sortCandidates orders ready items the way orderClause does.
+func sortCandidates(candidates []candidate, opts LeaseOptions) error {
+	if err := CheckOrder(opts.Order); err != nil {
+		return err
+	}
+	if opts.Order == OrderFit {
+		wanted := make(map[string]bool)
+		for _, key := range skills.Default().ProfileKeys(opts.ProfileSkills) {
+			wanted[key] = true
+		}
+		for i := range candidates {
+			for _, key := range candidates[i].skills {
+				if wanted[key] {
+					candidates[i].fit++
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		switch opts.Order {
+		case OrderFit:
+			if a.fit != b.fit {
+				return a.fit > b.fit
+			}
+			fallthrough
+		case OrderNewest:
+			at, bt := time.Time(a.job.PublishedAt), time.Time(b.job.PublishedAt)
+			if !at.Equal(bt) {
+				return at.After(bt)
+			}
+		default:
+			if !a.nextAttemptAt.Equal(b.nextAttemptAt) {
+				return a.nextAttemptAt.Before(b.nextAttemptAt)
+			}
+		}
+		return a.job.ID < b.job.ID
+	})
+	return nil
+}
+
+// LeaseJobs marks up to opts.Limit ready items as in progress and returns
+// them fully loaded, in the requested order. FOR UPDATE SKIP LOCKED lets
+// several processors lease concurrently without handing out the same item
+// twice. Expired leases that already used up their attempts are moved to
+// the dead-letter state instead.
+func (p *Postgres) LeaseJobs(opts LeaseOptions, policy RetryPolicy) ([]Lease, error) {
+	orderBy, err := orderClause(opts.Order, "$3")
+	if err != nil {
+		return nil, err
+	}
+	args := []any{opts.Limit, policy.LeaseTimeout.Seconds()}
+	if opts.Order == OrderFit {
+		args = append(args, skills.Default().ProfileKeys(opts.ProfileSkills))
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var leases []Lease
+	err = pgx.BeginFunc(ctx, p.pool, func(tx pgx.Tx) error {
+		query := `
+		UPDATE processed_job_ads
+		SET status = 'dead', leased_until = NULL, updated_at = now(),
+			last_error = 'lease expired after ' || attempts || ' attempts'
+		WHERE status = 'in_progress' AND leased_until < now() AND attempts >= $1
+		`
+		if _, err := tx.Exec(ctx, query, policy.MaxAttempts); err != nil {
+			return fmt.Errorf("failed to expire leases: %w", err)
+		}
+
+		query = `
+		WITH leased AS (
+			UPDATE processed_job_ads
+			SET status = 'in_progress', attempts = attempts + 1, updated_at = now(),
+				leased_until = now() + $2 * interval '1 second'
+			WHERE job_id IN (
+				SELECT p.job_id FROM processed_job_ads p JOIN job_ads j ON j.id = p.job_id
+				WHERE ((p.status IN ('pending', 'failed') AND p.next_attempt_at <= now())
+					OR (p.status = 'in_progress' AND p.leased_until < now()))
+				AND ` + notDuplicate + `
+				ORDER BY ` + orderBy + `
+				LIMIT $1
+				FOR UPDATE OF p SKIP LOCKED
+			)
+			RETURNING job_id, attempts
+		)
+		SELECT ` + jobColumns + `, l.attempts
+		FROM leased l JOIN job_ads j ON j.id = l.job_id JOIN processed_job_ads p ON p.job_id = l.job_id
+		ORDER BY ` + orderBy
+		rows, err := tx.Query(ctx, query, args...)
+		if err != nil {
+			return fmt.Errorf("failed to lease jobs: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var lease Lease
+			if err := scanJobAd(rows, &lease.Job, &lease.Attempts); err != nil {
+				return fmt.Errorf("failed to scan leased job: %w", err)
+			}
+			leases = append(leases, lease)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Debug("leased jobs", "jobs", len(leases), "order", opts.Order, "latency", time.Since(start))
+	return leases, nil
+}
+
+// FailJob records a failed attempt and returns the new status, which is
+// "failed" with a backoff or "dead" once the attempts are used up.
+func (p *Postgres) FailJob(lease Lease, cause error, policy RetryPolicy) (string, error) {
+	status, next := policy.failure(lease.Attempts, time.Now())
+
+	query := `
+	UPDATE processed_job_ads
+	SET status = $1, last_error = $2, next_attempt_at = $3, leased_until = NULL, updated_at = now()
+	WHERE job_id = $4 AND status = 'in_progress' AND attempts = $5
+	`
+	tag, err := p.pool.Exec(context.Background(), query, status, cause.Error(), next, lease.Job.ID, lease.Attempts)
+	if err != nil {
+		return "", fmt.Errorf("failed to record failure of job %s: %w", lease.Job.ID, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return "", fmt.Errorf("failed to record failure of job %s: %w", lease.Job.ID, ErrLeaseLost)
+	}
+
+	slog.Debug("recorded job failure", "vacancy_id", lease.Job.ID, "attempt", lease.Attempts, "status", status, "next_attempt", next)
+	return status, nil
+}
+
+func (p *Postgres) DeadJobs() ([]QueueEntry, error) {
+	query := `
+	SELECT p.job_id, j.name, p.status, p.attempts, coalesce(p.last_error, ''), p.next_attempt_at, p.updated_at
+	FROM processed_job_ads p JOIN job_ads j ON j.id = p.job_id
+	WHERE p.status = 'dead'
+	ORDER BY p.updated_at DESC, p.job_id
+	`
+	rows, err := p.pool.Query(context.Background(), query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load dead jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []QueueEntry
+	for rows.Next() {
+		var entry QueueEntry
+		if err := rows.Scan(&entry.JobID, &entry.Name, &entry.Status, &entry.Attempts,
+			&entry.LastError, &entry.NextAttemptAt, &entry.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan dead job: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// RequeueJobs moves dead items back to pending with a fresh attempt count.
+// With no ids every dead item is requeued.
+func (p *Postgres) RequeueJobs(ids []string) (int, error) {
+	query := `
+	UPDATE processed_job_ads
+	SET status = 'pending', attempts = 0, next_attempt_at = now(), leased_until = NULL, updated_at = now()
+	WHERE status = 'dead' AND (cardinality($1::text[]) = 0 OR job_id = ANY($1))
+	`
+	if ids == nil {
+		ids = []string{}
+	}
+	tag, err := p.pool.Exec(context.Background(), query, ids)
+	if err != nil {
+		return 0, fmt.Errorf("failed to requeue jobs: %w", err)
+	}
+
+	return int(tag.RowsAffected()), nil
+}
+
+func (p *Postgres) QueueStats() (map[string]int, error) {
+	query := `
+	SELECT CASE WHEN p.status IN ('pending', 'failed') AND NOT ` + notDuplicate + ` THEN 'duplicate' ELSE p.status END, count(*)
+	FROM processed_job_ads p
+	GROUP BY 1
+	`
+	rows, err := p.pool.Query(context.Background(), query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load queue stats: %w", err)
+	}
+	defer rows.Close()
+
+	stats := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan queue stats: %w", err)
+		}
+		stats[status] = count
+	}
+
+	return stats, rows.Err()
+}
@@ -0,0 +1,40 @@
+package storage
+
+import "hh_bot/models"
+
+// Repository covers the operations the fetch and process loops need. It is
+// implemented by Postgres, SQLite and Memory.
+type Repository interface {
+	ExistingJobIDs(ids []string) (map[string]bool, error)
+	// SaveJobs stores vacancies together with their queue entries in one
+	// transaction and returns the ids that were new.
+	SaveJobs(jobs []models.JobAd) ([]string, error)
+	RepairQueue() (RepairReport, error)
+	// UpdateProcessedJob stores a generated letter and marks the item done
+	// whatever its state, as reprocessing does.
+	UpdateProcessedJob(result *models.ProcessedJob) error
+
+	LeaseJobs(opts LeaseOptions, policy RetryPolicy) ([]Lease, error)
+	// CompleteJob and FailJob finish a lease. They return ErrLeaseLost when
+	// the item is no longer in progress under that lease.
+	CompleteJob(lease Lease, result *models.ProcessedJob) error
+	FailJob(lease Lease, cause error, policy RetryPolicy) (string, error)
+	DeadJobs() ([]QueueEntry, error)
+	RequeueJobs(ids []string) (int, error)
+	QueueStats() (map[string]int, error)
+	Close() error
+}
+
+var (
+	_ Repository = (*Postgres)(nil)
+	_ Repository = (*SQLite)(nil)
+	_ Repository = (*Memory)(nil)
+)
+
+// RepairReport counts the rows fixed by RepairQueue.
+type RepairReport struct {
+	// Queued vacancies had no queue entry.
+	Queued int
+	// Removed queue entries pointed at a missing vacancy.
+	Removed int
+}
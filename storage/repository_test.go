@@ -0,0 +1,465 @@
+package storage_test
+
+import (
+	"database/sql"
+	"errors"
+	"hh_bot/models"
+	"hh_bot/storage"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRepositories(t *testing.T) {
+	backends := map[string]func(t *testing.T) storage.Repository{
+		"memory": func(t *testing.T) storage.Repository {
+			return storage.NewMemory()
+		},
+		"sqlite": func(t *testing.T) storage.Repository {
+			repo, err := storage.NewSQLite(filepath.Join(t.TempDir(), "hh_bot.db"))
+			if err != nil {
+				t.Fatalf("NewSQLite: %v", err)
+			}
+			return repo
+		},
+	}
+
+	for name, open := range backends {
+		t.Run(name, func(t *testing.T) {
+			repo := open(t)
+			defer repo.Close()
+			testRepository(t, repo)
+		})
+	}
+}
+
+func testRepository(t *testing.T, repo storage.Repository) {
+	published := models.CustomTime(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+
+	existing, err := repo.ExistingJobIDs([]string{"1", "2"})
+	if err != nil || len(existing) != 0 {
+		t.Fatalf("ExistingJobIDs on empty repository = %v, %v", existing, err)
+	}
+
+	inserted, err := repo.SaveJobs([]models.JobAd{
+		{ID: "2", Name: "Go developer", Descrtiption: "Go and Postgres", DescriptionHTML: "<p>Go and Postgres</p>", PublishedAt: published},
+		{ID: "1", Name: "Python developer", Descrtiption: "Django"},
+	})
+	if err != nil {
+		t.Fatalf("SaveJobs: %v", err)
+	}
+	if len(inserted) != 2 {
+		t.Fatalf("SaveJobs inserted %v, want both jobs", inserted)
+	}
+
+	existing, err = repo.ExistingJobIDs([]string{"1", "2", "3"})
+	if err != nil {
+		t.Fatalf("ExistingJobIDs: %v", err)
+	}
+	if !existing["1"] || !existing["2"] || existing["3"] {
+		t.Errorf("ExistingJobIDs = %v", existing)
+	}
+
+	// Saving an existing id again must be a no-op.
+	inserted, err = repo.SaveJobs([]models.JobAd{
+		{ID: "2", Name: "changed"},
+		{ID: "3", Name: "Data scientist"},
+		{ID: "4", Name: "ML engineer"},
+	})
+	if err != nil {
+		t.Fatalf("SaveJobs: %v", err)
+	}
+	if len(inserted) != 2 || inserted[0] != "3" || inserted[1] != "4" {
+		t.Errorf("SaveJobs inserted %v, want [3 4]", inserted)
+	}
+
+	policy := storage.RetryPolicy{MaxAttempts: 2, MaxDelay: time.Hour, LeaseTimeout: time.Hour}
+
+	leases, err := repo.LeaseJobs(storage.LeaseOptions{Limit: 2}, policy)
+	if err != nil {
+		t.Fatalf("LeaseJobs: %v", err)
+	}
+	leased := byID(leases)
+	if len(leases) != 2 || len(leased) != 2 || leased["1"].Attempts != 1 {
+		t.Fatalf("unexpected leases: %+v", leases)
+	}
+	job := leased["2"].Job
+	if job.Name != "Go developer" || job.Descrtiption != "Go and Postgres" {
+		t.Errorf("job was not stored as first saved: %+v", job)
+	}
+	if job.DescriptionHTML != "<p>Go and Postgres</p>" {
+		t.Errorf("DescriptionHTML = %q", job.DescriptionHTML)
+	}
+	if !time.Time(job.PublishedAt).Equal(time.Time(published)) {
+		t.Errorf("PublishedAt = %v, want %v", job.PublishedAt, published)
+	}
+
+	result := &models.ProcessedJob{
+		JobID:       "2",
+		CoverLetter: "Hello",
+		Validation:  &models.LetterValidation{Valid: true, Score: 1, Attempts: 1},
+		PromptHash:  "abc",
+		Model:       "test-model",
+		Params:      map[string]any{"temperature": 0.2},
+		ProcessedAt: time.Now(),
+	}
+	if err := repo.CompleteJob(leased["2"], result); err != nil {
+		t.Fatalf("CompleteJob: %v", err)
+	}
+	if err := repo.CompleteJob(leased["2"], result); !errors.Is(err, storage.ErrLeaseLost) {
+		t.Fatalf("CompleteJob of a done job = %v, want ErrLeaseLost", err)
+	}
+
+	status, err := repo.FailJob(leased["1"], errors.New("llm timeout"), policy)
+	if err != nil || status != storage.StatusFailed {
+		t.Fatalf("FailJob = %q, %v, want failed", status, err)
+	}
+
+	// The failed job is ready again right away as the policy has no delay;
+	// the done job is never handed out again.
+	leases, err = repo.LeaseJobs(storage.LeaseOptions{Limit: 10}, policy)
+	if err != nil {
+		t.Fatalf("LeaseJobs: %v", err)
+	}
+	leased = byID(leases)
+	if len(leases) != 3 || leased["1"].Attempts != 2 || leased["3"].Attempts != 1 || leased["4"].Attempts != 1 {
+		t.Fatalf("unexpected leases: %+v", leases)
+	}
+
+	status, err = repo.FailJob(leased["1"], errors.New("llm timeout"), policy)
+	if err != nil || status != storage.StatusDead {
+		t.Fatalf("FailJob = %q, %v, want dead", status, err)
+	}
+
+	leases, err = repo.LeaseJobs(storage.LeaseOptions{Limit: 10}, policy)
+	if err != nil || len(leases) != 0 {
+		t.Fatalf("LeaseJobs with everything leased = %+v, %v", leases, err)
+	}
+
+	dead, err := repo.DeadJobs()
+	if err != nil {
+		t.Fatalf("DeadJobs: %v", err)
+	}
+	if len(dead) != 1 || dead[0].JobID != "1" || dead[0].Name != "Python developer" ||
+		dead[0].Attempts != 2 || dead[0].LastError != "llm timeout" {
+		t.Fatalf("unexpected dead jobs: %+v", dead)
+	}
+
+	stats, err := repo.QueueStats()
+	if err != nil {
+		t.Fatalf("QueueStats: %v", err)
+	}
+	want := map[string]int{storage.StatusDone: 1, storage.StatusDead: 1, storage.StatusInProgress: 2}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("QueueStats = %v, want %v", stats, want)
+	}
+
+	requeued, err := repo.RequeueJobs(nil)
+	if err != nil || requeued != 1 {
+		t.Fatalf("RequeueJobs = %d, %v", requeued, err)
+	}
+
+	// A lease that times out is handed out again, and becomes dead once it
+	// has used up its attempts.
+	expiring := policy
+	expiring.LeaseTimeout = -time.Second
+	var stale []storage.Lease
+	for attempt := 1; attempt <= 2; attempt++ {
+		stale = leases
+		leases, err = repo.LeaseJobs(storage.LeaseOptions{Limit: 10}, expiring)
+		if err != nil {
+			t.Fatalf("LeaseJobs: %v", err)
+		}
+		if len(leases) != 1 || leases[0].Job.ID != "1" || leases[0].Attempts != attempt {
+			t.Fatalf("attempt %d: unexpected leases: %+v", attempt, leases)
+		}
+	}
+
+	// The first expired lease was handed out again, so its processor may
+	// no longer finish it.
+	if _, err := repo.FailJob(stale[0], errors.New("late"), policy); !errors.Is(err, storage.ErrLeaseLost) {
+		t.Fatalf("FailJob of an expired lease = %v, want ErrLeaseLost", err)
+	}
+	if err := repo.CompleteJob(stale[0], &models.ProcessedJob{JobID: "1", CoverLetter: "late"}); !errors.Is(err, storage.ErrLeaseLost) {
+		t.Fatalf("CompleteJob of an expired lease = %v, want ErrLeaseLost", err)
+	}
+	leases, err = repo.LeaseJobs(storage.LeaseOptions{Limit: 10}, expiring)
+	if err != nil || len(leases) != 0 {
+		t.Fatalf("LeaseJobs after expired attempts = %+v, %v", leases, err)
+	}
+	if dead, _ := repo.DeadJobs(); len(dead) != 1 || dead[0].JobID != "1" {
+		t.Fatalf("expected job 1 to be dead after expired leases: %+v", dead)
+	}
+
+	report, err := repo.RepairQueue()
+	if err != nil {
+		t.Fatalf("RepairQueue: %v", err)
+	}
+	if report != (storage.RepairReport{}) {
+		t.Errorf("RepairQueue on a consistent repository = %+v", report)
+	}
+}
+
+func byID(leases []storage.Lease) map[string]storage.Lease {
+	leased := make(map[string]storage.Lease)
+	for _, lease := range leases {
+		leased[lease.Job.ID] = lease
+	}
+	return leased
+}
+
+func TestLeaseOrder(t *testing.T) {
+	backends := map[string]func(t *testing.T) storage.Repository{
+		"memory": func(t *testing.T) storage.Repository {
+			return storage.NewMemory()
+		},
+		"sqlite": func(t *testing.T) storage.Repository {
+			repo, err := storage.NewSQLite(filepath.Join(t.TempDir(), "hh_bot.db"))
+			if err != nil {
+				t.Fatalf("NewSQLite: %v", err)
+			}
+			return repo
+		},
+	}
+
+	day := func(d int) models.CustomTime {
+		return models.CustomTime(time.Date(2024, 5, d, 0, 0, 0, 0, time.UTC))
+	}
+	jobs := []models.JobAd{
+		{ID: "1", Name: "Go developer", PublishedAt: day(1), KeySkills: []models.KeySkill{{Name: "Go"}, {Name: "PostgreSQL"}}},
+		{ID: "2", Name: "Python developer", PublishedAt: day(3), KeySkills: []models.KeySkill{{Name: "Python"}}},
+		{ID: "3", Name: "Backend developer", PublishedAt: day(2), Descrtiption: "Golang, Docker"},
+	}
+	tests := []struct {
+		opts storage.LeaseOptions
+		want []string
+	}{
+		{storage.LeaseOptions{Order: storage.OrderNewest}, []string{"2", "3", "1"}},
+		{storage.LeaseOptions{Order: storage.OrderNewest, Limit: 2}, []string{"2", "3"}},
+		{storage.LeaseOptions{Order: storage.OrderFit, ProfileSkills: []string{"golang", "postgres"}}, []string{"1", "3", "2"}},
+		{storage.LeaseOptions{Order: storage.OrderFit, ProfileSkills: []string{"Docker"}, Limit: 1}, []string{"3"}},
+	}
+
+	for name, open := range backends {
+		t.Run(name, func(t *testing.T) {
+			for _, tt := range tests {
+				repo := open(t)
+				if _, err := repo.SaveJobs(jobs); err != nil {
+					t.Fatalf("SaveJobs: %v", err)
+				}
+
+				leases, err := repo.LeaseJobs(tt.opts, storage.DefaultRetryPolicy())
+				if err != nil {
+					t.Fatalf("LeaseJobs(%+v): %v", tt.opts, err)
+				}
+				var got []string
+				for _, lease := range leases {
+					got = append(got, lease.Job.ID)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("LeaseJobs(%+v) = %v, want %v", tt.opts, got, tt.want)
+				}
+				if leases[0].Job.Name == "" {
+					t.Errorf("leased job is not fully loaded: %+v", leases[0].Job)
+				}
+				repo.Close()
+			}
+
+			repo := open(t)
+			defer repo.Close()
+			if _, err := repo.LeaseJobs(storage.LeaseOptions{Order: "oldest"}, storage.DefaultRetryPolicy()); err == nil {
+				t.Errorf("expected an error for an unknown order")
+			}
+		})
+	}
+}
+
+func TestSQLiteRepairQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hh_bot.db")
+	repo, err := storage.NewSQLite(path)
+	if err != nil {
+		t.Fatalf("NewSQLite: %v", err)
+	}
+	defer repo.Close()
+
+	if _, err := repo.SaveJobs([]models.JobAd{{ID: "1"}, {ID: "2"}}); err != nil {
+		t.Fatalf("SaveJobs: %v", err)
+	}
+
+	// Simulate a crash between the two inserts of an older version, and a
+	// queue entry left behind by a deleted vacancy.
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`DELETE FROM processed_job_ads WHERE job_id = '1'`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO processed_job_ads (job_id) VALUES ('gone')`); err != nil {
+		t.Fatal(err)
+	}
+
+	existing, err := repo.ExistingJobIDs([]string{"1", "2"})
+	if err != nil {
+		t.Fatalf("ExistingJobIDs: %v", err)
+	}
+	if existing["1"] || !existing["2"] {
+		t.Errorf("unqueued job must not count as existing: %v", existing)
+	}
+
+	report, err := repo.RepairQueue()
+	if err != nil {
+		t.Fatalf("RepairQueue: %v", err)
+	}
+	if report.Queued != 1 || report.Removed != 1 {
+		t.Errorf("RepairQueue = %+v, want 1 queued and 1 removed", report)
+	}
+
+	leases, err := repo.LeaseJobs(storage.LeaseOptions{Limit: 10}, storage.DefaultRetryPolicy())
+	if err != nil {
+		t.Fatalf("LeaseJobs: %v", err)
+	}
+	if len(leases) != 2 {
+		t.Errorf("expected both jobs queued after repair, got %+v", leases)
+	}
+}
+
+func TestSQLiteIndexesOldJobs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hh_bot.db")
+	repo, err := storage.NewSQLite(path)
+	if err != nil {
+		t.Fatalf("NewSQLite: %v", err)
+	}
+	_, err = repo.SaveJobs([]models.JobAd{
+		{ID: "1", Name: "Python developer", PublishedAt: models.CustomTime(time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC))},
+		{ID: "2", Name: "Go developer", KeySkills: []models.KeySkill{{Name: "Go"}}},
+		{ID: "0", Name: "Java developer"},
+	})
+	if err != nil {
+		t.Fatalf("SaveJobs: %v", err)
+	}
+	repo.Close()
+
+	// Files of older versions have neither column filled in.
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := db.Exec(`UPDATE job_ads SET published_at = NULL, skills = NULL`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	repo, err = storage.NewSQLite(path)
+	if err != nil {
+		t.Fatalf("NewSQLite: %v", err)
+	}
+	defer repo.Close()
+
+	leases, err := repo.LeaseJobs(storage.LeaseOptions{Order: storage.OrderNewest, Limit: 1}, storage.DefaultRetryPolicy())
+	if err != nil {
+		t.Fatalf("LeaseJobs: %v", err)
+	}
+	if len(leases) != 1 || leases[0].Job.ID != "1" {
+		t.Errorf("LeaseJobs = %+v, want the only published job", leases)
+	}
+	leases, err = repo.LeaseJobs(storage.LeaseOptions{Order: storage.OrderFit, ProfileSkills: []string{"golang"}, Limit: 1}, storage.DefaultRetryPolicy())
+	if err != nil {
+		t.Fatalf("LeaseJobs: %v", err)
+	}
+	if len(leases) != 1 || leases[0].Job.ID != "2" {
+		t.Errorf("LeaseJobs = %+v, want the Go job", leases)
+	}
+}
+
+func TestSQLiteUpgrades(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hh_bot.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	// The schema of the first SQLite version, which had no queue states.
+	_, err = db.Exec(`
+	CREATE TABLE job_ads (id TEXT PRIMARY KEY, name TEXT NOT NULL, description TEXT, description_html TEXT, data TEXT NOT NULL, created_at TEXT NOT NULL);
+	CREATE TABLE processed_job_ads (job_id TEXT PRIMARY KEY, processed INTEGER NOT NULL DEFAULT 0, cover_letter TEXT, thinking TEXT,
+		validation TEXT, valid INTEGER, prompt_hash TEXT, prompt_version TEXT, model TEXT, params TEXT, processed_at TEXT);
+	INSERT INTO job_ads VALUES ('1', 'Go developer', '', '', '{"id": "1", "name": "Go developer"}', '2024-05-01T00:00:00.000Z');
+	INSERT INTO job_ads VALUES ('2', 'ML engineer', '', '', '{"id": "2", "name": "ML engineer"}', '2024-05-01T00:00:00.000Z');
+	INSERT INTO processed_job_ads (job_id, processed, cover_letter) VALUES ('1', 1, 'Hello'), ('2', 0, NULL);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	open := func() map[string]int {
+		t.Helper()
+		repo, err := storage.NewSQLite(path)
+		if err != nil {
+			t.Fatalf("NewSQLite: %v", err)
+		}
+		defer repo.Close()
+		stats, err := repo.QueueStats()
+		if err != nil {
+			t.Fatalf("QueueStats: %v", err)
+		}
+		return stats
+	}
+	version := func(db *sql.DB) int {
+		t.Helper()
+		var version int
+		if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+			t.Fatal(err)
+		}
+		return version
+	}
+
+	if stats := open(); !reflect.DeepEqual(stats, map[string]int{storage.StatusDone: 1, storage.StatusPending: 1}) {
+		t.Errorf("QueueStats after the upgrade = %v", stats)
+	}
+	if got := version(db); got != storage.SQLiteVersion {
+		t.Errorf("user_version = %d, want %d", got, storage.SQLiteVersion)
+	}
+
+	// The upgrades ran once, so they leave later changes alone.
+	if _, err := db.Exec(`UPDATE processed_job_ads SET status = 'pending'`); err != nil {
+		t.Fatal(err)
+	}
+	if stats := open(); !reflect.DeepEqual(stats, map[string]int{storage.StatusPending: 2}) {
+		t.Errorf("QueueStats after reopening = %v", stats)
+	}
+
+	fresh := filepath.Join(t.TempDir(), "fresh.db")
+	repo, err := storage.NewSQLite(fresh)
+	if err != nil {
+		t.Fatalf("NewSQLite: %v", err)
+	}
+	repo.Close()
+	freshDB, err := sql.Open("sqlite", fresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer freshDB.Close()
+	if got := version(freshDB); got != storage.SQLiteVersion {
+		t.Errorf("user_version of a new file = %d, want %d", got, storage.SQLiteVersion)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := storage.RetryPolicy{BaseDelay: time.Minute, MaxDelay: time.Hour}
+	cases := map[int]time.Duration{
+		1:  time.Minute,
+		2:  2 * time.Minute,
+		3:  4 * time.Minute,
+		7:  time.Hour,
+		50: time.Hour,
+	}
+	for attempts, want := range cases {
+		if got := policy.Delay(attempts); got != want {
+			t.Errorf("Delay(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
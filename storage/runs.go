@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Run states. A run is RunRunning until it finishes; one that stays so
+// after its process ended was killed.
+const (
+	RunRunning = "running"
+	RunOK      = "ok"
+	RunPartial = "partial"
+	RunFailed  = "failed"
+)
+
+// Run is the audit record of a command or daemon task.
+type Run struct {
+	ID         int64
+	Mode       string
+	StartedAt  time.Time
+	FinishedAt *time.Time
+	Status     string
+	Error      string
+	Queries    []QueryCount
+	// Pages are search result pages and Details vacancy requests. Saved
+	// and Skipped are new and already stored items; Failed are requests,
+	// vacancies or applications that failed.
+	Pages     int
+	Details   int
+	Saved     int
+	Skipped   int
+	Failed    int
+	LLMCalls  int
+	LLMTokens int
+}
+
+// QueryCount is what one search of a fetch found.
+type QueryCount struct {
+	Query      string `json:"query"`
+	Found      int    `json:"found"`
+	New        int    `json:"new"`
+	Duplicates int    `json:"duplicates"`
+	Failed     int    `json:"failed"`
+}
+
+func StartRun(dbpool *pgxpool.Pool, mode string) (int64, time.Time, error) {
+	var id int64
+	var startedAt time.Time
+	err := dbpool.QueryRow(context.Background(),
+		`INSERT INTO runs (mode) VALUES ($1) RETURNING id, started_at`, mode).Scan(&id, &startedAt)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to start run: %w", err)
+	}
+	return id, startedAt, nil
+}
+
+// FinishRun stores the final state and counts of a started run.
+func FinishRun(dbpool *pgxpool.Pool, run Run) error {
+	queries := run.Queries
+	if queries == nil {
+		queries = []QueryCount{}
+	}
+	_, err := dbpool.Exec(context.Background(), `
+	UPDATE runs SET finished_at = now(), status = $2, error = nullif($3, ''), queries = $4,
+		pages = $5, details = $6, saved = $7, skipped = $8, failed = $9, llm_calls = $10, llm_tokens = $11
+	WHERE id = $1
+	`, run.ID, run.Status, run.Error, queries, run.Pages, run.Details, run.Saved, run.Skipped, run.Failed,
+		run.LLMCalls, run.LLMTokens)
+	if err != nil {
+		return fmt.Errorf("failed to finish run %d: %w", run.ID, err)
+	}
+	return nil
+}
+
+const runColumns = `id, mode, started_at, finished_at, status, coalesce(error, ''), queries,
+	pages, details, saved, skipped, failed, llm_calls, llm_tokens`
+
+func scanRun(row pgx.Row) (Run, error) {
+	var r Run
+	err := row.Scan(&r.ID, &r.Mode, &r.StartedAt, &r.FinishedAt, &r.Status, &r.Error, &r.Queries,
+		&r.Pages, &r.Details, &r.Saved, &r.Skipped, &r.Failed, &r.LLMCalls, &r.LLMTokens)
+	return r, err
+}
+
+// LoadRuns returns the latest runs, newest first. With problemsOnly it
+// returns only runs that did not finish ok.
+func LoadRuns(dbpool *pgxpool.Pool, limit int, problemsOnly bool) ([]Run, error) {
+	rows, err := dbpool.Query(context.Background(), `
+	SELECT `+runColumns+`
+	FROM runs
+	WHERE NOT $2 OR status <> 'ok'
+	ORDER BY started_at DESC, id DESC
+	LIMIT $1
+	`, limit, problemsOnly)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load runs: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []Run
+	for rows.Next() {
+		r, err := scanRun(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan run: %w", err)
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+func LoadRun(dbpool *pgxpool.Pool, id int64) (Run, error) {
+	r, err := scanRun(dbpool.QueryRow(context.Background(), `SELECT `+runColumns+` FROM runs WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return r, fmt.Errorf("no run %d", id)
+	}
+	if err != nil {
+		return r, fmt.Errorf("failed to load run %d: %w", id, err)
+	}
+	return r, nil
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// SavedSearch is an HH vacancy search that fetch runs. Filters are extra
+// search parameters such as area or experience. Schedule is an optional
+// cron expression for the daemon.
+type SavedSearch struct {
+	ID        int
+	Name      string
+	Query     string
+	Filters   url.Values
+	Schedule  string
+	Owner     string
+	Enabled   bool
+	CreatedAt time.Time
+	// LastRun is the latest run, if any, when loaded by LoadSavedSearches.
+	LastRun *SearchRun
+}
+
+// SearchRun is what one fetch of a saved search found.
+type SearchRun struct {
+	SearchID   int
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Found      int
+	New        int
+	Duplicates int
+	Failed     int
+}
+
+type SavedSearchFilter struct {
+	// Ref selects one search by name or id.
+	Ref         string
+	Owner       string
+	EnabledOnly bool
+	// Unscheduled leaves out searches with their own schedule.
+	Unscheduled bool
+}
+
+func AddSavedSearch(dbpool *pgxpool.Pool, s SavedSearch) (int, error) {
+	if s.Filters == nil {
+		s.Filters = url.Values{}
+	}
+	var id int
+	err := dbpool.QueryRow(context.Background(), `
+	INSERT INTO searches (name, query, filters, schedule, owner, enabled)
+	VALUES ($1, $2, $3, nullif($4, ''), nullif($5, ''), $6)
+	RETURNING id
+	`, s.Name, s.Query, s.Filters, s.Schedule, s.Owner, s.Enabled).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to add search %s: %w", s.Name, err)
+	}
+	return id, nil
+}
+
+// LoadSavedSearches returns the searches matching filter in the order they
+// were added, each with its latest run.
+func LoadSavedSearches(dbpool *pgxpool.Pool, filter SavedSearchFilter) ([]SavedSearch, error) {
+	var args []any
+	conditions := []string{"true"}
+	addCondition := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.Ref != "" {
+		addCondition("(s.name = $%[1]d OR s.id::text = $%[1]d)", filter.Ref)
+	}
+	if filter.Owner != "" {
+		addCondition("s.owner = $%d", filter.Owner)
+	}
+	if filter.EnabledOnly {
+		conditions = append(conditions, "s.enabled")
+	}
+	if filter.Unscheduled {
+		conditions = append(conditions, "s.schedule IS NULL")
+	}
+
+	query := fmt.Sprintf(`
+	SELECT s.id, s.name, s.query, s.filters, coalesce(s.schedule, ''), coalesce(s.owner, ''), s.enabled, s.created_at,
+		r.started_at, r.finished_at, r.found, r.new, r.duplicates, r.failed
+	FROM searches s
+	LEFT JOIN LATERAL (
+		SELECT * FROM search_runs WHERE search_id = s.id ORDER BY started_at DESC LIMIT 1
+	) r ON true
+	WHERE %s
+	ORDER BY s.id
+	`, strings.Join(conditions, " AND "))
+
+	rows, err := dbpool.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load searches: %w", err)
+	}
+	defer rows.Close()
+
+	var searches []SavedSearch
+	for rows.Next() {
+		var s SavedSearch
+		var startedAt, finishedAt *time.Time
+		var found, inserted, duplicates, failed *int
+		if err := rows.Scan(&s.ID, &s.Name, &s.Query, &s.Filters, &s.Schedule, &s.Owner, &s.Enabled, &s.CreatedAt,
+			&startedAt, &finishedAt, &found, &inserted, &duplicates, &failed); err != nil {
+			return nil, fmt.Errorf("failed to scan search: %w", err)
+		}
+		if startedAt != nil {
+			s.LastRun = &SearchRun{SearchID: s.ID, StartedAt: *startedAt, FinishedAt: *finishedAt,
+				Found: *found, New: *inserted, Duplicates: *duplicates, Failed: *failed}
+		}
+		searches = append(searches, s)
+	}
+
+	return searches, rows.Err()
+}
+
+// SetSavedSearchEnabled enables or disables the search with the given name
+// or id.
+func SetSavedSearchEnabled(dbpool *pgxpool.Pool, ref string, enabled bool) error {
+	tag, err := dbpool.Exec(context.Background(),
+		`UPDATE searches SET enabled = $2 WHERE name = $1 OR id::text = $1`, ref, enabled)
+	if err != nil {
+		return fmt.Errorf("failed to update search %s: %w", ref, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("no saved search %q", ref)
+	}
+	return nil
+}
+
+// RemoveSavedSearch deletes the search with the given name or id and its
+// runs.
+func RemoveSavedSearch(dbpool *pgxpool.Pool, ref string) error {
+	tag, err := dbpool.Exec(context.Background(), `DELETE FROM searches WHERE name = $1 OR id::text = $1`, ref)
+	if err != nil {
+		return fmt.Errorf("failed to remove search %s: %w", ref, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("no saved search %q", ref)
+	}
+	return nil
+}
+
+// SaveSearchRun records a run of a saved search. A search removed while
+// it ran is ignored.
+func SaveSearchRun(dbpool *pgxpool.Pool, run SearchRun) error {
+	_, err := dbpool.Exec(context.Background(), `
+	INSERT INTO search_runs (search_id, started_at, finished_at, found, new, duplicates, failed)
+	SELECT $1, $2, $3, $4, $5, $6, $7
+	WHERE EXISTS (SELECT 1 FROM searches WHERE id = $1)
+	`, run.SearchID, run.StartedAt, run.FinishedAt, run.Found, run.New, run.Duplicates, run.Failed)
+	if err != nil {
+		return fmt.Errorf("failed to save run of search %d: %w", run.SearchID, err)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// SearchQuery is a full-text query over stored vacancies. Text uses web
+// search syntax: "quoted phrases", or, and -excluded words. With Raw set it
+// is passed to to_tsquery instead, which allows &, |, ! and <-> directly.
+type SearchQuery struct {
+	Text      string
+	Raw       bool
+	MinSalary int
+	// Currency is the HH currency code of MinSalary, RUR when empty.
+	// Vacancies paying in other currencies do not match a MinSalary.
+	Currency   string
+	Area       string
+	Experience string
+	// Status is a queue state, or "valid"/"invalid" for the letter check.
+	Status string
+	Limit  int
+}
+
+type SearchResult struct {
+	JobID      string
+	Name       string
+	Employer   string
+	Area       string
+	SalaryFrom *int
+	SalaryTo   *int
+	Currency   string
+	Status     string
+	Rank       float64
+	Snippet    string
+}
+
+// Snippets mark matches the way the Markdown descriptions mark bold text.
+const headlineOptions = "StartSel=**, StopSel=**, MaxFragments=2, MinWords=8, MaxWords=25, FragmentDelimiter=\" … \""
+
+func SearchJobs(dbpool *pgxpool.Pool, q SearchQuery) ([]SearchResult, error) {
+	query, args, err := searchQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := dbpool.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var r SearchResult
+		if err := rows.Scan(&r.JobID, &r.Name, &r.Employer, &r.Area, &r.SalaryFrom, &r.SalaryTo,
+			&r.Currency, &r.Status, &r.Rank, &r.Snippet); err != nil {
+			return nil, fmt.Errorf("failed to scan search result: %w", err)
+		}
+		results = append(results, r)
+	}
+
+	return results, rows.Err()
+}
+
+func searchQuery(q SearchQuery) (string, []any, error) {
+	args := []any{q.Text}
+	tsquery := "websearch_to_tsquery('ru_en', $1)"
+	if q.Raw {
+		tsquery = "to_tsquery('ru_en', $1)"
+	}
+
+	conditions := []string{"j.search_vector @@ q.query"}
+	addCondition := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if q.MinSalary > 0 {
+		currency := q.Currency
+		if currency == "" {
+			currency = "RUR"
+		}
+		addCondition("j.salary_currency = $%d", currency)
+		addCondition("coalesce(j.salary_to, j.salary_from) >= $%d", q.MinSalary)
+	}
+	if q.Area != "" {
+		addCondition("(j.area_id = $%[1]d OR j.area_name ILIKE $%[1]d)", q.Area)
+	}
+	if q.Experience != "" {
+		addCondition("(j.experience_id = $%[1]d OR j.experience_name ILIKE $%[1]d)", q.Experience)
+	}
+
+	if err := statusCondition(q.Status, addCondition, &conditions); err != nil {
+		return "", nil, err
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = 20
+	}
+	args = append(args, limit)
+
+	query := fmt.Sprintf(`
+	SELECT j.id, j.name, coalesce(j.employer_name, ''), coalesce(j.area_name, ''),
+		j.salary_from, j.salary_to, coalesce(j.salary_currency, ''), coalesce(p.status, ''),
+		ts_rank_cd(j.search_vector, q.query) AS rank,
+		ts_headline('ru_en', coalesce(j.description, ''), q.query, '%s')
+	FROM job_ads j
+	CROSS JOIN %s AS q(query)
+	LEFT JOIN processed_job_ads p ON p.job_id = j.id
+	WHERE %s
+	ORDER BY rank DESC, j.published_at DESC NULLS LAST
+	LIMIT $%d
+	`, headlineOptions, tsquery, strings.Join(conditions, " AND "), len(args))
+
+	return query, args, nil
+}
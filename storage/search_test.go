@@ -0,0 +1,114 @@
+package storage_test
+
+import (
+	"hh_bot/models"
+	"hh_bot/storage"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSearchJobsQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query storage.SearchQuery
+		where string
+		args  []any
+	}{
+		{
+			name:  "text only",
+			query: storage.SearchQuery{Text: "golang"},
+			where: "WHERE j.search_vector @@ q.query\n",
+			args:  []any{"golang", 20},
+		},
+		{
+			name:  "salary in roubles by default",
+			query: storage.SearchQuery{Text: "golang", MinSalary: 200000, Limit: 5},
+			where: "WHERE j.search_vector @@ q.query AND j.salary_currency = $2 AND coalesce(j.salary_to, j.salary_from) >= $3\n",
+			args:  []any{"golang", "RUR", 200000, 5},
+		},
+		{
+			name:  "salary in dollars and filters",
+			query: storage.SearchQuery{Text: "ml", MinSalary: 3000, Currency: "USD", Area: "1", Status: "valid"},
+			where: "WHERE j.search_vector @@ q.query AND j.salary_currency = $2 AND coalesce(j.salary_to, j.salary_from) >= $3" +
+				" AND (j.area_id = $4 OR j.area_name ILIKE $4) AND p.status = 'done' AND p.valid = true\n",
+			args: []any{"ml", "USD", 3000, "1", 20},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args, err := storage.SearchJobsQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(query, tt.where) {
+				t.Errorf("query does not contain %q:\n%s", tt.where, query)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("args = %v, want %v", args, tt.args)
+			}
+		})
+	}
+
+	if _, _, err := storage.SearchJobsQuery(storage.SearchQuery{Text: "go", Status: "lost"}); err == nil {
+		t.Error("an unknown status must fail")
+	}
+	query, _, _ := storage.SearchJobsQuery(storage.SearchQuery{Text: "go & !java", Raw: true})
+	if !strings.Contains(query, "to_tsquery('ru_en', $1)") || strings.Contains(query, "websearch_to_tsquery") {
+		t.Errorf("raw query must use to_tsquery:\n%s", query)
+	}
+}
+
+func TestSearchJobs(t *testing.T) {
+	dbpool := openPostgres(t)
+
+	salary := func(from int, currency string) models.Salary {
+		return models.Salary{From: &from, Currency: currency}
+	}
+	_, err := storage.NewPostgres(dbpool).SaveJobs([]models.JobAd{
+		{ID: "1", Name: "Golang developer", Salary: salary(250000, "RUR")},
+		{ID: "2", Name: "Golang developer", Salary: salary(5000, "USD")},
+		{ID: "3", Name: "Golang developer", Salary: salary(150000, "RUR")},
+		{ID: "4", Name: "Python developer", Salary: salary(300000, "RUR")},
+	})
+	if err != nil {
+		t.Fatalf("SaveJobs: %v", err)
+	}
+
+	for _, tt := range []struct {
+		query storage.SearchQuery
+		want  []string
+	}{
+		{storage.SearchQuery{Text: "golang"}, []string{"1", "2", "3"}},
+		{storage.SearchQuery{Text: "golang", MinSalary: 200000}, []string{"1"}},
+		{storage.SearchQuery{Text: "golang", MinSalary: 3000, Currency: "USD"}, []string{"2"}},
+	} {
+		results, err := storage.SearchJobs(dbpool, tt.query)
+		if err != nil {
+			t.Fatalf("SearchJobs(%+v): %v", tt.query, err)
+		}
+		var ids []string
+		for _, r := range results {
+			ids = append(ids, r.JobID)
+		}
+		if !sameIDs(ids, tt.want) {
+			t.Errorf("SearchJobs(%+v) = %v, want %v", tt.query, ids, tt.want)
+		}
+	}
+}
+
+func sameIDs(got, want []string) bool {
+	seen := make(map[string]bool, len(got))
+	for _, id := range got {
+		seen[id] = true
+	}
+	if len(got) != len(want) {
+		return false
+	}
+	for _, id := range want {
+		if !seen[id] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,621 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"hh_bot/models"
+	"hh_bot/skills"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLite is a single-file backend for running without a Postgres server.
+// Vacancies are stored as JSON next to the few columns that are queried, and
+// the schema is created on open instead of through the Postgres migrations.
+type SQLite struct {
+	db *sql.DB
+}
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS job_ads (
+	id               TEXT PRIMARY KEY,
+	name             TEXT NOT NULL,
+	description      TEXT,
+	description_html TEXT,
+	data             TEXT NOT NULL,
+	created_at       TEXT NOT NULL,
+	published_at     TEXT,
+	skills           TEXT
+);
+
+CREATE TABLE IF NOT EXISTS processed_job_ads (
+	job_id         TEXT PRIMARY KEY REFERENCES job_ads (id),
+	processed      INTEGER NOT NULL DEFAULT 0,
+	cover_letter   TEXT,
+	thinking       TEXT,
+	validation     TEXT,
+	valid          INTEGER,
+	prompt_hash    TEXT,
+	prompt_version TEXT,
+	model          TEXT,
+	params         TEXT,
+	processed_at   TEXT,
+	status          TEXT NOT NULL DEFAULT 'pending',
+	attempts        INTEGER NOT NULL DEFAULT 0,
+	last_error      TEXT,
+	next_attempt_at TEXT NOT NULL DEFAULT '',
+	leased_until    TEXT,
+	updated_at      TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS job_letters (
+	id             INTEGER PRIMARY KEY AUTOINCREMENT,
+	job_id         TEXT NOT NULL,
+	cover_letter   TEXT,
+	thinking       TEXT,
+	validation     TEXT,
+	valid          INTEGER,
+	prompt_hash    TEXT,
+	prompt_version TEXT,
+	model          TEXT,
+	params         TEXT,
+	created_at     TEXT NOT NULL
+);
+`
+
+// sqliteUpgrade brings a file created by an older version one step closer
+// to sqliteSchema. PRAGMA user_version counts the upgrades a file has, so
+// each one runs once. Files from before user_version was kept start at 0
+// and may already have some columns; an upgrade adding column to table is
+// skipped when it exists.
+type sqliteUpgrade struct {
+	table, column string
+	statement     string
+}
+
+// sqliteUpgrades are only ever appended to.
+var sqliteUpgrades = []sqliteUpgrade{
+	{"processed_job_ads", "status", `ALTER TABLE processed_job_ads ADD COLUMN status TEXT NOT NULL DEFAULT 'pending'`},
+	{"processed_job_ads", "attempts", `ALTER TABLE processed_job_ads ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0`},
+	{"processed_job_ads", "last_error", `ALTER TABLE processed_job_ads ADD COLUMN last_error TEXT`},
+	{"processed_job_ads", "next_attempt_at", `ALTER TABLE processed_job_ads ADD COLUMN next_attempt_at TEXT NOT NULL DEFAULT ''`},
+	{"processed_job_ads", "leased_until", `ALTER TABLE processed_job_ads ADD COLUMN leased_until TEXT`},
+	{"processed_job_ads", "updated_at", `ALTER TABLE processed_job_ads ADD COLUMN updated_at TEXT NOT NULL DEFAULT ''`},
+	{"", "", `UPDATE processed_job_ads SET status = 'done' WHERE processed = 1 AND status = 'pending'`},
+	{"job_ads", "published_at", `ALTER TABLE job_ads ADD COLUMN published_at TEXT`},
+	{"job_ads", "skills", `ALTER TABLE job_ads ADD COLUMN skills TEXT`},
+}
+
+// sqliteUpgradeSchema creates the schema of a new file, or applies the
+// upgrades an existing file does not have yet.
+func sqliteUpgradeSchema(db *sql.DB) error {
+	var tables int
+	if err := db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'job_ads'`).Scan(&tables); err != nil {
+		return err
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		return err
+	}
+	if tables == 0 {
+		_, err := db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, len(sqliteUpgrades)))
+		return err
+	}
+
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	for ; version < len(sqliteUpgrades); version++ {
+		if err := sqliteApplyUpgrade(db, version); err != nil {
+			return fmt.Errorf("upgrade %d: %w", version+1, err)
+		}
+	}
+	return nil
+}
+
+// sqliteApplyUpgrade runs sqliteUpgrades[version] and records it in one
+// transaction.
+func sqliteApplyUpgrade(db *sql.DB, version int) error {
+	upgrade := sqliteUpgrades[version]
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	exists := false
+	if upgrade.column != "" {
+		err := tx.QueryRow(`SELECT count(*) > 0 FROM pragma_table_info(?) WHERE name = ?`, upgrade.table, upgrade.column).Scan(&exists)
+		if err != nil {
+			return err
+		}
+	}
+	if !exists {
+		if _, err := tx.Exec(upgrade.statement); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// sqliteTimeFormat has a fixed width and is always UTC, so timestamps stored
+// as text compare correctly as strings.
+const sqliteTimeFormat = "2006-01-02T15:04:05.000Z"
+
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeFormat)
+}
+
+func parseSQLiteTime(value string) time.Time {
+	t, _ := time.Parse(sqliteTimeFormat, value)
+	return t
+}
+
+func NewSQLite(path string) (*SQLite, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database: %w", err)
+	}
+	// SQLite allows a single writer; one connection avoids "database is
+	// locked" errors between the fetch and process loops.
+	db.SetMaxOpenConns(1)
+
+	if err := sqliteUpgradeSchema(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to upgrade sqlite schema: %w", err)
+	}
+	if err := sqliteIndexJobs(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to upgrade sqlite schema: %w", err)
+	}
+
+	return &SQLite{db: db}, nil
+}
+
+// sqliteJobIndex returns the published_at and skills columns of a job. The
+// lease order reads them instead of decoding every ready vacancy: skills
+// holds the skillKeys of the job as a JSON array.
+func sqliteJobIndex(job *models.JobAd) (any, string, error) {
+	var published any
+	if t := time.Time(job.PublishedAt); !t.IsZero() {
+		published = sqliteTime(t)
+	}
+	keys, err := json.Marshal(skillKeys(job))
+	return published, string(keys), err
+}
+
+// sqliteIndexJobs fills published_at and skills of the vacancies saved
+// before these columns existed.
+func sqliteIndexJobs(db *sql.DB) error {
+	rows, err := db.Query(`SELECT data FROM job_ads WHERE skills IS NULL`)
+	if err != nil {
+		return err
+	}
+	var jobs []models.JobAd
+	for rows.Next() {
+		var data string
+		var job models.JobAd
+		if err := rows.Scan(&data); err != nil {
+			rows.Close()
+			return err
+		}
+		if err := json.Unmarshal([]byte(data), &job); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to decode job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(jobs) == 0 {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i := range jobs {
+		published, keys, err := sqliteJobIndex(&jobs[i])
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE job_ads SET published_at = ?, skills = ? WHERE id = ?`, published, keys, jobs[i].ID)
+		if err != nil {
+			return fmt.Errorf("failed to index job %s: %w", jobs[i].ID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLite) ExistingJobIDs(ids []string) (map[string]bool, error) {
+	existing := make(map[string]bool)
+	if len(ids) == 0 {
+		return existing, nil
+	}
+
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	query := `
+	SELECT j.id FROM job_ads j JOIN processed_job_ads p ON p.job_id = j.id
+	WHERE j.id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check existing ids: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan id: %w", err)
+		}
+		existing[id] = true
+	}
+
+	return existing, rows.Err()
+}
+
+const sqliteEnqueue = `
+INSERT INTO processed_job_ads (job_id, next_attempt_at, updated_at) VALUES (?1, ?2, ?2)
+ON CONFLICT (job_id) DO NOTHING
+`
+
+const sqliteInsertJob = `
+INSERT INTO job_ads (id, name, description, description_html, data, created_at, published_at, skills)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (id) DO NOTHING
+`
+
+func sqliteSaveJob(tx *sql.Tx, job *models.JobAd) (bool, error) {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode job %s: %w", job.ID, err)
+	}
+	published, keys, err := sqliteJobIndex(job)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode skills of job %s: %w", job.ID, err)
+	}
+
+	result, err := tx.Exec(sqliteInsertJob, job.ID, job.Name, job.Descrtiption, job.DescriptionHTML, data,
+		sqliteTime(time.Now()), published, keys)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (s *SQLite) SaveJobs(jobs []models.JobAd) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var inserted []string
+	for i := range jobs {
+		ok, err := sqliteSaveJob(tx, &jobs[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert job %s: %w", jobs[i].ID, err)
+		}
+		if ok {
+			inserted = append(inserted, jobs[i].ID)
+		}
+		_, err = tx.Exec(sqliteEnqueue, jobs[i].ID, sqliteTime(time.Now()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to queue job %s: %w", jobs[i].ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return inserted, nil
+}
+
+func (s *SQLite) RepairQueue() (RepairReport, error) {
+	var report RepairReport
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback()
+
+	now := sqliteTime(time.Now())
+	result, err := tx.Exec(`
+	INSERT INTO processed_job_ads (job_id, next_attempt_at, updated_at)
+	SELECT id, ?1, ?1 FROM job_ads WHERE id NOT IN (SELECT job_id FROM processed_job_ads)
+	`, now)
+	if err != nil {
+		return report, fmt.Errorf("failed to queue orphaned jobs: %w", err)
+	}
+	queued, _ := result.RowsAffected()
+
+	result, err = tx.Exec(`DELETE FROM processed_job_ads WHERE job_id NOT IN (SELECT id FROM job_ads)`)
+	if err != nil {
+		return report, fmt.Errorf("failed to delete orphaned queue entries: %w", err)
+	}
+	removed, _ := result.RowsAffected()
+
+	if err := tx.Commit(); err != nil {
+		return report, err
+	}
+	return RepairReport{Queued: int(queued), Removed: int(removed)}, nil
+}
+
+func (s *SQLite) UpdateProcessedJob(result *models.ProcessedJob) error {
+	return s.saveResult(result, nil)
+}
+
+func (s *SQLite) CompleteJob(lease Lease, result *models.ProcessedJob) error {
+	return s.saveResult(result, &lease)
+}
+
+// saveResult stores a result, with a lease only while the item is still
+// in progress under it.
+func (s *SQLite) saveResult(result *models.ProcessedJob, lease *Lease) error {
+	validation, err := json.Marshal(result.Validation)
+	if err != nil {
+		return fmt.Errorf("failed to encode validation: %w", err)
+	}
+	params, err := json.Marshal(result.Params)
+	if err != nil {
+		return fmt.Errorf("failed to encode params: %w", err)
+	}
+	valid := result.Validation == nil || result.Validation.Valid
+	processedAt := sqliteTime(result.ProcessedAt)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE processed_job_ads
+	SET cover_letter = ?, thinking = ?, processed = 1, validation = ?, valid = ?,
+		prompt_hash = ?, prompt_version = ?, model = ?, params = ?, processed_at = ?,
+		status = 'done', last_error = NULL, leased_until = NULL, updated_at = ?
+	WHERE job_id = ?
+	`
+	args := []any{result.CoverLetter, result.Thinking, string(validation), valid,
+		result.PromptHash, result.PromptVersion, result.Model, string(params), processedAt,
+		sqliteTime(time.Now()), result.JobID}
+	if lease != nil {
+		query += " AND status = 'in_progress' AND attempts = ?"
+		args = append(args, lease.Attempts)
+	}
+	updated, err := tx.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update processed job: %w", err)
+	}
+	if rows, err := updated.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to update processed job: %w", err)
+	} else if lease != nil && rows == 0 {
+		return fmt.Errorf("failed to update processed job %s: %w", result.JobID, ErrLeaseLost)
+	}
+
+	query = `
+	INSERT INTO job_letters (
+		job_id, cover_letter, thinking, validation, valid,
+		prompt_hash, prompt_version, model, params, created_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err = tx.Exec(query, result.JobID, result.CoverLetter, result.Thinking, string(validation), valid,
+		result.PromptHash, result.PromptVersion, result.Model, string(params), processedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save letter history: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// sqliteOrderClause is orderClause for the SQLite schema. The fit order
+// counts the profile keys bound to ?2 as a JSON array in the skills column.
+// SQLite sorts NULL first, so a descending published_at puts it last.
+func sqliteOrderClause(order string) (string, error) {
+	switch order {
+	case "", OrderQueue:
+		return "p.next_attempt_at, p.job_id", nil
+	case OrderNewest:
+		return "j.published_at DESC, p.job_id", nil
+	case OrderFit:
+		fit := "(SELECT count(*) FROM json_each(j.skills) s WHERE s.value IN (SELECT value FROM json_each(?2)))"
+		return fit + " DESC, j.published_at DESC, p.job_id", nil
+	}
+	return "", CheckOrder(order)
+}
+
+// LeaseJobs leases the ready items in the requested order. A single file
+// has a single writer, so the transaction alone keeps items from being
+// leased twice.
+func (s *SQLite) LeaseJobs(opts LeaseOptions, policy RetryPolicy) ([]Lease, error) {
+	orderBy, err := sqliteOrderClause(opts.Order)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := json.Marshal(append([]string{}, skills.Default().ProfileKeys(opts.ProfileSkills)...))
+	if err != nil {
+		return nil, err
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	now := time.Now()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	UPDATE processed_job_ads
+	SET status = 'dead', leased_until = NULL, updated_at = ?1,
+		last_error = 'lease expired after ' || attempts || ' attempts'
+	WHERE status = 'in_progress' AND leased_until < ?1 AND attempts >= ?2
+	`, sqliteTime(now), policy.MaxAttempts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expire leases: %w", err)
+	}
+
+	rows, err := tx.Query(`
+	SELECT j.data, j.description_html
+	FROM processed_job_ads p JOIN job_ads j ON j.id = p.job_id
+	WHERE (p.status IN ('pending', 'failed') AND p.next_attempt_at <= ?1)
+		OR (p.status = 'in_progress' AND p.leased_until < ?1)
+	ORDER BY `+orderBy+`
+	LIMIT ?3
+	`, sqliteTime(now), string(profile), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ready jobs: %w", err)
+	}
+	var ready []models.JobAd
+	for rows.Next() {
+		var data string
+		var descriptionHTML sql.NullString
+		if err := rows.Scan(&data, &descriptionHTML); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan ready job: %w", err)
+		}
+		var job models.JobAd
+		if err := json.Unmarshal([]byte(data), &job); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to decode job: %w", err)
+		}
+		job.DescriptionHTML = descriptionHTML.String
+		ready = append(ready, job)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	leases := make([]Lease, 0, len(ready))
+	for _, job := range ready {
+		var attempts int
+		err := tx.QueryRow(`
+		UPDATE processed_job_ads
+		SET status = 'in_progress', attempts = attempts + 1, leased_until = ?2, updated_at = ?1
+		WHERE job_id = ?3
+		RETURNING attempts
+		`, sqliteTime(now), sqliteTime(now.Add(policy.LeaseTimeout)), job.ID).Scan(&attempts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to lease job %s: %w", job.ID, err)
+		}
+		leases = append(leases, Lease{Job: job, Attempts: attempts})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return leases, nil
+}
+
+func (s *SQLite) FailJob(lease Lease, cause error, policy RetryPolicy) (string, error) {
+	now := time.Now()
+	status, next := policy.failure(lease.Attempts, now)
+
+	updated, err := s.db.Exec(`
+	UPDATE processed_job_ads
+	SET status = ?, last_error = ?, next_attempt_at = ?, leased_until = NULL, updated_at = ?
+	WHERE job_id = ? AND status = 'in_progress' AND attempts = ?
+	`, status, cause.Error(), sqliteTime(next), sqliteTime(now), lease.Job.ID, lease.Attempts)
+	if err != nil {
+		return "", fmt.Errorf("failed to record failure of job %s: %w", lease.Job.ID, err)
+	}
+	if rows, err := updated.RowsAffected(); err != nil {
+		return "", fmt.Errorf("failed to record failure of job %s: %w", lease.Job.ID, err)
+	} else if rows == 0 {
+		return "", fmt.Errorf("failed to record failure of job %s: %w", lease.Job.ID, ErrLeaseLost)
+	}
+	return status, nil
+}
+
+func (s *SQLite) DeadJobs() ([]QueueEntry, error) {
+	rows, err := s.db.Query(`
+	SELECT p.job_id, j.name, p.status, p.attempts, coalesce(p.last_error, ''), p.next_attempt_at, p.updated_at
+	FROM processed_job_ads p JOIN job_ads j ON j.id = p.job_id
+	WHERE p.status = 'dead'
+	ORDER BY p.updated_at DESC, p.job_id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load dead jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []QueueEntry
+	for rows.Next() {
+		var entry QueueEntry
+		var nextAttemptAt, updatedAt string
+		if err := rows.Scan(&entry.JobID, &entry.Name, &entry.Status, &entry.Attempts,
+			&entry.LastError, &nextAttemptAt, &updatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan dead job: %w", err)
+		}
+		entry.NextAttemptAt = parseSQLiteTime(nextAttemptAt)
+		entry.UpdatedAt = parseSQLiteTime(updatedAt)
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+func (s *SQLite) RequeueJobs(ids []string) (int, error) {
+	now := sqliteTime(time.Now())
+	query := `
+	UPDATE processed_job_ads
+	SET status = 'pending', attempts = 0, next_attempt_at = ?1, leased_until = NULL, updated_at = ?1
+	WHERE status = 'dead'
+	`
+	args := []any{now}
+	if len(ids) > 0 {
+		query += ` AND job_id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)`
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+
+	result, err := s.db.Exec(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to requeue jobs: %w", err)
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+func (s *SQLite) QueueStats() (map[string]int, error) {
+	rows, err := s.db.Query(`SELECT status, count(*) FROM processed_job_ads GROUP BY status`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load queue stats: %w", err)
+	}
+	defer rows.Close()
+
+	stats := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan queue stats: %w", err)
+		}
+		stats[status] = count
+	}
+
+	return stats, rows.Err()
+}
+
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
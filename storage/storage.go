@@ -2,33 +2,68 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"hh_bot/embeddings"
 	"hh_bot/models"
-	"log"
+	"hh_bot/skills"
+	"log/slog"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func GetJobID(dbpool *pgxpool.Pool, id string) (bool, error) {
+// Postgres is the main Repository backend. Features beyond the Repository
+// interface use the pool directly through the package-level functions.
+type Postgres struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgres(dbpool *pgxpool.Pool) *Postgres {
+	return &Postgres{pool: dbpool}
+}
+
+func (p *Postgres) Pool() *pgxpool.Pool {
+	return p.pool
+}
+
+func (p *Postgres) Close() error {
+	p.pool.Close()
+	return nil
+}
+
+// ExistingJobIDs checks a whole page of ids in one round trip. A vacancy
+// only counts as existing once it is queued, so a half-saved one is fetched
+// and queued again.
+func (p *Postgres) ExistingJobIDs(ids []string) (map[string]bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+
 	query := `
-	SELECT EXISTS (SELECT id FROM job_ads WHERE id=$1)
+	SELECT j.id FROM job_ads j JOIN processed_job_ads p ON p.job_id = j.id
+	WHERE j.id = ANY($1)
 	`
-	var exists bool
-	err := dbpool.QueryRow(ctx, query, id).Scan(&exists)
+	rows, err := p.pool.Query(ctx, query, ids)
 	if err != nil {
-		return false, fmt.Errorf("Failed to check ID existence: %w", err)
+		return nil, fmt.Errorf("failed to check existing ids: %w", err)
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan id: %w", err)
+		}
+		existing[id] = true
 	}
 
-	return exists, nil
+	return existing, rows.Err()
 }
 
-func SaveJobToDB(dbpool *pgxpool.Pool, job *models.JobAd) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	query := `
+const insertJobQuery = `
 	INSERT INTO job_ads (
 		id, accept_handicapped, accept_incomplete_resumes, accept_kids, accept_temporary,
 		allow_messages, alternate_url, apply_alternate_url, approved, archived, area,
@@ -38,14 +73,49 @@ func SaveJobToDB(dbpool *pgxpool.Pool, job *models.JobAd) error {
 		negotiations_url, night_shifts, premium, professional_roles, published_at,
 		relations, response_letter_required, response_url, salary, suitable_resumes_url,
 		test, type, video_vacancy, work_format, work_schedule_by_days, working_hours,
-		address ) VALUES (
+		address, description_html ) VALUES (
 		$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17,
 		$18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29, $30, $31, $32,
-		$33, $34, $35, $36, $37, $38, $39, $40, $41, $42, $43, $44, $45 )
-		ON CONFLICT (id) DO NOTHING;
+		$33, $34, $35, $36, $37, $38, $39, $40, $41, $42, $43, $44, $45, $46 )
+		ON CONFLICT (id) DO NOTHING
 	`
 
-	_, err := dbpool.Exec(ctx, query,
+// jobColumns selects a whole job_ads row aliased as j, in the order
+// scanJobAd expects. Nullable text and JSON columns are coalesced so they
+// scan the same way whether or not a value was stored.
+const jobColumns = `
+	j.id, j.accept_handicapped, j.accept_incomplete_resumes, j.accept_kids, j.accept_temporary,
+	j.allow_messages, coalesce(j.alternate_url, ''), coalesce(j.apply_alternate_url, ''), j.approved, j.archived,
+	coalesce(j.area, 'null'), coalesce(j.billing_type, 'null'), coalesce(j.code, ''), j.contacts, j.department,
+	coalesce(j.description, ''), j.driver_license_types, coalesce(j.employer, 'null'),
+	coalesce(j.employment_form, 'null'), coalesce(j.experience, 'null'), j.fly_in_fly_out_duration, j.has_test,
+	j.initial_created_at, coalesce(j.insider_interview, 'null'), j.internship, j.key_skills, j.languages, j.name,
+	coalesce(j.negotiations_url, ''), j.night_shifts, j.premium, j.professional_roles, j.published_at,
+	j.relations, j.response_letter_required, coalesce(j.response_url, ''), coalesce(j.salary, 'null'),
+	coalesce(j.suitable_resumes_url, ''),
+	coalesce(j.test, 'null'), coalesce(j.type, 'null'), coalesce(j.video_vacancy, 'null'), j.work_format,
+	j.work_schedule_by_days, j.working_hours, coalesce(j.address, 'null'), coalesce(j.description_html, '')
+`
+
+// scanJobAd scans a row selected with jobColumns, followed by extra columns.
+func scanJobAd(row pgx.Row, job *models.JobAd, extra ...any) error {
+	dest := []any{
+		&job.ID, &job.AcceptHandicapped, &job.AcceptIncompleteResumes, &job.AcceptKids, &job.AcceptTemporary,
+		&job.AllowMessages, &job.AlternateURL, &job.ApplyAlternateURL, &job.Approved, &job.Archived,
+		&job.Area, &job.BillingType, &job.Code, &job.Contacts, &job.Department,
+		&job.Descrtiption, &job.DriverLicenseTypes, &job.Employer,
+		&job.EmploymentForm, &job.Experience, &job.FlyInFlyOutDuration, &job.HasTest,
+		&job.InitialCreatedAt, &job.InsiderInterview, &job.Internship, &job.KeySkills, &job.Languages, &job.Name,
+		&job.NegotiationsUrl, &job.NightShifts, &job.Premium, &job.ProfessionalRoles, &job.PublishedAt,
+		&job.Relations, &job.ResponseLetterRequired, &job.ResponseURL, &job.Salary, &job.SuitableResumesURL,
+		&job.Test, &job.Type, &job.VideoVacancy, &job.WorkFormat,
+		&job.WorkScheduleByDays, &job.WorkingHours, &job.Address, &job.DescriptionHTML,
+	}
+	return row.Scan(append(dest, extra...)...)
+}
+
+func jobArgs(job *models.JobAd) []any {
+	return []any{
 		job.ID, job.AcceptHandicapped, job.AcceptIncompleteResumes, job.AcceptKids, job.AcceptTemporary,
 		job.AllowMessages, job.AlternateURL, job.ApplyAlternateURL, job.Approved, job.Archived, job.Area,
 		job.BillingType, job.Code, job.Contacts, job.Department, job.Descrtiption, job.DriverLicenseTypes,
@@ -54,55 +124,591 @@ func SaveJobToDB(dbpool *pgxpool.Pool, job *models.JobAd) error {
 		job.NegotiationsUrl, job.NightShifts, job.Premium, job.ProfessionalRoles, job.PublishedAt,
 		job.Relations, job.ResponseLetterRequired, job.ResponseURL, job.Salary, job.SuitableResumesURL,
 		job.Test, job.Type, job.VideoVacancy, job.WorkFormat, job.WorkScheduleByDays, job.WorkingHours,
-		job.Address,
-	)
+		job.Address, job.DescriptionHTML,
+	}
+}
 
-	return err
+// SaveJobs inserts vacancies and their queue entries in one transaction with
+// a single batch, and returns the ids that were actually inserted. Ids that
+// already exist are left untouched.
+func (p *Postgres) SaveJobs(jobs []models.JobAd) ([]string, error) {
+	if len(jobs) == 0 {
+		return nil, nil
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var inserted []string
+	err := pgx.BeginFunc(ctx, p.pool, func(tx pgx.Tx) error {
+		batch := &pgx.Batch{}
+		for i := range jobs {
+			batch.Queue(insertJobQuery, jobArgs(&jobs[i])...)
+			batch.Queue(`INSERT INTO processed_job_ads (job_id) VALUES ($1) ON CONFLICT (job_id) DO NOTHING`, jobs[i].ID)
+		}
+
+		results := tx.SendBatch(ctx, batch)
+		for i := range jobs {
+			tag, err := results.Exec()
+			if err != nil {
+				results.Close()
+				return fmt.Errorf("failed to insert job %s: %w", jobs[i].ID, err)
+			}
+			if tag.RowsAffected() > 0 {
+				inserted = append(inserted, jobs[i].ID)
+			}
+			if _, err := results.Exec(); err != nil {
+				results.Close()
+				return fmt.Errorf("failed to queue job %s: %w", jobs[i].ID, err)
+			}
+		}
+
+		return results.Close()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Debug("saved jobs", "jobs", len(jobs), "inserted", len(inserted), "latency", time.Since(start))
+	return inserted, nil
+}
+
+// RepairQueue queues vacancies that have no queue entry and deletes queue
+// entries whose vacancy is gone.
+func (p *Postgres) RepairQueue() (RepairReport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var report RepairReport
+	err := pgx.BeginFunc(ctx, p.pool, func(tx pgx.Tx) error {
+		query := `
+		INSERT INTO processed_job_ads (job_id)
+		SELECT id FROM job_ads WHERE id NOT IN (SELECT job_id FROM processed_job_ads)
+		ON CONFLICT (job_id) DO NOTHING
+		`
+		tag, err := tx.Exec(ctx, query)
+		if err != nil {
+			return fmt.Errorf("failed to queue orphaned jobs: %w", err)
+		}
+		report.Queued = int(tag.RowsAffected())
+
+		query = `
+		DELETE FROM processed_job_ads WHERE job_id NOT IN (SELECT id FROM job_ads)
+		`
+		tag, err = tx.Exec(ctx, query)
+		if err != nil {
+			return fmt.Errorf("failed to delete orphaned queue entries: %w", err)
+		}
+		report.Removed = int(tag.RowsAffected())
+
+		return nil
+	})
+
+	return report, err
+}
+
+// UpdateProcessedJob stores the latest result for a vacancy and appends it
+// to job_letters, which keeps every generated letter for comparison.
+func (p *Postgres) UpdateProcessedJob(result *models.ProcessedJob) error {
+	return p.saveResult(result, nil)
+}
+
+func (p *Postgres) CompleteJob(lease Lease, result *models.ProcessedJob) error {
+	return p.saveResult(result, &lease)
+}
+
+// saveResult stores a result, with a lease only while the item is still
+// in progress under it.
+func (p *Postgres) saveResult(result *models.ProcessedJob, lease *Lease) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	valid := result.Validation == nil || result.Validation.Valid
+
+	return pgx.BeginFunc(ctx, p.pool, func(tx pgx.Tx) error {
+		query := `
+		UPDATE processed_job_ads
+		SET cover_letter = $1, thinking = $2, processed = $3, validation = $4, valid = $5,
+			prompt_hash = $6, prompt_version = $7, model = $8, params = $9, processed_at = $10,
+			status = 'done', last_error = NULL, leased_until = NULL, updated_at = now()
+		WHERE job_id = $11
+		`
+		args := []any{result.CoverLetter, result.Thinking, true, result.Validation, valid,
+			result.PromptHash, result.PromptVersion, result.Model, result.Params, result.ProcessedAt, result.JobID}
+		if lease != nil {
+			query += " AND status = 'in_progress' AND attempts = $12"
+			args = append(args, lease.Attempts)
+		}
+		tag, err := tx.Exec(ctx, query, args...)
+		if err != nil {
+			return fmt.Errorf("failed to update processed job: %w", err)
+		}
+		if lease != nil && tag.RowsAffected() == 0 {
+			return fmt.Errorf("failed to update processed job %s: %w", result.JobID, ErrLeaseLost)
+		}
+
+		query = `
+		INSERT INTO job_letters (
+			job_id, cover_letter, thinking, validation, valid,
+			prompt_hash, prompt_version, model, params, created_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		`
+		_, err = tx.Exec(ctx, query, result.JobID, result.CoverLetter, result.Thinking, result.Validation, valid,
+			result.PromptHash, result.PromptVersion, result.Model, result.Params, result.ProcessedAt)
+		if err != nil {
+			return fmt.Errorf("failed to save letter history: %w", err)
+		}
+
+		return nil
+	})
+}
+
+type ReprocessFilter struct {
+	PromptHash    string
+	PromptVersion string
+	Model         string
+	Since         time.Time
+	Until         time.Time
+	// Status is one of "processed", "unprocessed", "valid" or "invalid".
+	Status string
+}
+
+func (f ReprocessFilter) IsEmpty() bool {
+	return f == ReprocessFilter{}
 }
 
-func SaveUnprocessedJobToDB(dbpool *pgxpool.Pool, id string) error {
+// LoadJobIDsForReprocessing returns the ids of the jobs matching filter,
+// ordered and limited by opts the same way LeaseJobs is, and like it skips
+// near-duplicates. Callers load the jobs themselves in pages with
+// LoadJobsByIDs.
+func LoadJobIDsForReprocessing(dbpool *pgxpool.Pool, filter ReprocessFilter, opts LeaseOptions) ([]string, error) {
+	query, args, err := reprocessQuery(filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := dbpool.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load jobs for reprocessing: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan job id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// reprocessQuery builds the query of LoadJobIDsForReprocessing.
+func reprocessQuery(filter ReprocessFilter, opts LeaseOptions) (string, []any, error) {
+	conditions := []string{notDuplicate}
+	var args []any
+
+	var orderBy string
+	var err error
+	if opts.Order == OrderFit {
+		args = append(args, skills.Default().ProfileKeys(opts.ProfileSkills))
+		orderBy, err = orderClause(opts.Order, "$1")
+	} else {
+		orderBy, err = orderClause(opts.Order, "")
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	addCondition := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.PromptHash != "" {
+		addCondition("p.prompt_hash = $%d", filter.PromptHash)
+	}
+	if filter.PromptVersion != "" {
+		addCondition("p.prompt_version = $%d", filter.PromptVersion)
+	}
+	if filter.Model != "" {
+		addCondition("p.model = $%d", filter.Model)
+	}
+	if !filter.Since.IsZero() {
+		addCondition("p.processed_at >= $%d", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		addCondition("p.processed_at < $%d", filter.Until)
+	}
+
+	switch filter.Status {
+	case "":
+	case "processed":
+		conditions = append(conditions, "p.processed = true")
+	case "unprocessed":
+		conditions = append(conditions, "p.processed = false")
+	case "valid":
+		conditions = append(conditions, "p.processed = true AND p.valid = true")
+	case "invalid":
+		conditions = append(conditions, "p.processed = true AND p.valid = false")
+	default:
+		return "", nil, fmt.Errorf("unknown status %q", filter.Status)
+	}
+
+	query := `SELECT p.job_id FROM job_ads j JOIN processed_job_ads p ON p.job_id = j.id WHERE ` + strings.Join(conditions, " AND ")
+	query += " ORDER BY " + orderBy
+	if opts.Limit > 0 {
+		args = append(args, opts.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	return query, args, nil
+}
+
+func ReconvertDescriptions(dbpool *pgxpool.Pool, convert func(string) string) (int, error) {
 	query := `
-	INSERT INTO processed_job_ads (job_id) VALUES ($1) ON CONFLICT (job_id) DO NOTHING
+	SELECT id, description_html FROM job_ads WHERE description_html IS NOT NULL
 	`
-	_, err := dbpool.Exec(context.Background(), query, id)
+	rows, err := dbpool.Query(context.Background(), query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load job descriptions: %w", err)
+	}
 
-	return err
+	descriptions := make(map[string]string)
+	for rows.Next() {
+		var id, descriptionHTML string
+		if err := rows.Scan(&id, &descriptionHTML); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan job description: %w", err)
+		}
+		descriptions[id] = convert(descriptionHTML)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for id, description := range descriptions {
+		_, err := dbpool.Exec(context.Background(), `UPDATE job_ads SET description = $1 WHERE id = $2`, description, id)
+		if err != nil {
+			return updated, fmt.Errorf("failed to update description of job %s: %w", id, err)
+		}
+		updated++
+	}
+
+	return updated, nil
+}
+
+// LoadJobsByIDs returns the stored jobs among ids in the order of ids.
+func LoadJobsByIDs(dbpool *pgxpool.Pool, ids []string) ([]models.JobAd, error) {
+	query := `SELECT ` + jobColumns + ` FROM job_ads j WHERE j.id = ANY($1) ORDER BY array_position($1, j.id)`
+	rows, err := dbpool.Query(context.Background(), query, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobAds []models.JobAd
+	for rows.Next() {
+		var job models.JobAd
+		if err := scanJobAd(rows, &job); err != nil {
+			return nil, fmt.Errorf("failed to scan job: %w", err)
+		}
+		jobAds = append(jobAds, job)
+	}
+
+	return jobAds, rows.Err()
+}
+
+func LoadFingerprints(dbpool *pgxpool.Pool) ([]models.Fingerprint, error) {
+	query := `
+	SELECT job_id, simhash, cluster_id, canonical FROM job_fingerprints ORDER BY job_id
+	`
+	rows, err := dbpool.Query(context.Background(), query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load fingerprints: %w", err)
+	}
+	defer rows.Close()
+
+	var fingerprints []models.Fingerprint
+	for rows.Next() {
+		var fp models.Fingerprint
+		var simhash int64
+		if err := rows.Scan(&fp.JobID, &simhash, &fp.ClusterID, &fp.Canonical); err != nil {
+			return nil, fmt.Errorf("failed to scan fingerprint: %w", err)
+		}
+		fp.SimHash = uint64(simhash)
+		fingerprints = append(fingerprints, fp)
+	}
+
+	return fingerprints, rows.Err()
 }
 
-func UpdateProcessedJob(dbpool *pgxpool.Pool, id, cover_letter, thinking string) error {
+func SaveFingerprint(dbpool *pgxpool.Pool, fp models.Fingerprint) error {
 	query := `
-	UPDATE processed_job_ads 
-	SET cover_letter = $1, thinking = $2, processed = $3 
-	WHERE job_id = $4
+	INSERT INTO job_fingerprints (job_id, simhash, cluster_id, canonical) VALUES ($1, $2, $3, $4)
+	ON CONFLICT (job_id) DO UPDATE SET simhash = $2, cluster_id = $3, canonical = $4
 	`
-	_, err := dbpool.Exec(context.Background(), query, cover_letter, thinking, true, id)
+	_, err := dbpool.Exec(context.Background(), query, fp.JobID, int64(fp.SimHash), fp.ClusterID, fp.Canonical)
 
 	return err
 }
 
-func LoadUnprocessedJobs(dbpool *pgxpool.Pool) ([]models.JobAd, error) {
+// LoadJobsMissingExtraction returns which of ids have no skills and which
+// have no fingerprint stored, so that vacancies saved only in part get them
+// on the next ingest. A vacancy without any known skill counts as missing
+// its skills.
+func LoadJobsMissingExtraction(dbpool *pgxpool.Pool, ids []string) (map[string]bool, map[string]bool, error) {
 	query := `
-	SELECT id, description from job_ads WHERE id IN (SELECT job_id FROM processed_job_ads WHERE processed=false)
+	SELECT id,
+		NOT EXISTS (SELECT 1 FROM job_skills s WHERE s.job_id = j.id),
+		NOT EXISTS (SELECT 1 FROM job_fingerprints f WHERE f.job_id = j.id)
+	FROM job_ads j
+	WHERE id = ANY($1)
+	`
+	rows, err := dbpool.Query(context.Background(), query, ids)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load jobs missing skills or fingerprints: %w", err)
+	}
+	defer rows.Close()
+
+	noSkills := make(map[string]bool)
+	noFingerprint := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		var skills, fingerprint bool
+		if err := rows.Scan(&id, &skills, &fingerprint); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan job: %w", err)
+		}
+		if skills {
+			noSkills[id] = true
+		}
+		if fingerprint {
+			noFingerprint[id] = true
+		}
+	}
+
+	return noSkills, noFingerprint, rows.Err()
+}
+
+// LoadJobsWithoutFingerprint returns the fields needed for fingerprinting,
+// oldest first so that the earliest posting becomes canonical.
+func LoadJobsWithoutFingerprint(dbpool *pgxpool.Pool) ([]models.JobAd, error) {
+	query := `
+	SELECT id, name, employer->>'name', description FROM job_ads
+	WHERE id NOT IN (SELECT job_id FROM job_fingerprints)
+	ORDER BY published_at, id
 	`
 	rows, err := dbpool.Query(context.Background(), query)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load jobs from data base: %w", err)
+		return nil, fmt.Errorf("failed to load jobs without fingerprint: %w", err)
 	}
+	defer rows.Close()
 
 	var jobAds []models.JobAd
+	for rows.Next() {
+		var job models.JobAd
+		var employer *string
+		if err := rows.Scan(&job.ID, &job.Name, &employer, &job.Descrtiption); err != nil {
+			return nil, fmt.Errorf("failed to scan job: %w", err)
+		}
+		if employer != nil {
+			job.Employer.Name = *employer
+		}
+		jobAds = append(jobAds, job)
+	}
 
+	return jobAds, rows.Err()
+}
+
+func LoadJobsWithoutEmbedding(dbpool *pgxpool.Pool, model string) ([]models.JobAd, error) {
+	query := `
+	SELECT ` + jobColumns + ` FROM job_ads j
+	WHERE j.id NOT IN (SELECT job_id FROM job_embeddings WHERE model = $1)
+	`
+	rows, err := dbpool.Query(context.Background(), query, model)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load jobs without embedding: %w", err)
+	}
+	defer rows.Close()
+
+	var jobAds []models.JobAd
 	for rows.Next() {
 		var job models.JobAd
-		err := rows.Scan(&job.ID, &job.Descrtiption)
-		if err != nil {
-			log.Printf("failed to retrieve a job: %v", err)
+		if err := scanJobAd(rows, &job); err != nil {
+			return nil, fmt.Errorf("failed to scan job: %w", err)
 		}
 		jobAds = append(jobAds, job)
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, err
+	return jobAds, rows.Err()
+}
+
+// SaveEmbedding stores the vector as a real[] and, when pgvector is enabled,
+// also in the embedding_vec column used for in-database search. Every model
+// keeps its own embedding of a job.
+func SaveEmbedding(dbpool *pgxpool.Pool, jobID, model string, vector []float32, pgvector bool) error {
+	query := `
+	INSERT INTO job_embeddings (job_id, model, embedding) VALUES ($1, $2, $3)
+	ON CONFLICT (job_id, model) DO UPDATE SET embedding = $3
+	`
+	if pgvector {
+		query = `
+		INSERT INTO job_embeddings (job_id, model, embedding, embedding_vec) VALUES ($1, $2, $3, $3::real[]::vector)
+		ON CONFLICT (job_id, model) DO UPDATE SET embedding = $3, embedding_vec = $3::real[]::vector
+		`
+	}
+	_, err := dbpool.Exec(context.Background(), query, jobID, model, vector)
+
+	return err
+}
+
+func LoadEmbedding(dbpool *pgxpool.Pool, jobID, model string) ([]float32, error) {
+	query := `
+	SELECT embedding FROM job_embeddings WHERE job_id = $1 AND model = $2
+	`
+	var vector []float32
+	err := dbpool.QueryRow(context.Background(), query, jobID, model).Scan(&vector)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load embedding of job %s: %w", jobID, err)
+	}
+
+	return vector, nil
+}
+
+func LoadEmbeddings(dbpool *pgxpool.Pool, model string) (map[string][]float32, error) {
+	query := `
+	SELECT job_id, embedding FROM job_embeddings WHERE model = $1
+	`
+	rows, err := dbpool.Query(context.Background(), query, model)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load embeddings: %w", err)
+	}
+	defer rows.Close()
+
+	vectors := make(map[string][]float32)
+	for rows.Next() {
+		var id string
+		var vector []float32
+		if err := rows.Scan(&id, &vector); err != nil {
+			return nil, fmt.Errorf("failed to scan embedding: %w", err)
+		}
+		vectors[id] = vector
+	}
+
+	return vectors, rows.Err()
+}
+
+// SearchSimilar ranks stored vacancies by cosine similarity using pgvector.
+func SearchSimilar(dbpool *pgxpool.Pool, model string, vector []float32, limit int) ([]embeddings.Match, error) {
+	query := `
+	SELECT job_id, 1 - (embedding_vec <=> $2::real[]::vector) AS score FROM job_embeddings
+	WHERE model = $1 AND embedding_vec IS NOT NULL
+	ORDER BY embedding_vec <=> $2::real[]::vector
+	LIMIT $3
+	`
+	rows, err := dbpool.Query(context.Background(), query, model, vector, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search similar jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var matches []embeddings.Match
+	for rows.Next() {
+		var match embeddings.Match
+		if err := rows.Scan(&match.JobID, &match.Score); err != nil {
+			return nil, fmt.Errorf("failed to scan match: %w", err)
+		}
+		matches = append(matches, match)
+	}
+
+	return matches, rows.Err()
+}
+
+// SaveJobSkills replaces the normalized skills stored for a vacancy.
+func SaveJobSkills(dbpool *pgxpool.Pool, jobID string, jobSkills []models.JobSkill) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return pgx.BeginFunc(ctx, dbpool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DELETE FROM job_skills WHERE job_id = $1`, jobID); err != nil {
+			return fmt.Errorf("failed to delete skills of job %s: %w", jobID, err)
+		}
+
+		batch := &pgx.Batch{}
+		for _, skill := range jobSkills {
+			batch.Queue(`INSERT INTO job_skills (job_id, skill, category, source) VALUES ($1, $2, $3, $4)`,
+				jobID, skill.Skill, skill.Category, skill.Source)
+		}
+
+		return tx.SendBatch(ctx, batch).Close()
+	})
+}
+
+func LoadJobsForSkills(dbpool *pgxpool.Pool) ([]models.JobAd, error) {
+	return loadJobsForSkills(dbpool, `SELECT id, name, description, key_skills FROM job_ads`)
+}
+
+// LoadJobsWithoutSkills returns the fields needed for skill extraction of
+// the vacancies that have no skills stored.
+func LoadJobsWithoutSkills(dbpool *pgxpool.Pool) ([]models.JobAd, error) {
+	query := `
+	SELECT id, name, description, key_skills FROM job_ads j
+	WHERE NOT EXISTS (SELECT 1 FROM job_skills s WHERE s.job_id = j.id)
+	`
+	return loadJobsForSkills(dbpool, query)
+}
+
+func loadJobsForSkills(dbpool *pgxpool.Pool, query string) ([]models.JobAd, error) {
+	rows, err := dbpool.Query(context.Background(), query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobAds []models.JobAd
+	for rows.Next() {
+		var job models.JobAd
+		if err := rows.Scan(&job.ID, &job.Name, &job.Descrtiption, &job.KeySkills); err != nil {
+			return nil, fmt.Errorf("failed to scan job: %w", err)
+		}
+		jobAds = append(jobAds, job)
+	}
+
+	return jobAds, rows.Err()
+}
+
+type SkillCount struct {
+	Skill    string
+	Category string
+	Jobs     int
+}
+
+func TopSkills(dbpool *pgxpool.Pool, limit int) ([]SkillCount, error) {
+	query := `
+	SELECT skill, category, count(*) AS jobs FROM job_skills
+	GROUP BY skill, category ORDER BY jobs DESC, skill LIMIT $1
+	`
+	rows, err := dbpool.Query(context.Background(), query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count skills: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []SkillCount
+	for rows.Next() {
+		var count SkillCount
+		if err := rows.Scan(&count.Skill, &count.Category, &count.Jobs); err != nil {
+			return nil, fmt.Errorf("failed to scan skill count: %w", err)
+		}
+		counts = append(counts, count)
 	}
 
-	return jobAds, nil
+	return counts, rows.Err()
 }
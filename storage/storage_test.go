@@ -0,0 +1,65 @@
+package storage_test
+
+import (
+	"hh_bot/storage"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReprocessQuery(t *testing.T) {
+	since := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		filter storage.ReprocessFilter
+		opts   storage.LeaseOptions
+		where  string
+		order  string
+		args   []any
+	}{
+		{
+			name:  "everything",
+			where: " WHERE p.job_id NOT IN (SELECT job_id FROM job_fingerprints WHERE canonical = false) ORDER BY",
+			order: " ORDER BY p.next_attempt_at, p.job_id",
+		},
+		{
+			name:   "prompt and model",
+			filter: storage.ReprocessFilter{PromptHash: "88282e5c2b6b", Model: "llama3", Status: "invalid"},
+			opts:   storage.LeaseOptions{Limit: 10, Order: storage.OrderNewest},
+			where:  " AND p.prompt_hash = $1 AND p.model = $2 AND p.processed = true AND p.valid = false",
+			order:  " ORDER BY j.published_at DESC NULLS LAST, p.job_id LIMIT $3",
+			args:   []any{"88282e5c2b6b", "llama3", 10},
+		},
+		{
+			name:   "dates after the fit profile",
+			filter: storage.ReprocessFilter{PromptVersion: "v2", Since: since, Until: since.AddDate(0, 0, 1), Status: "processed"},
+			opts:   storage.LeaseOptions{Order: storage.OrderFit, ProfileSkills: []string{"Go"}},
+			where:  " AND p.prompt_version = $2 AND p.processed_at >= $3 AND p.processed_at < $4 AND p.processed = true",
+			order:  "= ANY($1)) DESC, j.published_at DESC NULLS LAST, p.job_id",
+			args:   []any{[]string{"go"}, "v2", since, since.AddDate(0, 0, 1)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args, err := storage.ReprocessQuery(tt.filter, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(query, tt.where) || !strings.HasSuffix(query, tt.order) {
+				t.Errorf("query %s\nwant conditions %q and order %q", query, tt.where, tt.order)
+			}
+			if len(args) != len(tt.args) || (len(args) > 0 && !reflect.DeepEqual(args, tt.args)) {
+				t.Errorf("args = %#v, want %#v", args, tt.args)
+			}
+		})
+	}
+
+	if _, _, err := storage.ReprocessQuery(storage.ReprocessFilter{Status: "maybe"}, storage.LeaseOptions{}); err == nil {
+		t.Errorf("an unknown status must be an error")
+	}
+	if !(storage.ReprocessFilter{}).IsEmpty() || (storage.ReprocessFilter{Model: "llama3"}).IsEmpty() {
+		t.Errorf("IsEmpty is wrong")
+	}
+}
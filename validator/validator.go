@@ -0,0 +1,220 @@
+package validator
+
+import (
+	"fmt"
+	"hh_bot/models"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type Rule interface {
+	Name() string
+	Check(letter string, job *models.JobAd) []string
+}
+
+type Validator struct {
+	Rules []Rule
+}
+
+type Options struct {
+	MinLength        int
+	MaxLength        int
+	BannedPhrases    []string
+	RequiredMentions []string
+}
+
+var DefaultBannedPhrases = []string{
+	"here is your",
+	"here's your",
+	"here is a cover letter",
+	"here is the cover letter",
+	"as an ai",
+	"вот ваш",
+	"вот сопроводительное",
+	"как ии",
+	"как языковая модель",
+}
+
+func New(rules ...Rule) *Validator {
+	return &Validator{Rules: rules}
+}
+
+func NewDefault(opts Options) *Validator {
+	minLength, maxLength := opts.MinLength, opts.MaxLength
+	if minLength <= 0 {
+		minLength = 300
+	}
+	if maxLength <= 0 {
+		maxLength = 3000
+	}
+
+	rules := []Rule{
+		LengthRule{Min: minLength, Max: maxLength},
+		LanguageRule{},
+		PlaceholderRule{},
+		BannedPhrasesRule{Phrases: append(append([]string{}, DefaultBannedPhrases...), opts.BannedPhrases...)},
+	}
+	if len(opts.RequiredMentions) > 0 {
+		rules = append(rules, RequiredMentionsRule{Mentions: opts.RequiredMentions})
+	}
+
+	return New(rules...)
+}
+
+func (v *Validator) Validate(letter string, job *models.JobAd) *models.LetterValidation {
+	result := &models.LetterValidation{Violations: []models.Violation{}}
+	failed := 0
+
+	for _, rule := range v.Rules {
+		messages := rule.Check(letter, job)
+		if len(messages) > 0 {
+			failed++
+		}
+		for _, message := range messages {
+			result.Violations = append(result.Violations, models.Violation{Rule: rule.Name(), Message: message})
+		}
+	}
+
+	result.Valid = failed == 0
+	result.Score = 1
+	if len(v.Rules) > 0 {
+		result.Score = float64(len(v.Rules)-failed) / float64(len(v.Rules))
+	}
+
+	return result
+}
+
+type LengthRule struct {
+	Min int
+	Max int
+}
+
+func (LengthRule) Name() string { return "length" }
+
+func (r LengthRule) Check(letter string, job *models.JobAd) []string {
+	length := utf8.RuneCountInString(strings.TrimSpace(letter))
+	if r.Min > 0 && length < r.Min {
+		return []string{fmt.Sprintf("letter is %d characters long, minimum is %d", length, r.Min)}
+	}
+	if r.Max > 0 && length > r.Max {
+		return []string{fmt.Sprintf("letter is %d characters long, maximum is %d", length, r.Max)}
+	}
+	return nil
+}
+
+type LanguageRule struct{}
+
+func (LanguageRule) Name() string { return "language" }
+
+func (LanguageRule) Check(letter string, job *models.JobAd) []string {
+	want := DetectLanguage(job.Name + " " + job.Descrtiption)
+	got := DetectLanguage(letter)
+	if want == "" || got == "" || want == got {
+		return nil
+	}
+	return []string{fmt.Sprintf("letter is written in %q but the vacancy is in %q", got, want)}
+}
+
+// DetectLanguage tells Russian from English text by the share of Cyrillic
+// letters. It returns an empty string when the text has no letters at all.
+func DetectLanguage(text string) string {
+	var cyrillic, latin int
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		}
+	}
+
+	switch {
+	case cyrillic+latin == 0:
+		return ""
+	case cyrillic*2 >= cyrillic+latin:
+		return "ru"
+	default:
+		return "en"
+	}
+}
+
+var placeholderPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`\[[^\]\n]{2,60}\]`),
+	regexp.MustCompile(`\{[^}\n]{2,60}\}`),
+	regexp.MustCompile(`<[^>\n]{2,60}>`),
+	regexp.MustCompile(`(?i)\b(your name|company name|hiring manager name)\b`),
+	regexp.MustCompile(`(?i)(ваше имя|ваши контакты|название компании)`),
+	regexp.MustCompile(`X{3,}`),
+}
+
+type PlaceholderRule struct{}
+
+func (PlaceholderRule) Name() string { return "placeholder" }
+
+func (PlaceholderRule) Check(letter string, job *models.JobAd) []string {
+	var matches []string
+	for _, re := range placeholderPatterns {
+		for _, match := range re.FindAllString(letter, -1) {
+			if !containsPart(matches, match) {
+				matches = append(matches, match)
+			}
+		}
+	}
+
+	messages := make([]string, 0, len(matches))
+	for _, match := range matches {
+		messages = append(messages, fmt.Sprintf("letter contains placeholder %q", match))
+	}
+	return messages
+}
+
+func containsPart(matches []string, match string) bool {
+	for _, m := range matches {
+		if strings.Contains(m, match) {
+			return true
+		}
+	}
+	return false
+}
+
+type BannedPhrasesRule struct {
+	Phrases []string
+}
+
+func (BannedPhrasesRule) Name() string { return "banned_phrase" }
+
+func (r BannedPhrasesRule) Check(letter string, job *models.JobAd) []string {
+	lower := strings.ToLower(letter)
+	var messages []string
+	for _, phrase := range r.Phrases {
+		phrase = strings.ToLower(strings.TrimSpace(phrase))
+		if phrase != "" && strings.Contains(lower, phrase) {
+			messages = append(messages, fmt.Sprintf("letter contains banned phrase %q", phrase))
+		}
+	}
+	return messages
+}
+
+// RequiredMentionsRule checks that every mention appears in the letter.
+// The mentions "{name}" and "{employer}" are replaced with the vacancy title
+// and the employer name.
+type RequiredMentionsRule struct {
+	Mentions []string
+}
+
+func (RequiredMentionsRule) Name() string { return "required_mention" }
+
+func (r RequiredMentionsRule) Check(letter string, job *models.JobAd) []string {
+	lower := strings.ToLower(letter)
+	var messages []string
+	for _, mention := range r.Mentions {
+		mention = strings.NewReplacer("{name}", job.Name, "{employer}", job.Employer.Name).Replace(mention)
+		mention = strings.TrimSpace(mention)
+		if mention != "" && !strings.Contains(lower, strings.ToLower(mention)) {
+			messages = append(messages, fmt.Sprintf("letter does not mention %q", mention))
+		}
+	}
+	return messages
+}
@@ -0,0 +1,81 @@
+package validator_test
+
+import (
+	"hh_bot/models"
+	"hh_bot/validator"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	job := &models.JobAd{
+		Name:         "ML-инженер",
+		Descrtiption: "Мы ищем ML-инженера для работы над рекомендательной системой.",
+	}
+	job.Employer.Name = "Яндекс"
+
+	v := validator.NewDefault(validator.Options{
+		MinLength:        20,
+		MaxLength:        500,
+		RequiredMentions: []string{"{employer}"},
+	})
+
+	tests := []struct {
+		name   string
+		letter string
+		rules  []string
+	}{
+		{
+			name:   "valid letter",
+			letter: "Здравствуйте! Хочу присоединиться к команде Яндекс в роли ML-инженера.",
+		},
+		{
+			name:   "too short",
+			letter: "Яндекс, привет",
+			rules:  []string{"length"},
+		},
+		{
+			name:   "wrong language",
+			letter: "Hello! I would love to join the Яндекс team as an ML engineer.",
+			rules:  []string{"language"},
+		},
+		{
+			name:   "placeholder and preamble",
+			letter: "Вот ваше письмо: Здравствуйте, Яндекс! С уважением, [Ваше имя]",
+			rules:  []string{"placeholder", "banned_phrase"},
+		},
+		{
+			name:   "missing mention",
+			letter: "Здравствуйте! Хочу присоединиться к вашей команде в роли ML-инженера.",
+			rules:  []string{"required_mention"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := v.Validate(tt.letter, job)
+			var got []string
+			for _, violation := range result.Violations {
+				got = append(got, violation.Rule)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.rules, ",") {
+				t.Fatalf("got violations %v, want %v", result.Violations, tt.rules)
+			}
+			if result.Valid != (len(tt.rules) == 0) {
+				t.Fatalf("unexpected valid flag %v", result.Valid)
+			}
+		})
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	if got := validator.DetectLanguage("Data Scientist в команду поиска"); got != "ru" {
+		t.Fatalf("expected ru, got %q", got)
+	}
+	if got := validator.DetectLanguage("Data Scientist, Python, SQL"); got != "en" {
+		t.Fatalf("expected en, got %q", got)
+	}
+	if got := validator.DetectLanguage("123 456"); got != "" {
+		t.Fatalf("expected no language, got %q", got)
+	}
+}